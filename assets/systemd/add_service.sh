@@ -0,0 +1,57 @@
+#!/bin/sh
+# Installs prom-ovs-apiserver as a systemd service. Run it as root from the
+# unpacked dist directory:
+#   sudo ./install.sh
+#
+# The configuration lives in /etc/prom-ovs-apiserver and is kept on upgrade.
+#   systemctl reload prom-ovs-apiserver    re-reads config.yaml (SIGHUP)
+#   systemctl restart prom-ovs-apiserver   drains in-flight requests (SIGTERM)
+set -e
+
+BINARY=prom-ovs-apiserver
+BIN_DIR=/usr/sbin
+CONF_DIR=/etc/${BINARY}
+UNIT_FILE=/etc/systemd/system/${BINARY}.service
+SRC_DIR=$(cd "$(dirname "$0")" && pwd)
+
+if [ "$(id -u)" -ne 0 ]; then
+	echo "run as root" >&2
+	exit 1
+fi
+
+install -m 0755 "${SRC_DIR}/${BINARY}" "${BIN_DIR}/${BINARY}"
+
+mkdir -p "${CONF_DIR}"
+for file in config.yaml queries.yaml; do
+	if [ ! -e "${CONF_DIR}/${file}" ]; then
+		install -m 0644 "${SRC_DIR}/${file}" "${CONF_DIR}/${file}"
+	fi
+done
+
+# TimeoutStopSec must be longer than web.shutdown_timeout so in-flight
+# requests drain before systemd sends SIGKILL.
+cat > "${UNIT_FILE}" <<UNIT
+[Unit]
+Description=Helios OVS metrics API server
+Documentation=https://github.com/kongseokhwan/Helios-prom-client
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=simple
+WorkingDirectory=${CONF_DIR}
+ExecStart=${BIN_DIR}/${BINARY} -config.file=${CONF_DIR}/config.yaml
+ExecReload=/bin/kill -HUP \$MAINPID
+KillSignal=SIGTERM
+TimeoutStopSec=45s
+Restart=on-failure
+RestartSec=5s
+
+[Install]
+WantedBy=multi-user.target
+UNIT
+
+systemctl daemon-reload
+systemctl enable "${BINARY}.service"
+systemctl restart "${BINARY}.service"
+echo "OK: ${BINARY} installed, configuration in ${CONF_DIR}"
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// previousRateQuery is the rate of AvgbyQueryWithRate offset into the past
+const previousRateQuery string = "avg by(bridge, port) (rate(%s[%s] offset %s)*8)" // selector, duration, offset
+
+// commands are the subcommands of ovsctl, completion aside
+var commands = []*command{
+	{name: "top", summary: "Rank the ports by the rate of a metric", setup: setupTop},
+	{name: "count", summary: "Count the ports reporting a metric", setup: setupCount},
+	{name: "range", summary: "Print the rate of a metric per port over the last hour", setup: setupRange},
+	{name: "bridges", summary: "List the bridges", setup: setupBridges},
+	{name: "ports", summary: "List the ports and their bridges", setup: setupPorts},
+	{name: "health", summary: "Check that the Prometheus servers are ready", setup: setupHealth},
+	{name: "compare", summary: "Compare the rate of a metric per port with an earlier one", setup: setupCompare},
+	{name: "__complete", args: "bridge|port", hidden: true, setup: setupComplete},
+}
+
+// metricFlags registers -metric and, when window is set, -window.
+func metricFlags(fs *flag.FlagSet, window bool) (*string, *string) {
+	metric := fs.String("metric", defaultMetric, "Metric to query")
+	if !window {
+		return metric, nil
+	}
+	return metric, fs.String("window", "5m", "rate() window")
+}
+
+func setupTop(fs *flag.FlagSet) runFunc {
+	metric, window := metricFlags(fs, true)
+	rank := fs.Int("rank", 10, "Number of ports to rank")
+	return func(ctx context.Context, c *ovs_prom_client.OVSClient, args []string) (*table, error) {
+		if len(args) > 0 {
+			return nil, errUsage
+		}
+		metrics, warnings, err := c.NtopQueryWithRateContext(ctx, *rank, *metric, *window)
+		if err != nil {
+			return nil, err
+		}
+		t := seriesTable(metrics, false)
+		t.warnings = warnings
+		return t, nil
+	}
+}
+
+func setupCount(fs *flag.FlagSet) runFunc {
+	metric, _ := metricFlags(fs, false)
+	return func(ctx context.Context, c *ovs_prom_client.OVSClient, args []string) (*table, error) {
+		if len(args) > 0 {
+			return nil, errUsage
+		}
+		metrics, warnings, err := c.CountQueryContext(ctx, *metric)
+		if err != nil {
+			return nil, err
+		}
+		sortByLabels(metrics)
+		t := seriesTable(metrics, false)
+		t.warnings = warnings
+		return t, nil
+	}
+}
+
+func setupRange(fs *flag.FlagSet) runFunc {
+	metric, window := metricFlags(fs, true)
+	return func(ctx context.Context, c *ovs_prom_client.OVSClient, args []string) (*table, error) {
+		if len(args) > 0 {
+			return nil, errUsage
+		}
+		metrics, warnings, err := c.AvgbyQueryWithRateContext(ctx, *metric, *window)
+		if err != nil {
+			return nil, err
+		}
+		sortByLabels(metrics)
+		t := seriesTable(metrics, true)
+		t.warnings = warnings
+		return t, nil
+	}
+}
+
+func setupBridges(fs *flag.FlagSet) runFunc {
+	metric, _ := metricFlags(fs, false)
+	return func(ctx context.Context, c *ovs_prom_client.OVSClient, args []string) (*table, error) {
+		if len(args) > 0 {
+			return nil, errUsage
+		}
+		bridges, warnings, err := c.LabelValuesContext(ctx, "bridge", *metric)
+		if err != nil {
+			return nil, err
+		}
+		t := &table{columns: []string{"bridge"}, warnings: warnings}
+		for _, bridge := range bridges {
+			t.rows = append(t.rows, []interface{}{bridge})
+		}
+		return t, nil
+	}
+}
+
+func setupPorts(fs *flag.FlagSet) runFunc {
+	metric, _ := metricFlags(fs, false)
+	return func(ctx context.Context, c *ovs_prom_client.OVSClient, args []string) (*table, error) {
+		if len(args) > 0 {
+			return nil, errUsage
+		}
+		series, warnings, err := c.SeriesContext(ctx, *metric)
+		if err != nil {
+			return nil, err
+		}
+
+		columns := []string{"bridge", "port"}
+		for _, s := range series {
+			if _, ok := s.Labels[ovs_prom_client.SourceLabel]; ok {
+				columns = append(columns, ovs_prom_client.SourceLabel)
+				break
+			}
+		}
+		t := &table{columns: columns, warnings: warnings}
+		seen := make(map[string]bool)
+		for _, s := range series {
+			row := make([]interface{}, len(columns))
+			cells := make([]string, len(columns))
+			for i, name := range columns {
+				row[i], cells[i] = s.Labels[name], s.Labels[name]
+			}
+			if key := strings.Join(cells, "\x00"); !seen[key] {
+				seen[key] = true
+				t.rows = append(t.rows, row)
+			}
+		}
+		sort.Slice(t.rows, func(i, j int) bool { return lessRow(t.rows[i], t.rows[j]) })
+		return t, nil
+	}
+}
+
+func setupHealth(fs *flag.FlagSet) runFunc {
+	return func(ctx context.Context, c *ovs_prom_client.OVSClient, args []string) (*table, error) {
+		if len(args) > 0 {
+			return nil, errUsage
+		}
+		t := &table{columns: []string{"upstream", "ready", "error"}}
+		unready := 0
+		statuses := c.Ready(ctx)
+		for _, s := range statuses {
+			name := s.Name
+			if name == "" {
+				name = c.Host + ":" + c.Port
+			}
+			if !s.Ready {
+				unready++
+			}
+			t.rows = append(t.rows, []interface{}{name, s.Ready, s.Error})
+		}
+		if unready > 0 {
+			return t, fmt.Errorf("%d of %d Prometheus servers are not ready", unready, len(statuses))
+		}
+		return t, nil
+	}
+}
+
+func setupCompare(fs *flag.FlagSet) runFunc {
+	metric, window := metricFlags(fs, true)
+	offset := fs.String("offset", "1d", "How long ago the earlier rate was")
+	rank := fs.Int("rank", 0, "Only the ports whose rate changed the most, 0 for all")
+	return func(ctx context.Context, c *ovs_prom_client.OVSClient, args []string) (*table, error) {
+		if len(args) > 0 {
+			return nil, errUsage
+		}
+		if _, err := ovs_prom_client.ParseDuration(*offset); err != nil {
+			return nil, err
+		}
+		if *rank != 0 {
+			if err := ovs_prom_client.ValidateRank(*rank, 0); err != nil {
+				return nil, err
+			}
+		}
+
+		now, warnings, err := c.RateQueryContext(ctx, *metric, *window)
+		if err != nil {
+			return nil, err
+		}
+		before, earlierWarnings, err := previousRates(ctx, c, *metric, *window, *offset)
+		if err != nil {
+			return nil, err
+		}
+		t := compareRates(before, now)
+		t.warnings = append(warnings, earlierWarnings...)
+		if *rank > 0 && *rank < len(t.rows) {
+			t.rows = t.rows[:*rank]
+		}
+		return t, nil
+	}
+}
+
+// previousRates queries the rates of metric offset into the past. The
+// client cannot offset its built-in queries, so the filters are applied to
+// the selector here.
+func previousRates(ctx context.Context, c *ovs_prom_client.OVSClient, metric string, window string, offset string) ([]ovs_prom_client.TSMetricObj, v1.Warnings, error) {
+	selector := metric
+	if len(c.Matchers) > 0 {
+		matchers := make([]string, len(c.Matchers))
+		for i, m := range c.Matchers {
+			matchers[i] = m.String()
+		}
+		selector += "{" + strings.Join(matchers, ",") + "}"
+	}
+	unrestricted := *c
+	unrestricted.Matchers = nil
+	return unrestricted.QueryContext(ctx, fmt.Sprintf(previousRateQuery, selector, window, offset))
+}
+
+// compareRates returns a row per port with its rate before, now and the
+// change in percent, ordered by the largest change. Ports missing on one
+// side have no change and come last.
+func compareRates(before []ovs_prom_client.TSMetricObj, now []ovs_prom_client.TSMetricObj) *table {
+	names := labelNames(append(append([]ovs_prom_client.TSMetricObj{}, before...), now...))
+	type pair struct {
+		labels      map[string]string
+		before, now interface{}
+	}
+	pairs := make(map[string]*pair)
+	var keys []string
+	add := func(metrics []ovs_prom_client.TSMetricObj, set func(p *pair, v interface{})) {
+		for _, m := range metrics {
+			cells := make([]string, len(names))
+			for i, name := range names {
+				cells[i] = m.Labels[name]
+			}
+			key := strings.Join(cells, "\x00")
+			p := pairs[key]
+			if p == nil {
+				p = &pair{labels: m.Labels}
+				pairs[key] = p
+				keys = append(keys, key)
+			}
+			if len(m.Vals) > 0 {
+				set(p, sampleValue(m.Vals[len(m.Vals)-1]))
+			}
+		}
+	}
+	add(before, func(p *pair, v interface{}) { p.before = v })
+	add(now, func(p *pair, v interface{}) { p.now = v })
+
+	t := &table{columns: append(append([]string{}, names...), "before", "now", "change")}
+	for _, key := range keys {
+		p := pairs[key]
+		row := make([]interface{}, 0, len(t.columns))
+		for _, name := range names {
+			row = append(row, p.labels[name])
+		}
+		var change interface{}
+		b, okBefore := p.before.(float64)
+		n, okNow := p.now.(float64)
+		if okBefore && okNow && b != 0 {
+			change = (n - b) / b * 100
+		}
+		t.rows = append(t.rows, append(row, p.before, p.now, change))
+	}
+
+	last := len(t.columns) - 1
+	sort.SliceStable(t.rows, func(i, j int) bool {
+		a, aok := t.rows[i][last].(float64)
+		b, bok := t.rows[j][last].(float64)
+		if aok != bok {
+			return aok
+		}
+		if aok && math.Abs(a) != math.Abs(b) {
+			return math.Abs(a) > math.Abs(b)
+		}
+		return lessRow(t.rows[i], t.rows[j])
+	})
+	return t
+}
+
+// lessRow orders rows by their text cells.
+func lessRow(a []interface{}, b []interface{}) bool {
+	for i := range a {
+		sa, aok := a[i].(string)
+		sb, bok := b[i].(string)
+		if aok && bok && sa != sb {
+			return sa < sb
+		}
+	}
+	return false
+}
+
+func setupComplete(fs *flag.FlagSet) runFunc {
+	return func(ctx context.Context, c *ovs_prom_client.OVSClient, args []string) (*table, error) {
+		if len(args) != 1 || (args[0] != "bridge" && args[0] != "port") {
+			return nil, errUsage
+		}
+		values, _, err := c.LabelValuesContext(ctx, args[0], "")
+		if err != nil {
+			return nil, err
+		}
+		t := &table{columns: []string{args[0]}, bare: true}
+		for _, v := range values {
+			t.rows = append(t.rows, []interface{}{v})
+		}
+		return t, nil
+	}
+}
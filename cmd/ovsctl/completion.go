@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// bashCompletion completes the commands and their flags, and the values of
+// -bridge and -port from Prometheus through `ovsctl __complete`. Ports are
+// those of the bridges already given. %[1]s holds the commands.
+const bashCompletion string = `# ovsctl completion, e.g. in ~/.bashrc:
+#   source <(ovsctl completion bash)
+# Set OVSCTL_PROMETHEUS to complete from another server than localhost:9090.
+_ovsctl() {
+	local cur prev
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	if [ "$COMP_CWORD" -eq 1 ]; then
+		COMPREPLY=($(compgen -W "%[1]s completion" -- "$cur"))
+		return
+	fi
+	if [ "${COMP_WORDS[1]}" = completion ]; then
+		COMPREPLY=($(compgen -W "bash zsh" -- "$cur"))
+		return
+	fi
+
+	# COMP_WORDBREAKS splits -bridge=br-int into -bridge, = and br-int
+	local flag= value= prefix= bridges= i
+	case "$cur" in
+	=) flag="$prev" prefix="=" ;;
+	-*) ;;
+	*) if [ "$prev" = "=" ]; then flag="${COMP_WORDS[COMP_CWORD-2]}"; else flag="$prev"; fi
+		value="$cur" ;;
+	esac
+	case "$flag" in
+	-bridge|--bridge) flag=bridge ;;
+	-port|--port) flag=port ;;
+	*)
+		if [[ "$cur" == -* ]]; then
+			COMPREPLY=($(compgen -W "$("${COMP_WORDS[0]}" "${COMP_WORDS[1]}" -h 2>&1 | sed -n 's/^  \(-[a-z-]*\).*/\1/p')" -- "$cur"))
+		fi
+		return
+		;;
+	esac
+	# Complete the last of a comma-separated list
+	if [[ "$value" == *,* ]]; then
+		prefix="$prefix${value%%,*},"
+		value="${value##*,}"
+	fi
+
+	# Ports of the bridges given before
+	for ((i = 2; i < COMP_CWORD - 1; i++)); do
+		case "$flag ${COMP_WORDS[i]}" in
+		"port -bridge"|"port --bridge")
+			if [ "${COMP_WORDS[i+1]}" = "=" ]; then bridges="${COMP_WORDS[i+2]}"; else bridges="${COMP_WORDS[i+1]}"; fi ;;
+		esac
+	done
+	local values
+	values="$("${COMP_WORDS[0]}" __complete ${bridges:+-bridge="$bridges"} "$flag" 2>/dev/null)"
+	COMPREPLY=($(compgen -P "$prefix" -W "$values" -- "$value"))
+}
+complete -o default -F _ovsctl ovsctl
+`
+
+// zshCompletion runs the bash completion through bashcompinit
+const zshCompletion string = `# ovsctl completion, e.g. in ~/.zshrc:
+#   source <(ovsctl completion zsh)
+autoload -U +X bashcompinit && bashcompinit
+`
+
+func runCompletion(args []string, stdout io.Writer, stderr io.Writer) error {
+	var names []string
+	for _, cmd := range commands {
+		if !cmd.hidden {
+			names = append(names, cmd.name)
+		}
+	}
+	script := fmt.Sprintf(bashCompletion, strings.Join(names, " "))
+
+	switch {
+	case len(args) == 1 && args[0] == "bash":
+		_, err := io.WriteString(stdout, script)
+		return err
+	case len(args) == 1 && args[0] == "zsh":
+		_, err := io.WriteString(stdout, zshCompletion+script)
+		return err
+	default:
+		fmt.Fprintln(stderr, "Usage: ovsctl completion bash|zsh")
+		return errUsage
+	}
+}
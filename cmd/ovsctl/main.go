@@ -0,0 +1,242 @@
+// Command ovsctl queries the OVS metrics in Prometheus from a shell, through
+// the same client as the API server, e.g.
+//
+//	ovsctl top -metric=ovs_interface_transmit_bytes_total -window=1m -rank=5
+//	ovsctl ports -bridge=br-int -output=csv
+//	ovsctl compare -offset=1d -filter=tenant=acme
+//
+// Every command prints an aligned table, JSON or CSV. `ovsctl completion
+// bash` prints a completion script that also completes bridge and port names.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+)
+
+// EnvPrometheus is the default of -prometheus, so that completion queries
+// the same server
+const EnvPrometheus string = "OVSCTL_PROMETHEUS"
+
+// defaultMetric is the metric of the commands without -metric
+const defaultMetric string = "ovs_interface_receive_bytes_total"
+
+// errUsage is returned for invalid flags and arguments, after the usage
+// has been printed
+var errUsage = errors.New("invalid usage")
+
+// command is a subcommand of ovsctl. setup registers the flags of the
+// command and returns what runs it once they are parsed.
+type command struct {
+	name    string
+	args    string
+	summary string
+	hidden  bool
+	setup   func(fs *flag.FlagSet) runFunc
+}
+
+// runFunc runs a command and returns its result. A command may return a
+// table and an error, e.g. health with unready upstreams.
+type runFunc func(ctx context.Context, c *ovs_prom_client.OVSClient, args []string) (*table, error)
+
+// filters are label=value flags, repeatable and comma-separated
+type filters map[string][]string
+
+// Set implements flag.Value.
+func (f filters) Set(s string) error {
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || ovs_prom_client.ValidateLabel(name) != nil {
+			return fmt.Errorf("%q is not label=value", entry)
+		}
+		f[name] = append(f[name], value)
+	}
+	return nil
+}
+
+func (f filters) String() string {
+	var entries []string
+	for name, values := range f {
+		for _, v := range values {
+			entries = append(entries, name+"="+v)
+		}
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// options are the flags of every command
+type options struct {
+	prometheus string
+	upstreams  config.Upstreams
+	timeout    time.Duration
+	output     string
+	bridges    config.Strings
+	ports      config.Strings
+	filters    filters
+}
+
+func (o *options) register(fs *flag.FlagSet, getenv func(string) string) {
+	o.prometheus = "localhost:9090"
+	if v := getenv(EnvPrometheus); v != "" {
+		o.prometheus = v
+	}
+	o.filters = make(filters)
+
+	fs.StringVar(&o.prometheus, "prometheus", o.prometheus, "Prometheus server as host:port, $"+EnvPrometheus+" by default")
+	fs.Var(&o.upstreams, "upstreams", "Named Prometheus servers as name=host:port,... (replaces -prometheus)")
+	fs.DurationVar(&o.timeout, "timeout", ovs_prom_client.DefaultTimeout, "Timeout of a single Prometheus query")
+	fs.StringVar(&o.output, "output", formatTable, "Output format: table, json or csv")
+	fs.Var(&o.bridges, "bridge", "Only these bridges, comma-separated")
+	fs.Var(&o.ports, "port", "Only these ports, comma-separated")
+	fs.Var(o.filters, "filter", "Only series with label=value, repeatable, e.g. tenant=acme")
+}
+
+// matchers returns the restrictions of the filter flags.
+func (o *options) matchers() []ovs_prom_client.Matcher {
+	values := make(map[string][]string, len(o.filters)+2)
+	for name, v := range o.filters {
+		values[name] = append(values[name], v...)
+	}
+	values["bridge"] = append(values["bridge"], o.bridges...)
+	values["port"] = append(values["port"], o.ports...)
+
+	var matchers []ovs_prom_client.Matcher
+	for name, v := range values {
+		if len(v) > 0 {
+			matchers = append(matchers, ovs_prom_client.Matcher{Name: name, Values: v})
+		}
+	}
+	sort.Slice(matchers, func(i, j int) bool { return matchers[i].Name < matchers[j].Name })
+	return matchers
+}
+
+// client returns a client of the Prometheus servers restricted by the
+// filter flags.
+func (o *options) client() (*ovs_prom_client.OVSClient, error) {
+	host, port, err := net.SplitHostPort(o.prometheus)
+	if err != nil {
+		return nil, fmt.Errorf("-prometheus: %q is not host:port", o.prometheus)
+	}
+	c, err := ovs_prom_client.NewOVSPClilent(host, port, "v1")
+	if err != nil {
+		return nil, err
+	}
+	c.Timeout = o.timeout
+	for _, up := range o.upstreams {
+		c.AddUpstream(up.Name, up.Host, up.Port)
+	}
+	c.Matchers = o.matchers()
+	return c, nil
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err := run(ctx, os.Args[1:], os.Getenv, os.Stdout, os.Stderr)
+	stop()
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "ovsctl:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command named by the first of args.
+func run(ctx context.Context, args []string, getenv func(string) string, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return errUsage
+		}
+		return flag.ErrHelp
+	}
+	if args[0] == "completion" {
+		return runCompletion(args[1:], stdout, stderr)
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		usage(stderr)
+		return errUsage
+	}
+
+	var o options
+	fs := flag.NewFlagSet("ovsctl "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: ovsctl %s\n\n%s.\n\nFlags:\n", strings.TrimSpace(cmd.name+" [flags] "+cmd.args), cmd.summary)
+		fs.PrintDefaults()
+	}
+	o.register(fs, getenv)
+	runCmd := cmd.setup(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if !validFormat(o.output) {
+		fmt.Fprintf(stderr, "-output: %q is not one of table, json or csv\n", o.output)
+		return errUsage
+	}
+
+	c, err := o.client()
+	if err != nil {
+		return err
+	}
+	t, err := runCmd(ctx, c, fs.Args())
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+		return err
+	}
+	if t != nil {
+		for _, w := range t.warnings {
+			fmt.Fprintln(stderr, "warning:", w)
+		}
+		if werr := t.write(stdout, o.output); werr != nil {
+			return werr
+		}
+	}
+	return err
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: ovsctl <command> [flags] [arguments]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands {
+		if !cmd.hidden {
+			fmt.Fprintf(w, "  %-11s %s\n", cmd.name, cmd.summary)
+		}
+	}
+	fmt.Fprintf(w, "  %-11s %s\n", "completion", "Print the bash or zsh completion script")
+	fmt.Fprintln(w, "\nRun 'ovsctl <command> -h' for the flags of a command.")
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+)
+
+// Output formats
+const (
+	formatTable string = "table"
+	formatJSON  string = "json"
+	formatCSV   string = "csv"
+)
+
+// Columns every series table ends with
+const (
+	columnValue     string = "value"
+	columnTimestamp string = "timestamp"
+)
+
+// table is the result of a command. Cells are strings, float64, bool,
+// time.Time or nil for a missing value. A bare table is written as its
+// cells, one per line, whatever the format, e.g. for shell completion.
+type table struct {
+	columns  []string
+	rows     [][]interface{}
+	warnings []string
+	bare     bool
+}
+
+func validFormat(format string) bool {
+	switch format {
+	case formatTable, formatJSON, formatCSV:
+		return true
+	}
+	return false
+}
+
+// write writes t in format: aligned columns, a JSON array of objects or CSV
+// with a header.
+func (t *table) write(w io.Writer, format string) error {
+	if t.bare {
+		for _, row := range t.rows {
+			for _, cell := range row {
+				if _, err := fmt.Fprintln(w, formatCell(cell, false)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	switch format {
+	case formatJSON:
+		objects := make([]map[string]interface{}, len(t.rows))
+		for i, row := range t.rows {
+			objects[i] = make(map[string]interface{}, len(t.columns))
+			for j, column := range t.columns {
+				objects[i][column] = jsonCell(row[j])
+			}
+		}
+		out, err := json.MarshalIndent(objects, "", "\t\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(t.columns); err != nil {
+			return err
+		}
+		record := make([]string, len(t.columns))
+		for _, row := range t.rows {
+			for j, cell := range row {
+				record[j] = formatCell(cell, false)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(t.columns, "\t")))
+		cells := make([]string, len(t.columns))
+		for _, row := range t.rows {
+			for j, cell := range row {
+				cells[j] = formatCell(cell, true)
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+		return tw.Flush()
+	}
+}
+
+// formatCell returns cell as text, rounded for a human when short is set.
+func formatCell(cell interface{}, short bool) string {
+	switch v := cell.(type) {
+	case nil:
+		if short {
+			return "-"
+		}
+		return ""
+	case float64:
+		if short && !math.IsNaN(v) && !math.IsInf(v, 0) && v != math.Trunc(v) {
+			return strconv.FormatFloat(v, 'f', 2, 64)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// jsonCell returns what encoding/json can write for cell: NaN and
+// infinities are kept as strings.
+func jsonCell(cell interface{}) interface{} {
+	if v, ok := cell.(float64); ok && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return cell
+}
+
+// labelNames returns the label names of metrics, bridge and port first and
+// the others sorted.
+func labelNames(metrics []ovs_prom_client.TSMetricObj) []string {
+	seen := make(map[string]bool)
+	for _, metric := range metrics {
+		for name := range metric.Labels {
+			if name != "__name__" {
+				seen[name] = true
+			}
+		}
+	}
+
+	var names []string
+	for _, name := range []string{"bridge", "port"} {
+		if seen[name] {
+			names = append(names, name)
+			delete(seen, name)
+		}
+	}
+	rest := make([]string, 0, len(seen))
+	for name := range seen {
+		rest = append(rest, name)
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+// seriesTable returns a row per series with its latest sample, or a row per
+// sample when all is set.
+func seriesTable(metrics []ovs_prom_client.TSMetricObj, all bool) *table {
+	names := labelNames(metrics)
+	t := &table{columns: append(append([]string{}, names...), columnValue, columnTimestamp)}
+	for _, metric := range metrics {
+		first := 0
+		if !all && len(metric.Vals) > 0 {
+			first = len(metric.Vals) - 1
+		}
+		for i := first; i < len(metric.Vals); i++ {
+			row := make([]interface{}, 0, len(t.columns))
+			for _, name := range names {
+				row = append(row, metric.Labels[name])
+			}
+			var ts string
+			if i < len(metric.TimeSeries) {
+				ts = metric.TimeSeries[i]
+			}
+			row = append(row, sampleValue(metric.Vals[i]), sampleTime(ts))
+			t.rows = append(t.rows, row)
+		}
+	}
+	return t
+}
+
+// sampleValue returns a sample as a number, or as it is when it is none.
+func sampleValue(s string) interface{} {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return s
+	}
+	return v
+}
+
+// sampleTime returns a Unix timestamp like "1600000000.123" as a time, or
+// as it is when it is none.
+func sampleTime(s string) interface{} {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return s
+	}
+	return time.UnixMilli(int64(math.Round(secs * 1000))).UTC()
+}
+
+// sortByLabels orders metrics by their labels, for results without an order
+// of their own.
+func sortByLabels(metrics []ovs_prom_client.TSMetricObj) {
+	names := labelNames(metrics)
+	sort.SliceStable(metrics, func(i, j int) bool {
+		for _, name := range names {
+			if a, b := metrics[i].Labels[name], metrics[j].Labels[name]; a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+}
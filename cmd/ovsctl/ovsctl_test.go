@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePrometheus answers the query, discovery and readiness APIs with fixed
+// results and records the requests.
+type fakePrometheus struct {
+	*httptest.Server
+	mu      sync.Mutex
+	queries []string
+}
+
+func newFakePrometheus(t *testing.T) *fakePrometheus {
+	p := &fakePrometheus{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		p.queries = append(p.queries, r.URL.Path+" "+r.Form.Get("query")+r.Form.Get("match[]"))
+		p.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/-/ready":
+			w.Write([]byte("Prometheus Server is Ready.\n"))
+		case r.URL.Path == "/api/v1/series":
+			w.Write([]byte(`{"status":"success","data":[` +
+				`{"__name__":"ovs_interface_receive_bytes_total","bridge":"br-int","port":"vm2"},` +
+				`{"__name__":"ovs_interface_receive_bytes_total","bridge":"br-ex","port":"eth0"},` +
+				`{"__name__":"ovs_interface_receive_bytes_total","bridge":"br-int","port":"vm2","instance":"b"}]}`))
+		case strings.HasPrefix(r.URL.Path, "/api/v1/label/"):
+			w.Write([]byte(`{"status":"success","data":["br-ex","br-int"]}`))
+		case r.URL.Path == "/api/v1/query_range":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[` +
+				`{"metric":{"bridge":"br-int","port":"vm1"},"values":[[1600000000,"8"],[1600000060,"16"]]}]}}`))
+		case strings.Contains(r.Form.Get("query"), "offset"):
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` +
+				`{"metric":{"bridge":"br-int","port":"vm1"},"value":[1600000000,"100"]},` +
+				`{"metric":{"bridge":"br-int","port":"vm2"},"value":[1600000000,"100"]}]}}`))
+		default:
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` +
+				`{"metric":{"bridge":"br-int","port":"vm1"},"value":[1600000000,"150.125"]},` +
+				`{"metric":{"bridge":"br-int","port":"vm2"},"value":[1600000000,"90"]},` +
+				`{"metric":{"bridge":"br-ex","port":"eth0"},"value":[1600000000,"5"]}]}}`))
+		}
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakePrometheus) lastQuery() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queries[len(p.queries)-1]
+}
+
+// runCommand runs ovsctl against p and returns its output.
+func runCommand(t *testing.T, p *fakePrometheus, args ...string) (string, error) {
+	t.Helper()
+	getenv := func(name string) string {
+		if name == EnvPrometheus {
+			return strings.TrimPrefix(p.URL, "http://")
+		}
+		return ""
+	}
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), args, getenv, &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestTop(t *testing.T) {
+	p := newFakePrometheus(t)
+
+	out, err := runCommand(t, p, "top", "-rank=2", "-window=1m", "-bridge=br-int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := p.lastQuery(); !strings.Contains(q, `topk(2,`) || !strings.Contains(q, `{bridge=~"br-int"}[1m]`) {
+		t.Errorf("unexpected query %s", q)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "BRIDGE  PORT  VALUE") || !strings.Contains(lines[1], "vm1   150.12") {
+		t.Errorf("unexpected table:\n%s", out)
+	}
+
+	out, err = runCommand(t, p, "top", "-output=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &rows); err != nil || len(rows) != 3 || rows[0]["port"] != "vm1" || rows[0]["value"] != 150.125 {
+		t.Errorf("unexpected JSON %v:\n%s", err, out)
+	}
+
+	out, err = runCommand(t, p, "top", "-output=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil || len(records) != 4 || strings.Join(records[0], ",") != "bridge,port,value,timestamp" || records[1][3] != "2020-09-13T12:26:40Z" {
+		t.Errorf("unexpected CSV %v:\n%s", err, out)
+	}
+
+	if _, err := runCommand(t, p, "top", "-output=xml"); err != errUsage {
+		t.Errorf("expected an unknown format to fail, got %v", err)
+	}
+	if _, err := runCommand(t, p, "top", "-window=5x"); err == nil {
+		t.Error("expected an invalid window to fail")
+	}
+}
+
+func TestRangeAndCount(t *testing.T) {
+	p := newFakePrometheus(t)
+
+	out, err := runCommand(t, p, "range", "-output=csv", "-filter=tenant=acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := p.lastQuery(); !strings.Contains(q, `{tenant=~"acme"}`) {
+		t.Errorf("expected the filter in the query, got %s", q)
+	}
+	if !strings.Contains(out, "br-int,vm1,8,2020-09-13T12:26:40Z\nbr-int,vm1,16,2020-09-13T12:27:40Z") {
+		t.Errorf("expected a row per sample:\n%s", out)
+	}
+
+	if _, err := runCommand(t, p, "count", "-metric=ovs_interface_receive_drop_total"); err != nil {
+		t.Fatal(err)
+	}
+	if q := p.lastQuery(); !strings.Contains(q, "count(count by (bridge, port)(ovs_interface_receive_drop_total))") {
+		t.Errorf("unexpected query %s", q)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	p := newFakePrometheus(t)
+
+	out, err := runCommand(t, p, "bridges")
+	if err != nil || out != "BRIDGE\nbr-ex\nbr-int\n" {
+		t.Errorf("unexpected bridges %v:\n%s", err, out)
+	}
+
+	out, err = runCommand(t, p, "ports", "-output=csv")
+	if err != nil || out != "bridge,port\nbr-ex,eth0\nbr-int,vm2\n" {
+		t.Errorf("unexpected ports %v:\n%s", err, out)
+	}
+
+	out, err = runCommand(t, p, "__complete", "-bridge=br-int", "port")
+	if err != nil || out != "br-ex\nbr-int\n" {
+		t.Errorf("unexpected completion %v:\n%s", err, out)
+	}
+	if q := p.lastQuery(); !strings.Contains(q, "/api/v1/label/port/values") || !strings.Contains(q, `{bridge=~"br-int"}`) {
+		t.Errorf("expected ports of br-int, got %s", q)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	p := newFakePrometheus(t)
+
+	out, err := runCommand(t, p, "compare", "-offset=1h", "-output=json", "-port=vm1,vm2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := p.lastQuery(); !strings.Contains(q, `rate(ovs_interface_receive_bytes_total{port=~"vm1|vm2"}[5m] offset 1h)`) {
+		t.Errorf("unexpected query %s", q)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &rows); err != nil || len(rows) != 3 {
+		t.Fatalf("unexpected JSON %v:\n%s", err, out)
+	}
+	// Largest change first, the port without an earlier rate last
+	if rows[0]["port"] != "vm1" || rows[0]["change"] != 50.125 || rows[1]["port"] != "vm2" || rows[1]["change"] != -10.0 {
+		t.Errorf("unexpected order %v", rows)
+	}
+	if rows[2]["port"] != "eth0" || rows[2]["before"] != nil || rows[2]["change"] != nil {
+		t.Errorf("expected eth0 without a change, got %v", rows[2])
+	}
+
+	if out, err = runCommand(t, p, "compare", "-rank=1"); err != nil || strings.Count(out, "\n") != 2 {
+		t.Errorf("expected the top change only %v:\n%s", err, out)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	p := newFakePrometheus(t)
+	out, err := runCommand(t, p, "health")
+	if err != nil || !strings.Contains(out, "true") {
+		t.Errorf("expected a ready server %v:\n%s", err, out)
+	}
+
+	p.Close()
+	if out, err = runCommand(t, p, "health"); err == nil || !strings.Contains(out, "false") {
+		t.Errorf("expected an unready server %v:\n%s", err, out)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	p := newFakePrometheus(t)
+	if _, err := runCommand(t, p); err != errUsage {
+		t.Errorf("expected usage without a command, got %v", err)
+	}
+	if _, err := runCommand(t, p, "frobnicate"); err != errUsage {
+		t.Errorf("expected usage for an unknown command, got %v", err)
+	}
+	if _, err := runCommand(t, p, "top", "extra"); err != errUsage {
+		t.Errorf("expected usage for an argument, got %v", err)
+	}
+
+	out, err := runCommand(t, p, "completion", "bash")
+	if err != nil || !strings.Contains(out, "top count range bridges ports health compare completion") || strings.Contains(out, "%!") {
+		t.Errorf("unexpected completion script %v:\n%s", err, out)
+	}
+}
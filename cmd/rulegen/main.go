@@ -0,0 +1,75 @@
+// Command prom-ovs-rules writes a Prometheus rule file with recording rules
+// for the per-port rates, error ratios and utilization of the OVS exporter
+// and standard alerting rules over them, e.g.
+//
+//	prom-ovs-rules -windows=1m,5m,15m -output=/etc/prometheus/ovs-rules.yml
+//
+// The API server reads the recorded series once Prometheus evaluates them.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/rules"
+)
+
+// header introduces the generated file
+const header string = "# Generated by prom-ovs-rules, do not edit.\n"
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(2)
+	}
+}
+
+// run parses args and writes the rule file to stdout or the -output file.
+func run(args []string, stdout io.Writer, stderr io.Writer) error {
+	o := rules.DefaultOptions()
+	windows := config.Strings(o.Windows)
+	var output string
+
+	fs := flag.NewFlagSet("prom-ovs-rules", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Var(&windows, "windows", "rate() windows to record, comma-separated")
+	fs.StringVar(&o.Interval, "interval", o.Interval, "Evaluation interval of the rule groups, the global one of Prometheus when empty")
+	fs.StringVar(&o.AlertWindow, "alert-window", o.AlertWindow, "rate() window of the alerting rules, recorded in any case")
+	fs.StringVar(&o.For, "for", o.For, "How long a threshold must be crossed before its alert fires")
+	fs.StringVar(&o.Severity, "severity", o.Severity, "Severity label of the alerts")
+	fs.Float64Var(&o.ErrorRatio, "error-ratio", o.ErrorRatio, "Error ratio alert threshold, 0 leaves the alert out")
+	fs.Float64Var(&o.DropRatio, "drop-ratio", o.DropRatio, "Drop ratio alert threshold, 0 leaves the alert out")
+	fs.Float64Var(&o.Utilization, "utilization", o.Utilization, "Utilization alert threshold, 0 leaves the alert out")
+	fs.StringVar(&output, "output", "", "File to write, stdout when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	o.Windows = windows
+
+	file, err := rules.Generate(o)
+	if err != nil {
+		return err
+	}
+	out, err := file.Marshal()
+	if err != nil {
+		return err
+	}
+	out = append([]byte(header), out...)
+
+	if output == "" {
+		_, err = stdout.Write(out)
+		return err
+	}
+	if err := os.WriteFile(output, out, 0644); err != nil {
+		return fmt.Errorf("error to write the rules: %v", err)
+	}
+	return nil
+}
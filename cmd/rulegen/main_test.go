@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"-windows=30s,2m", "-utilization=0"}, &stdout, &stderr); err != nil {
+		t.Fatal(err, stderr.String())
+	}
+	out := stdout.String()
+	if !strings.HasPrefix(out, header) || !strings.Contains(out, "bridge_port:ovs_interface_transmit_bytes_total:bits_rate2m") {
+		t.Errorf("unexpected rules:\n%s", out)
+	}
+	if strings.Contains(out, "OVSPortUtilizationHigh") {
+		t.Errorf("expected no utilization alert:\n%s", out)
+	}
+
+	path := filepath.Join(t.TempDir(), "ovs-rules.yml")
+	if err := run([]string{"-output=" + path}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil || !bytes.Contains(b, []byte("OVSPortErrorRatioHigh")) {
+		t.Errorf("unexpected file %s: %v", b, err)
+	}
+
+	if err := run([]string{"-for=soon"}, &stdout, &stderr); err == nil {
+		t.Error("expected an invalid duration to fail")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/enrich"
+)
+
+// getGroupTopkAPIQuery returns a handler ranking the groups of label, e.g.
+// logical switches, by the summed rate of their ports.
+func getGroupTopkAPIQuery(label string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pathParams := mux.Vars(r)
+		metricID := pathParams[PARAMMETRIC]
+		if !checkMetric(w, r, metricID) {
+			return
+		}
+
+		durationID, ok := checkDuration(w, r, pathParams[PARAMDURATION])
+		if !ok {
+			return
+		}
+		rankID, ok := checkRank(w, r, pathParams[PARAMRANK])
+		if !ok {
+			return
+		}
+
+		format, err := negotiateFormat(r)
+		if err != nil {
+			writeError(w, r, http.StatusNotAcceptable, codeUnsupportedFormat, err.Error(), r.URL.Query().Get(PARAMFORMAT))
+			return
+		}
+
+		c, err := newOVSClient(r.Context())
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "error to create OVSClient")
+			return
+		}
+
+		/*
+			1. Call OVSClient API : RateQueryContext(ctx, metric string, duration string) ([]TSMetricObj, Warnings, error)
+			2. Sum the enriched port rates by label and keep the top rankID
+			3. Write the result in the negotiated format
+		*/
+		queryResult, warnings, err := c.RateQueryContext(r.Context(), metricID, durationID)
+		if err != nil {
+			writeUpstreamError(w, r, err, warnings)
+			return
+		}
+		queryResult = enrich.TopK(enrich.Aggregate(queryResult, label), rankID)
+
+		if err := writeMetrics(w, format, queryResult, warnings); err != nil {
+			logger.ErrorContext(r.Context(), "error to write response", "format", format, "err", err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/alert"
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/saved"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// alertRules keeps the alert rules next to the saved queries
+var alertRules *saved.Collection[alert.Rule, *alert.Rule]
+
+// evaluator evaluates alertRules in the background
+var evaluator *alert.Evaluator
+
+// AlertRules is JSON response struct of the alert rule list
+type AlertRules struct {
+	Rules []*alert.Rule `json:"rules"`
+}
+
+// Alerts is JSON response struct of the pending and firing alerts
+type Alerts struct {
+	Alerts []alert.Alert `json:"alerts"`
+}
+
+// newEvaluator returns an evaluator of alertRules that queries through an
+// unrestricted OVSClient and notifies the webhooks and Alertmanagers of
+// conf. Alertmanagers are reminded of firing alerts until ctx is done.
+func newEvaluator(ctx context.Context, conf config.AlertingConfig) *alert.Evaluator {
+	var notifiers alert.Notifiers
+	for _, url := range conf.WebhookURLs {
+		notifiers = append(notifiers, alert.NewWebhook(url, conf.WebhookRetries, time.Duration(conf.WebhookTimeout)))
+	}
+	var alertmanagers []*alert.Alertmanager
+	for _, url := range conf.AlertmanagerURLs {
+		am := alert.NewAlertmanager(url, conf.WebhookRetries, time.Duration(conf.WebhookTimeout))
+		am.ResendInterval = time.Duration(conf.ResendInterval)
+		am.ExternalURL = conf.ExternalURL
+		alertmanagers = append(alertmanagers, am)
+		notifiers = append(notifiers, am)
+	}
+	query := func(ctx context.Context, q string) ([]ovs_prom_client.TSMetricObj, v1.Warnings, error) {
+		c, err := newOVSClient(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.QueryContext(ctx, q)
+	}
+	e := alert.NewEvaluator(alertRules.List, query, notifiers)
+	e.Logger = logger
+	for _, am := range alertmanagers {
+		go am.Run(ctx, e.Alerts, func(err error) {
+			logger.Error("error to resend alerts", "err", err)
+		})
+	}
+	return e
+}
+
+// checkAlerting rejects principals restricted by the policy: rules see and
+// notify about every port.
+func checkAlerting(w http.ResponseWriter, r *http.Request) bool {
+	if p := principal(r.Context()); p != nil && p.Scope.Restricted() {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "alerting needs an unrestricted principal", p.Name)
+		return false
+	}
+	return true
+}
+
+// checkRule validates a rule against the server limits.
+func checkRule(rule *alert.Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	if rule.Window != "" {
+		if _, err := validateDuration(rule.Window); err != nil {
+			return &saved.ValidationError{Field: "window", Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+func listAlertRules(w http.ResponseWriter, r *http.Request) {
+	if !checkAlerting(w, r) {
+		return
+	}
+	rules, err := alertRules.List()
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	writeResource(w, r, http.StatusOK, &AlertRules{Rules: rules})
+}
+
+func getAlertRule(w http.ResponseWriter, r *http.Request) {
+	if !checkAlerting(w, r) {
+		return
+	}
+	rule, err := alertRules.Get(mux.Vars(r)[PARAMID])
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	writeResource(w, r, http.StatusOK, rule)
+}
+
+func createAlertRule(w http.ResponseWriter, r *http.Request) {
+	if !checkAlerting(w, r) {
+		return
+	}
+	var rule alert.Rule
+	if !readResource(w, r, &rule) {
+		return
+	}
+	if name := owner(r); name != "" {
+		rule.Owner = name
+	}
+
+	err := checkRule(&rule)
+	if err == nil {
+		err = alertRules.Create(&rule)
+	}
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/alert_rules/"+rule.ID)
+	writeResource(w, r, http.StatusCreated, &rule)
+}
+
+func updateAlertRule(w http.ResponseWriter, r *http.Request) {
+	if !checkAlerting(w, r) {
+		return
+	}
+	var rule alert.Rule
+	if !readResource(w, r, &rule) {
+		return
+	}
+	rule.ID = mux.Vars(r)[PARAMID]
+
+	err := checkRule(&rule)
+	if err == nil {
+		err = alertRules.Update(&rule, owner(r))
+	}
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	writeResource(w, r, http.StatusOK, &rule)
+}
+
+func deleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	if !checkAlerting(w, r) {
+		return
+	}
+	if err := alertRules.Delete(mux.Vars(r)[PARAMID], owner(r)); err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listAlerts(w http.ResponseWriter, r *http.Request) {
+	if !checkAlerting(w, r) {
+		return
+	}
+	writeResource(w, r, http.StatusOK, &Alerts{Alerts: evaluator.Alerts()})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/alert"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/saved"
+)
+
+func TestAlertRules(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []alert.WebhookPayload
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p alert.WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		payloads = append(payloads, p)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+	var posted [][]alert.PostableAlert
+	alertmanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alerts []alert.PostableAlert
+		if r.URL.Path != alert.AlertmanagerPath || json.NewDecoder(r.Body).Decode(&alerts) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		posted = append(posted, alerts)
+		mu.Unlock()
+	}))
+	defer alertmanager.Close()
+
+	_, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` +
+			`{"metric":{"bridge":"br-int","port":"p1"},"value":[1600000000,"0.05"]},` +
+			`{"metric":{"bridge":"br-int","port":"p2"},"value":[1600000000,"0.001"]}]}}`))
+	})
+	defer closeProm()
+	library = saved.NewLibrary(saved.NewMemoryStore())
+	alertRules = saved.NewCollection[alert.Rule](saved.NewMemoryStore(), alert.BucketRules)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	evaluator = newEvaluator(ctx, config.AlertingConfig{
+		WebhookURLs:      config.Strings{webhook.URL},
+		WebhookTimeout:   config.Duration(time.Second),
+		AlertmanagerURLs: config.Strings{alertmanager.URL},
+		ResendInterval:   config.Duration(time.Hour),
+	})
+	defer func() { library, alertRules, evaluator = nil, nil, nil }()
+	router := newRouter()
+
+	body := `{"name": "PortErrors", "signal": "error_ratio", "window": "48h", "op": ">", "threshold": 0.01}`
+	rec := serveJSON(router, http.MethodPost, "/api/v1/alert_rules", body, nil)
+	var resp APIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusBadRequest || resp.Error.Details[0] != "window" {
+		t.Fatalf("expected the window above limits.max-duration to fail, got %d: %s", rec.Code, rec.Body)
+	}
+
+	body = strings.Replace(body, "48h", "5m", 1)
+	if rec = serveJSON(router, http.MethodPost, "/api/v1/alert_rules", body, nil); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var rule alert.Rule
+	if err := json.Unmarshal(rec.Body.Bytes(), &rule); err != nil {
+		t.Fatal(err)
+	}
+	rec = serveJSON(router, http.MethodGet, "/api/v1/alert_rules", "", nil)
+	var rules AlertRules
+	if err := json.Unmarshal(rec.Body.Bytes(), &rules); err != nil || len(rules.Rules) != 1 || rules.Rules[0].ID != rule.ID {
+		t.Fatalf("unexpected rules %s", rec.Body)
+	}
+
+	if err := evaluator.Eval(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rec = serveJSON(router, http.MethodGet, "/api/v1/alerts", "", nil)
+	var alerts Alerts
+	if err := json.Unmarshal(rec.Body.Bytes(), &alerts); err != nil || len(alerts.Alerts) != 1 {
+		t.Fatalf("unexpected alerts %s", rec.Body)
+	}
+	if a := alerts.Alerts[0]; a.State != alert.StateFiring || a.RuleID != rule.ID || a.Labels["port"] != "p1" || a.Labels["alertname"] != "PortErrors" {
+		t.Errorf("unexpected alert %+v", a)
+	}
+	mu.Lock()
+	if len(payloads) != 1 || len(payloads[0].Alerts) != 1 || payloads[0].Alerts[0].Labels["port"] != "p1" {
+		t.Errorf("expected the webhook to receive p1, got %+v", payloads)
+	}
+	if len(posted) != 1 || len(posted[0]) != 1 || posted[0][0].Labels["port"] != "p1" || posted[0][0].Labels["severity"] != "warning" || !posted[0][0].EndsAt.After(posted[0][0].StartsAt) {
+		t.Errorf("expected Alertmanager to receive p1, got %+v", posted)
+	}
+	mu.Unlock()
+
+	if rec = serveJSON(router, http.MethodDelete, "/api/v1/alert_rules/"+rule.ID, "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected delete %d: %s", rec.Code, rec.Body)
+	}
+	if err := evaluator.Eval(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(payloads) != 2 || payloads[1].Alerts[0].State != alert.StateResolved {
+		t.Errorf("expected the alert of the deleted rule to resolve, got %+v", payloads)
+	}
+	if len(posted) != 2 || !posted[1][0].EndsAt.Equal(payloads[1].Alerts[0].ResolvedAt) {
+		t.Errorf("expected Alertmanager to receive endsAt, got %+v", posted)
+	}
+	mu.Unlock()
+}
+
+func TestAlertRulesRestricted(t *testing.T) {
+	router := newTestLibrary(t)
+	alertRules = saved.NewCollection[alert.Rule](saved.NewMemoryStore(), alert.BucketRules)
+	t.Cleanup(func() { alertRules = nil })
+	setTestAuthenticator(t)
+
+	if rec := serveJSON(router, http.MethodGet, "/api/v1/alert_rules", "", map[string]string{HEADERAPIKEY: "wall"}); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a restricted principal, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serveJSON(router, http.MethodGet, "/api/v1/alert_rules", "", map[string]string{HEADERAPIKEY: "admin"}); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for an unrestricted principal, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/auth"
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/enrich"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/filewatch"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// HEADERAPIKEY is API key header
+const HEADERAPIKEY string = "X-API-Key"
+
+// authenticator checks API clients, nil when authentication is disabled
+var authenticator *auth.Authenticator
+
+// publicRoutes are served without authentication
+var publicRoutes = map[string]bool{
+	"/healthz":             true,
+	"/readyz":              true,
+	"/version":             true,
+	"/metrics":             true,
+	"/api/v1/openapi.json": true,
+	"/api/v1/docs":         true,
+}
+
+// publicGRPCServices are served without authentication
+var publicGRPCServices = []string{"/grpc.health.v1.Health/", "/grpc.reflection."}
+
+type principalKey struct{}
+
+// newAuthenticator loads the files of conf and watches them for changes.
+// It returns the reload functions of the files.
+func newAuthenticator(ctx context.Context, conf config.AuthConfig) (*auth.Authenticator, []func() error, error) {
+	a := &auth.Authenticator{}
+	var reloadFiles []func() error
+	onError := func(file string) func(error) {
+		return func(err error) {
+			logger.Error("error to reload auth file", "file", file, "err", err)
+		}
+	}
+
+	if file := conf.APIKeysFile; file != "" {
+		keys, err := auth.NewKeyStore(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		go keys.Watch(ctx, filewatch.DefaultInterval, onError(file))
+		a.Keys = keys
+		reloadFiles = append(reloadFiles, keys.Reload)
+	}
+	if file := conf.JWKSFile; file != "" {
+		v, err := auth.NewJWTVerifier(file, conf.JWTIssuer, conf.JWTAudience)
+		if err != nil {
+			return nil, nil, err
+		}
+		go v.Watch(ctx, filewatch.DefaultInterval, onError(file))
+		a.JWT = v
+		reloadFiles = append(reloadFiles, v.Reload)
+	}
+	if file := conf.PolicyFile; file != "" {
+		p, err := auth.NewPolicy(file, conf.TenantLabel)
+		if err != nil {
+			return nil, nil, err
+		}
+		go p.Watch(ctx, filewatch.DefaultInterval, onError(file))
+		a.Policy = p
+		reloadFiles = append(reloadFiles, p.Reload)
+	}
+	return a, reloadFiles, nil
+}
+
+// authMiddleware requires an API key or a bearer token on every route but
+// the public ones, and attaches the principal to the request.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authenticator == nil || publicRoutes[routeTemplate(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := authenticator.Authenticate(r.Header.Get(HEADERAPIKEY), bearerToken(r.Header.Get("Authorization")))
+		if err != nil {
+			writeAuthError(w, r, p, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), principalKey{}, p)
+		ctx = ovs_prom_client.WithLogAttrs(ctx, slog.String("principal", p.Name))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// writeAuthError sends 403 to a principal the policy does not know and 401
+// otherwise.
+func writeAuthError(w http.ResponseWriter, r *http.Request, p *auth.Principal, err error) {
+	if errors.Is(err, auth.ErrForbidden) {
+		logger.WarnContext(r.Context(), "request denied", "principal", p.Name, "err", err)
+		writeError(w, r, http.StatusForbidden, codeForbidden, err.Error())
+		return
+	}
+	logger.WarnContext(r.Context(), "authentication failed", "err", err)
+	w.Header().Set("WWW-Authenticate", `Bearer realm="helios"`)
+	writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "authentication required")
+}
+
+// bearerToken returns the token of an Authorization header.
+func bearerToken(header string) string {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// principal returns the authenticated client of ctx, if any.
+func principal(ctx context.Context) *auth.Principal {
+	p, _ := ctx.Value(principalKey{}).(*auth.Principal)
+	return p
+}
+
+// scopeMatchers returns the label matchers that limit the queries of the
+// principal of ctx to its scope.
+func scopeMatchers(ctx context.Context) []ovs_prom_client.Matcher {
+	p := principal(ctx)
+	if p == nil || !p.Scope.Restricted() {
+		return nil
+	}
+
+	var matchers []ovs_prom_client.Matcher
+	if len(p.Scope.Bridges) > 0 {
+		matchers = append(matchers, ovs_prom_client.Matcher{Name: enrich.LabelBridge, Values: p.Scope.Bridges})
+	}
+	if len(p.Scope.Ports) > 0 {
+		matchers = append(matchers, ovs_prom_client.Matcher{Name: enrich.LabelPort, Values: p.Scope.Ports})
+	}
+	if len(p.Scope.Tenants) > 0 {
+		matchers = append(matchers, ovs_prom_client.Matcher{Name: authenticator.Policy.TenantLabel, Values: p.Scope.Tenants})
+	}
+	return matchers
+}
+
+// grpcAuthenticate checks the x-api-key or authorization metadata of a call.
+func grpcAuthenticate(ctx context.Context, method string) (context.Context, error) {
+	if authenticator == nil {
+		return ctx, nil
+	}
+	for _, prefix := range publicGRPCServices {
+		if strings.HasPrefix(method, prefix) {
+			return ctx, nil
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	p, err := authenticator.Authenticate(first(strings.ToLower(HEADERAPIKEY)), bearerToken(first("authorization")))
+	if errors.Is(err, auth.ErrForbidden) {
+		logger.WarnContext(ctx, "request denied", "principal", p.Name, "method", method, "err", err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "authentication failed", "method", method, "err", err)
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	ctx = context.WithValue(ctx, principalKey{}, p)
+	return ovs_prom_client.WithLogAttrs(ctx, slog.String("principal", p.Name)), nil
+}
+
+func authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := grpcAuthenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcAuthenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// contextStream is a server stream with the context of the interceptors
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/auth"
+	pb "github.com/kongseokhwan/Helios-prom-client/pkg/heliospb"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/queries"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// setTestAuthenticator enables authentication with the API keys "wall",
+// restricted to a bridge and a tenant, "admin", unrestricted, and "intern",
+// unknown to the policy.
+func setTestAuthenticator(t *testing.T) {
+	dir := t.TempDir()
+	hash := func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	keys, err := auth.NewKeyStore(write("keys.yaml", "keys:\n"+
+		"  - name: noc-wall\n    sha256: "+hash("wall")+"\n"+
+		"  - name: admin\n    sha256: "+hash("admin")+"\n"+
+		"  - name: intern\n    sha256: "+hash("intern")+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := auth.NewPolicy(write("policy.yaml", "principals:\n"+
+		"  noc-wall:\n    bridges: [br-int]\n    tenants: [acme.corp]\n"+
+		"  admin: {}\n"), "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator = &auth.Authenticator{Keys: keys, Policy: policy}
+	t.Cleanup(func() { authenticator = nil })
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	var mu sync.Mutex
+	var promQueries []string
+	_, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		promQueries = append(promQueries, r.Form.Get("query"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"42"]}]}}`))
+	})
+	defer closeProm()
+	setTestAuthenticator(t)
+	router := newRouter()
+
+	file := filepath.Join(t.TempDir(), "queries.yaml")
+	err := ioutil.WriteFile(file, []byte("queries:\n  - name: port_errors\n    query: sum(rate(ovs_interface_receive_errors_total[5m]))\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevQueries := namedQueries
+	defer func() { namedQueries = prevQueries }()
+	if namedQueries, err = queries.NewRegistry(file); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		header map[string]string
+		status int
+		code   string
+		query  string
+	}{
+		{
+			name:   "no credentials",
+			path:   "/api/v1/count/metric/ovs_interface_receive_bytes_total",
+			status: http.StatusUnauthorized,
+			code:   codeUnauthorized,
+		},
+		{
+			name:   "unknown key",
+			path:   "/api/v1/count/metric/ovs_interface_receive_bytes_total",
+			header: map[string]string{HEADERAPIKEY: "guess"},
+			status: http.StatusUnauthorized,
+			code:   codeUnauthorized,
+		},
+		{
+			name:   "principal without policy",
+			path:   "/api/v1/count/metric/ovs_interface_receive_bytes_total",
+			header: map[string]string{HEADERAPIKEY: "intern"},
+			status: http.StatusForbidden,
+			code:   codeForbidden,
+		},
+		{
+			name:   "restricted principal",
+			path:   "/api/v1/count/metric/ovs_interface_receive_bytes_total",
+			header: map[string]string{HEADERAPIKEY: "wall"},
+			status: http.StatusOK,
+			query:  `count(count by (bridge, port)(ovs_interface_receive_bytes_total{bridge=~"br-int",tenant=~"acme\\.corp"}))`,
+		},
+		{
+			name:   "unrestricted principal",
+			path:   "/api/v1/count/metric/ovs_interface_receive_bytes_total",
+			header: map[string]string{HEADERAPIKEY: "admin"},
+			status: http.StatusOK,
+			query:  `count(count by (bridge, port)(ovs_interface_receive_bytes_total))`,
+		},
+		{
+			name:   "named query of a restricted principal",
+			path:   "/api/v1/queries/port_errors",
+			header: map[string]string{HEADERAPIKEY: "wall"},
+			status: http.StatusForbidden,
+			code:   codeForbidden,
+		},
+		{
+			name:   "public route",
+			path:   "/healthz",
+			status: http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mu.Lock()
+			promQueries = nil
+			mu.Unlock()
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rec.Code, rec.Body)
+			}
+			if tc.code != "" {
+				var resp APIErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Error.Code != tc.code {
+					t.Errorf("expected code %s, got %+v", tc.code, resp.Error)
+				}
+			}
+			if tc.status == http.StatusUnauthorized && !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer") {
+				t.Errorf("expected a WWW-Authenticate challenge, got %q", rec.Header().Get("WWW-Authenticate"))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if tc.query != "" && (len(promQueries) != 1 || promQueries[0] != tc.query) {
+				t.Errorf("expected query %s, got %v", tc.query, promQueries)
+			}
+			if tc.query == "" && len(promQueries) != 0 {
+				t.Errorf("unexpected queries %v", promQueries)
+			}
+		})
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	conn := newTestGRPC(t, fakeProm)
+	setTestAuthenticator(t)
+	client := pb.NewOVSMetricsClient(conn)
+	req := &pb.CountRequest{Metric: "ovs_interface_receive_bytes_total"}
+
+	if _, err := client.Count(context.Background(), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "intern")
+	if _, err := client.Count(ctx, req); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "wall")
+	if _, err := client.Count(ctx, req); err != nil {
+		t.Errorf("expected success, got %v", err)
+	}
+	_, err := client.ListSeries(ctx, &pb.ListSeriesRequest{Match: `{__name__=~".+"}`})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a selector of a restricted principal, got %v", err)
+	}
+}
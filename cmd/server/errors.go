@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// HEADERREQUESTID is request ID header, taken from the request when present
+const HEADERREQUESTID string = "X-Request-ID"
+
+// Error codes of APIError
+const (
+	codeInvalidMetric     string = "invalid_metric"
+	codeInvalidDuration   string = "invalid_duration"
+	codeInvalidRank       string = "invalid_rank"
+	codeInvalidParameter  string = "invalid_parameter"
+	codeInvalidQuery      string = "invalid_query"
+	codeUnsupportedFormat string = "unsupported_format"
+	codeUnauthorized      string = "unauthorized"
+	codeForbidden         string = "forbidden"
+	codeNotFound          string = "not_found"
+	codeMethodNotAllowed  string = "method_not_allowed"
+	codeRateLimited       string = "rate_limited"
+	codeOverloaded        string = "overloaded"
+	codeUpstreamError     string = "upstream_error"
+	codeUpstreamTimeout   string = "upstream_timeout"
+	codeInternal          string = "internal_error"
+)
+
+// APIError is JSON error response struct
+type APIError struct {
+	Code       string   `json:"code"`
+	Message    string   `json:"message"`
+	Details    []string `json:"details,omitempty"`
+	Suggestion string   `json:"suggestion,omitempty"`
+	RequestID  string   `json:"request_id,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// APIErrorResponse is JSON response struct of a failed request
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type requestIDKey struct{}
+
+// requestIDMiddleware tags every request with an ID, echoed in the response
+// header and in error bodies.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HEADERREQUESTID)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(HEADERREQUESTID, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = ovs_prom_client.WithLogAttrs(ctx, slog.String("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID of the request, if any.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// writeError sends a structured error body with the given status.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string, details ...string) {
+	writeErrorWithWarnings(w, r, status, APIError{Code: code, Message: message, Details: details})
+}
+
+func writeErrorWithWarnings(w http.ResponseWriter, r *http.Request, status int, apiErr APIError) {
+	apiErr.RequestID = requestID(r.Context())
+
+	resp, err := json.MarshalIndent(&APIErrorResponse{Error: apiErr}, "", "\t\t")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// writeUpstreamError maps a failed Prometheus query to 400 for invalid
+// parameters and queries Prometheus rejects, 403 for queries a restricted
+// principal may not run, 429 when too many queries are running, 504 for
+// timeouts and 502 otherwise.
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, err error, warnings []string) {
+	if writeValidationError(w, r, err) {
+		return
+	}
+	if errors.Is(err, ovs_prom_client.ErrRestricted) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, err.Error())
+		return
+	}
+	if errors.Is(err, ovs_prom_client.ErrOverloaded) {
+		writeOverloaded(w, r, err)
+		return
+	}
+
+	status, code := classifyUpstreamError(err)
+	logger.WarnContext(r.Context(), "error to query prometheus", "status", status, "err", err)
+	writeErrorWithWarnings(w, r, status, APIError{
+		Code:     code,
+		Message:  "error to query prometheus",
+		Details:  []string{err.Error()},
+		Warnings: warnings,
+	})
+}
+
+// classifyUpstreamError returns the HTTP status and error code of a failed
+// Prometheus query.
+func classifyUpstreamError(err error) (int, string) {
+	var promErr *v1.Error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, codeUpstreamTimeout
+	case errors.As(err, &promErr) && promErr.Type == v1.ErrBadData:
+		return http.StatusBadRequest, codeInvalidQuery
+	case errors.As(err, &promErr) && (promErr.Type == v1.ErrTimeout || promErr.Type == v1.ErrCanceled):
+		return http.StatusGatewayTimeout, codeUpstreamTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, codeUpstreamTimeout
+	}
+	return http.StatusBadGateway, codeUpstreamError
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, codeNotFound, "no such route", r.URL.Path)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed", r.Method)
+}
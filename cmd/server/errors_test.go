@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+)
+
+// newTestRouter routes the query API to a fake Prometheus answering with
+// handler.
+func newTestRouter(t *testing.T, handler http.HandlerFunc) (*mux.Router, func()) {
+	prom := httptest.NewServer(handler)
+	u, err := url.Parse(prom.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := config.Default()
+	conf.Prometheus.Host = u.Hostname()
+	conf.Prometheus.Port = u.Port()
+	conf.Prometheus.Timeout = config.Duration(200 * time.Millisecond)
+	cfg.Store(conf)
+
+	r := mux.NewRouter()
+	r.Use(metricsMiddleware, requestIDMiddleware)
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/count/metric/{metricID}", getCountAPIQuery).Methods(http.MethodGet)
+	api.HandleFunc("/topk/metric/{metricID}/duration/{durationID}/rank/{rankID}", getTopkAPIQuery).Methods(http.MethodGet)
+	api.HandleFunc("/groupby/metric/{metricID}/duration/{durationID}", getGroupbyAPIQueryRange).Methods(http.MethodGet)
+	return r, prom.Close
+}
+
+func TestErrorModel(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		path   string
+		prom   http.HandlerFunc
+		status int
+		code   string
+		hint   string
+	}{
+		{
+			name:   "invalid metric",
+			path:   "/api/v1/count/metric/rate(x)",
+			status: http.StatusBadRequest,
+			code:   codeInvalidMetric,
+		},
+		{
+			name:   "invalid rank",
+			path:   "/api/v1/topk/metric/ovs_interface_receive_bytes_total/duration/5m/rank/ten",
+			status: http.StatusBadRequest,
+			code:   codeInvalidRank,
+		},
+		{
+			name:   "injected duration",
+			path:   "/api/v1/topk/metric/ovs_interface_receive_bytes_total/duration/5m%5D)or%20vector(1/rank/10",
+			status: http.StatusBadRequest,
+			code:   codeInvalidDuration,
+		},
+		{
+			name:   "duration shorter than 4 scrape intervals",
+			path:   "/api/v1/topk/metric/ovs_interface_receive_bytes_total/duration/30s/rank/10",
+			status: http.StatusBadRequest,
+			code:   codeInvalidDuration,
+			hint:   "1m",
+		},
+		{
+			name:   "rank over the limit",
+			path:   "/api/v1/topk/metric/ovs_interface_receive_bytes_total/duration/5m/rank/1000",
+			status: http.StatusBadRequest,
+			code:   codeInvalidRank,
+			hint:   "100",
+		},
+		{
+			name: "rejected query",
+			path: "/api/v1/count/metric/ovs_interface_receive_bytes_total",
+			prom: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+			},
+			status: http.StatusBadRequest,
+			code:   codeInvalidQuery,
+		},
+		{
+			name: "upstream failure",
+			path: "/api/v1/count/metric/ovs_interface_receive_bytes_total",
+			prom: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			status: http.StatusBadGateway,
+			code:   codeUpstreamError,
+		},
+		{
+			name: "upstream timeout",
+			path: "/api/v1/count/metric/ovs_interface_receive_bytes_total",
+			prom: func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(time.Second)
+			},
+			status: http.StatusGatewayTimeout,
+			code:   codeUpstreamTimeout,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.prom == nil {
+				tc.prom = func(w http.ResponseWriter, r *http.Request) {
+					t.Errorf("unexpected upstream query")
+				}
+			}
+			router, closeProm := newTestRouter(t, tc.prom)
+			defer closeProm()
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set(HEADERREQUESTID, "req-1")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rec.Code, rec.Body)
+			}
+			var resp APIErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error.Code != tc.code || resp.Error.Suggestion != tc.hint || resp.Error.RequestID != "req-1" {
+				t.Errorf("unexpected error %+v", resp.Error)
+			}
+		})
+	}
+}
+
+func TestCountPassesWarnings(t *testing.T) {
+	router, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","warnings":["partial data"],"data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"42"]}]}}`))
+	})
+	defer closeProm()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/count/metric/ovs_interface_receive_bytes_total", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp TSMetrics
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Metrics) != 1 || resp.Metrics[0].Vals[0] != "42" {
+		t.Errorf("unexpected metrics %+v", resp.Metrics)
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0] != "partial data" {
+		t.Errorf("unexpected warnings %v", resp.Warnings)
+	}
+}
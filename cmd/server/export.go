@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/parquet-go/parquet-go"
+)
+
+// PARAMFORMAT is output format query parameter
+const PARAMFORMAT string = "format"
+
+// Supported output formats
+const (
+	formatJSON    string = "json"
+	formatCSV     string = "csv"
+	formatNDJSON  string = "ndjson"
+	formatParquet string = "parquet"
+)
+
+// Column names of the value and timestamp of each exported sample, and of its
+// labels in NDJSON
+const (
+	columnValue     string = "value"
+	columnTimestamp string = "timestamp"
+	columnLabels    string = "labels"
+)
+
+var formatContentTypes = map[string]string{
+	formatJSON:    "application/json",
+	formatCSV:     "text/csv; charset=utf-8",
+	formatNDJSON:  "application/x-ndjson",
+	formatParquet: "application/vnd.apache.parquet",
+}
+
+var mediaTypeFormats = map[string]string{
+	"application/json":               formatJSON,
+	"text/csv":                       formatCSV,
+	"application/csv":                formatCSV,
+	"application/x-ndjson":           formatNDJSON,
+	"application/ndjson":             formatNDJSON,
+	"application/jsonl":              formatNDJSON,
+	"application/vnd.apache.parquet": formatParquet,
+	"application/x-parquet":          formatParquet,
+}
+
+var errUnsupportedFormat = errors.New("unsupported output format")
+
+// parquetRowGroupRows bounds the rows a Parquet export holds in memory
+const parquetRowGroupRows int64 = 64 * 1024
+
+// negotiateFormat picks the output format from the ?format= parameter, then
+// from the Accept header. It falls back to JSON without either, or for a
+// wildcard Accept.
+func negotiateFormat(r *http.Request) (string, error) {
+	if format := strings.ToLower(r.URL.Query().Get(PARAMFORMAT)); format != "" {
+		if _, ok := formatContentTypes[format]; ok {
+			return format, nil
+		}
+		return "", errUnsupportedFormat
+	}
+
+	accept := strings.TrimSpace(r.Header.Get("Accept"))
+	if accept == "" {
+		return formatJSON, nil
+	}
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		if format, ok := mediaTypeFormats[mediaType]; ok {
+			return format, nil
+		}
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return formatJSON, nil
+		}
+		if mediaType == "text/*" {
+			return formatCSV, nil
+		}
+	}
+
+	return "", errUnsupportedFormat
+}
+
+// writeMetrics writes the query result to w in the given format. Non-JSON
+// formats have one row per label set and sample, with a column per label of
+// metrics. Warnings are sent as HTTP Warning headers, and in the body for
+// JSON and in the "warnings" key of the Parquet metadata.
+func writeMetrics(w http.ResponseWriter, format string, metrics []ovs_prom_client.TSMetricObj, warnings []string) error {
+	for _, warning := range warnings {
+		w.Header().Add("Warning", warningHeader(warning))
+	}
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.WriteHeader(http.StatusOK)
+
+	mw, err := newMetricWriter(w, format, exportLabelNames(metrics))
+	if err != nil {
+		return err
+	}
+	for _, metric := range metrics {
+		if err := mw.write(metric); err != nil {
+			return err
+		}
+	}
+	return mw.close(warnings)
+}
+
+func warningHeader(warning string) string {
+	return fmt.Sprintf("199 - %q", warning)
+}
+
+// metricStream writes the series of a streamed query as they arrive. The
+// response starts with the first series, so that a query failing before it
+// still gets an error response.
+type metricStream struct {
+	w          http.ResponseWriter
+	format     string
+	labelNames []string
+	mw         metricWriter
+}
+
+// newMetricStream returns a stream of series to w in the given format, with
+// a column per name of labelNames in the non-JSON formats.
+func newMetricStream(w http.ResponseWriter, format string, labelNames []string) *metricStream {
+	return &metricStream{w: w, format: format, labelNames: labelNames}
+}
+
+// started reports whether the response was started.
+func (s *metricStream) started() bool {
+	return s.mw != nil
+}
+
+func (s *metricStream) start() error {
+	s.w.Header().Set("Content-Type", formatContentTypes[s.format])
+	s.w.Header().Set("Trailer", "Warning")
+	s.w.WriteHeader(http.StatusOK)
+
+	var err error
+	s.mw, err = newMetricWriter(s.w, s.format, s.labelNames)
+	return err
+}
+
+// write writes one series, starting the response if needed.
+func (s *metricStream) write(metric ovs_prom_client.TSMetricObj) error {
+	if !s.started() {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	return s.mw.write(metric)
+}
+
+// close ends the response after the last series. Warnings are sent as HTTP
+// Warning trailers, and in the body like writeMetrics does.
+func (s *metricStream) close(warnings []string) error {
+	if !s.started() {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	if err := s.mw.close(warnings); err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		s.w.Header().Add("Warning", warningHeader(warning))
+	}
+	return nil
+}
+
+// metricWriter writes series in one of the output formats
+type metricWriter interface {
+	write(metric ovs_prom_client.TSMetricObj) error
+	// close ends the output, adding warnings where the format allows.
+	close(warnings []string) error
+}
+
+// newMetricWriter returns the writer of format to w. CSV and Parquet have a
+// column per name of labelNames; other labels are left out.
+func newMetricWriter(w io.Writer, format string, labelNames []string) (metricWriter, error) {
+	switch format {
+	case formatCSV:
+		return newCSVWriter(w, labelNames)
+	case formatNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case formatParquet:
+		return newParquetWriter(w, labelNames), nil
+	default:
+		return &jsonWriter{w: w}, nil
+	}
+}
+
+// jsonWriter writes the same indented TSMetrics document as json.MarshalIndent
+// does, one metric at a time.
+type jsonWriter struct {
+	w io.Writer
+	n int
+}
+
+func (jw *jsonWriter) write(metric ovs_prom_client.TSMetricObj) error {
+	sep := ",\n\t\t\t\t"
+	if jw.n == 0 {
+		sep = "{\n\t\t\"metrics\": [\n\t\t\t\t"
+	}
+	obj, err := json.MarshalIndent(&metric, "\t\t\t\t", "\t\t")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(jw.w, sep); err != nil {
+		return err
+	}
+	if _, err := jw.w.Write(obj); err != nil {
+		return err
+	}
+	jw.n++
+	return nil
+}
+
+func (jw *jsonWriter) close(warnings []string) error {
+	closing := "\n\t\t]"
+	if jw.n == 0 {
+		closing = "{\n\t\t\"metrics\": null"
+	}
+	if _, err := io.WriteString(jw.w, closing); err != nil {
+		return err
+	}
+
+	if len(warnings) > 0 {
+		obj, err := json.MarshalIndent(warnings, "\t\t", "\t\t")
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(jw.w, ",\n\t\t\"warnings\": "); err != nil {
+			return err
+		}
+		if _, err := jw.w.Write(obj); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(jw.w, "\n}")
+	return err
+}
+
+type csvWriter struct {
+	cw         *csv.Writer
+	labelNames []string
+	record     []string
+}
+
+func newCSVWriter(w io.Writer, labelNames []string) (*csvWriter, error) {
+	cw := csv.NewWriter(w)
+	header := append(append([]string{}, labelNames...), columnValue, columnTimestamp)
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvWriter{cw: cw, labelNames: labelNames, record: make([]string, len(header))}, nil
+}
+
+func (c *csvWriter) write(metric ovs_prom_client.TSMetricObj) error {
+	err := eachSample(metric, func(val string, ts string) error {
+		for i, name := range c.labelNames {
+			c.record[i] = metric.Labels[name]
+		}
+		c.record[len(c.labelNames)] = val
+		c.record[len(c.labelNames)+1] = ts
+		return c.cw.Write(c.record)
+	})
+	if err != nil {
+		return err
+	}
+	// Send the rows of every series as it arrives
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+func (c *csvWriter) close(warnings []string) error {
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+// ndjsonWriter writes a line per sample, with the labels of its series nested
+// under columnLabels so that none of them can replace its value or timestamp.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (nw *ndjsonWriter) write(metric ovs_prom_client.TSMetricObj) error {
+	labels := metric.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return eachSample(metric, func(val string, ts string) error {
+		return nw.enc.Encode(map[string]interface{}{
+			columnLabels:    labels,
+			columnValue:     sampleNumber(val),
+			columnTimestamp: sampleNumber(ts),
+		})
+	})
+}
+
+func (nw *ndjsonWriter) close(warnings []string) error {
+	return nil
+}
+
+type parquetWriter struct {
+	pw         *parquet.Writer
+	labelNames []string
+	columns    map[string]int
+	row        parquet.Row
+}
+
+func newParquetWriter(w io.Writer, labelNames []string) *parquetWriter {
+	group := parquet.Group{
+		columnValue:     parquet.Optional(parquet.Leaf(parquet.DoubleType)),
+		columnTimestamp: parquet.Optional(parquet.Leaf(parquet.DoubleType)),
+	}
+	for _, name := range labelNames {
+		group[name] = parquet.Optional(parquet.String())
+	}
+	schema := parquet.NewSchema("ovs_metrics", group)
+
+	// Leaf columns of a group are ordered by name.
+	columns := make(map[string]int)
+	for i, path := range schema.Columns() {
+		columns[path[0]] = i
+	}
+
+	return &parquetWriter{
+		pw:         parquet.NewWriter(w, schema, parquet.MaxRowsPerRowGroup(parquetRowGroupRows)),
+		labelNames: labelNames,
+		columns:    columns,
+		row:        make(parquet.Row, len(columns)),
+	}
+}
+
+func (p *parquetWriter) write(metric ovs_prom_client.TSMetricObj) error {
+	return eachSample(metric, func(val string, ts string) error {
+		for _, name := range p.labelNames {
+			i := p.columns[name]
+			if value, ok := metric.Labels[name]; ok {
+				p.row[i] = parquet.ByteArrayValue([]byte(value)).Level(0, 1, i)
+			} else {
+				p.row[i] = parquet.NullValue().Level(0, 0, i)
+			}
+		}
+		p.row[p.columns[columnValue]] = parquetDouble(val, p.columns[columnValue])
+		p.row[p.columns[columnTimestamp]] = parquetDouble(ts, p.columns[columnTimestamp])
+
+		_, err := p.pw.WriteRows([]parquet.Row{p.row})
+		return err
+	})
+}
+
+// close writes the warnings as a JSON list under the "warnings" key of the
+// file metadata.
+func (p *parquetWriter) close(warnings []string) error {
+	if len(warnings) > 0 {
+		obj, err := json.Marshal(warnings)
+		if err != nil {
+			return err
+		}
+		p.pw.SetKeyValueMetadata("warnings", string(obj))
+	}
+	return p.pw.Close()
+}
+
+// eachSample calls fn once per sample of metric.
+func eachSample(metric ovs_prom_client.TSMetricObj, fn func(val string, ts string) error) error {
+	for i, val := range metric.Vals {
+		ts := ""
+		if i < len(metric.TimeSeries) {
+			ts = metric.TimeSeries[i]
+		}
+		if err := fn(val, ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportLabelNames returns the sorted union of label names in metrics.
+func exportLabelNames(metrics []ovs_prom_client.TSMetricObj) []string {
+	seen := make(map[string]bool)
+	for _, metric := range metrics {
+		for name := range metric.Labels {
+			if name == columnValue || name == columnTimestamp {
+				continue
+			}
+			seen[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sampleNumber returns s as a JSON number when it is a finite float, and as
+// the raw string otherwise (e.g. "NaN").
+func sampleNumber(s string) interface{} {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return s
+	}
+	return f
+}
+
+func parquetDouble(s string, column int) parquet.Value {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return parquet.NullValue().Level(0, 0, column)
+	}
+	return parquet.DoubleValue(f).Level(0, 1, column)
+}
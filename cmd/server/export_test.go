@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	exportRangePath string = "/api/v1/groupby/metric/ovs_interface_receive_bytes_total/duration/5m"
+	exportTopkPath  string = "/api/v1/topk/metric/ovs_interface_receive_bytes_total/duration/5m/rank/10"
+)
+
+// exportProm answers range queries with two series of two samples and
+// instant queries with one sample of each, both with a warning.
+func exportProm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/api/v1/query_range" {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[` +
+			`{"metric":{"bridge":"br-int","port":"a"},"values":[[1600000000,"1"],[1600000060,"2"]]},` +
+			`{"metric":{"bridge":"br-int","port":"b"},"values":[[1600000000,"3"],[1600000060,"NaN"]]}` +
+			`]},"warnings":["partial data"]}`))
+		return
+	}
+	w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` +
+		`{"metric":{"bridge":"br-int","port":"a"},"value":[1600000060,"2"]},` +
+		`{"metric":{"bridge":"br-int","port":"b"},"value":[1600000060,"4"]}` +
+		`]},"warnings":["partial data"]}`))
+}
+
+// export requests path from a router querying exportProm.
+func export(t *testing.T, path string, accept string) *http.Response {
+	t.Helper()
+	router, closeProm := newTestRouter(t, exportProm)
+	t.Cleanup(closeProm)
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func readBody(t *testing.T, resp *http.Response) []byte {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	for _, tc := range []struct {
+		query  string
+		accept string
+		want   string
+		err    bool
+	}{
+		{want: formatJSON},
+		{query: "format=CSV", accept: "application/json", want: formatCSV},
+		{query: "format=parquet", want: formatParquet},
+		{query: "format=xml", err: true},
+		{accept: "application/x-ndjson", want: formatNDJSON},
+		{accept: "application/xml, text/csv;q=0.9", want: formatCSV},
+		{accept: "application/vnd.apache.parquet", want: formatParquet},
+		{accept: "*/*", want: formatJSON},
+		{accept: "text/*", want: formatCSV},
+		{accept: "application/xml", err: true},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/?"+tc.query, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		format, err := negotiateFormat(req)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q %q: expected an error, got %s", tc.query, tc.accept, format)
+			}
+			continue
+		}
+		if err != nil || format != tc.want {
+			t.Errorf("%q %q: got %s %v, want %s", tc.query, tc.accept, format, err, tc.want)
+		}
+	}
+}
+
+func TestExportUnsupportedFormat(t *testing.T) {
+	for _, tc := range []struct {
+		path   string
+		accept string
+	}{
+		{path: exportRangePath + "?format=xml"},
+		{path: exportRangePath, accept: "application/xml"},
+		{path: exportTopkPath + "?format=xml"},
+		{path: exportTopkPath, accept: "application/xml"},
+	} {
+		resp := export(t, tc.path, tc.accept)
+		if resp.StatusCode != http.StatusNotAcceptable {
+			t.Errorf("%s %q: expected 406, got %d", tc.path, tc.accept, resp.StatusCode)
+			continue
+		}
+		var apiErr APIErrorResponse
+		if err := json.Unmarshal(readBody(t, resp), &apiErr); err != nil || apiErr.Error.Code != codeUnsupportedFormat {
+			t.Errorf("%s %q: unexpected error %+v %v", tc.path, tc.accept, apiErr.Error, err)
+		}
+	}
+}
+
+// exportWarnings returns the Warning headers and trailers of resp.
+func exportWarnings(resp *http.Response) (headers []string, trailers []string) {
+	return resp.Header.Values("Warning"), resp.Trailer.Values("Warning")
+}
+
+func TestExportFormats(t *testing.T) {
+	warning := `199 - "partial data"`
+
+	for _, tc := range []struct {
+		name        string
+		path        string
+		accept      string
+		contentType string
+		streamed    bool
+		check       func(t *testing.T, body []byte)
+	}{
+		{
+			name:        "json range",
+			path:        exportRangePath,
+			contentType: "application/json",
+			streamed:    true,
+			check:       checkJSON([]string{"a", "b"}, []string{"1", "2", "3", "NaN"}),
+		},
+		{
+			name:        "csv range",
+			path:        exportRangePath + "?format=csv",
+			contentType: "text/csv; charset=utf-8",
+			streamed:    true,
+			check: checkCSV([][]string{
+				{"bridge", "port", "value", "timestamp"},
+				{"br-int", "a", "1", "1600000000"},
+				{"br-int", "a", "2", "1600000060"},
+				{"br-int", "b", "3", "1600000000"},
+				{"br-int", "b", "NaN", "1600000060"},
+			}),
+		},
+		{
+			name:        "ndjson range",
+			path:        exportRangePath,
+			accept:      "application/x-ndjson",
+			contentType: "application/x-ndjson",
+			streamed:    true,
+			check: checkNDJSON([]string{
+				`{"labels":{"bridge":"br-int","port":"a"},"timestamp":1600000000,"value":1}`,
+				`{"labels":{"bridge":"br-int","port":"a"},"timestamp":1600000060,"value":2}`,
+				`{"labels":{"bridge":"br-int","port":"b"},"timestamp":1600000000,"value":3}`,
+				`{"labels":{"bridge":"br-int","port":"b"},"timestamp":1600000060,"value":"NaN"}`,
+			}),
+		},
+		{
+			name:        "parquet range",
+			path:        exportRangePath + "?format=parquet",
+			contentType: "application/vnd.apache.parquet",
+			streamed:    true,
+			check:       checkParquet([]string{"a", "a", "b", "b"}, 4),
+		},
+		{
+			name:        "json topk",
+			path:        exportTopkPath,
+			contentType: "application/json",
+			check:       checkJSON([]string{"b", "a"}, []string{"4", "2"}),
+		},
+		{
+			name:        "csv topk",
+			path:        exportTopkPath,
+			accept:      "text/csv",
+			contentType: "text/csv; charset=utf-8",
+			check: checkCSV([][]string{
+				{"bridge", "port", "value", "timestamp"},
+				{"br-int", "b", "4", "1600000060"},
+				{"br-int", "a", "2", "1600000060"},
+			}),
+		},
+		{
+			name:        "ndjson topk",
+			path:        exportTopkPath + "?format=ndjson",
+			contentType: "application/x-ndjson",
+			check: checkNDJSON([]string{
+				`{"labels":{"bridge":"br-int","port":"b"},"timestamp":1600000060,"value":4}`,
+				`{"labels":{"bridge":"br-int","port":"a"},"timestamp":1600000060,"value":2}`,
+			}),
+		},
+		{
+			name:        "parquet topk",
+			path:        exportTopkPath,
+			accept:      "application/x-parquet",
+			contentType: "application/vnd.apache.parquet",
+			check:       checkParquet([]string{"b", "a"}, 4),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := export(t, tc.path, tc.accept)
+			body := readBody(t, resp)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != tc.contentType {
+				t.Errorf("got Content-Type %s, want %s", ct, tc.contentType)
+			}
+
+			// Streamed warnings are only known after the body
+			headers, trailers := exportWarnings(resp)
+			if tc.streamed {
+				headers, trailers = trailers, headers
+			}
+			if !reflect.DeepEqual(headers, []string{warning}) || len(trailers) > 0 {
+				t.Errorf("unexpected Warning headers %q and trailers %q", resp.Header.Values("Warning"), resp.Trailer.Values("Warning"))
+			}
+			tc.check(t, body)
+		})
+	}
+}
+
+func checkJSON(ports []string, vals []string) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		var resp TSMetrics
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("%v: %s", err, body)
+		}
+		var gotPorts, gotVals []string
+		for _, m := range resp.Metrics {
+			gotPorts = append(gotPorts, m.Labels["port"])
+			gotVals = append(gotVals, m.Vals...)
+		}
+		if !reflect.DeepEqual(gotPorts, ports) || !reflect.DeepEqual(gotVals, vals) {
+			t.Errorf("got ports %v and values %v, want %v and %v", gotPorts, gotVals, ports, vals)
+		}
+		if !reflect.DeepEqual(resp.Warnings, []string{"partial data"}) {
+			t.Errorf("unexpected warnings %q", resp.Warnings)
+		}
+	}
+}
+
+func checkCSV(want [][]string) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(records, want) {
+			t.Errorf("got %q, want %q", records, want)
+		}
+	}
+}
+
+func checkNDJSON(want []string) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		if got := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n"); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func checkParquet(ports []string, columns int) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		f, err := parquet.OpenFile(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(f.Schema().Columns()); n != columns {
+			t.Errorf("got %d columns, want %d", n, columns)
+		}
+		if warnings, ok := f.Lookup("warnings"); !ok || warnings != `["partial data"]` {
+			t.Errorf("unexpected warnings metadata %q", warnings)
+		}
+
+		type row struct {
+			Bridge string  `parquet:"bridge"`
+			Port   string  `parquet:"port"`
+			Value  float64 `parquet:"value"`
+		}
+		rows, err := parquet.Read[row](bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range rows {
+			got = append(got, r.Port)
+		}
+		if !reflect.DeepEqual(got, ports) {
+			t.Errorf("got ports %v, want %v", got, ports)
+		}
+	}
+}
+
+func TestExportRangeUpstreamError(t *testing.T) {
+	router, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+	})
+	defer closeProm()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, exportRangePath+"?format=csv", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an error before the first series to be 400, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp APIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error.Code != codeInvalidQuery {
+		t.Errorf("unexpected body %s", rec.Body)
+	}
+}
+
+func TestExportRangeEmpty(t *testing.T) {
+	router, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+	})
+	defer closeProm()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, exportRangePath+"?format=csv", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "bridge,port,value,timestamp\n" {
+		t.Errorf("expected the header row only, got %d: %q", rec.Code, rec.Body)
+	}
+}
+
+func TestExportNDJSONLabelNames(t *testing.T) {
+	var buf bytes.Buffer
+	nw := &ndjsonWriter{enc: json.NewEncoder(&buf)}
+	err := nw.write(ovs_prom_client.TSMetricObj{
+		Labels:     map[string]string{"value": "label", "timestamp": "label"},
+		Vals:       []string{"1"},
+		TimeSeries: []string{"1600000000"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"labels":{"timestamp":"label","value":"label"},"timestamp":1600000000,"value":1}` + "\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/enrich"
+	pb "github.com/kongseokhwan/Helios-prom-client/pkg/heliospb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// topkGroups are the labels TopKRequest.group_by may rank by
+var topkGroups = map[string]string{
+	"logical_switch": enrich.LabelLogicalSwitch,
+	"logical_router": enrich.LabelLogicalRouter,
+	"tenant":         enrich.LabelTenant,
+	"owner":          enrich.LabelOwner,
+	"environment":    enrich.LabelEnvironment,
+}
+
+// grpcServer implements the OVSMetrics service on top of OVSClient
+type grpcServer struct {
+	pb.UnimplementedOVSMetricsServer
+}
+
+// newGRPCServer returns the gRPC API server with the health and reflection
+// services. Stopping it should go through health.Shutdown first.
+func newGRPCServer() (*grpc.Server, *health.Server) {
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unaryLogInterceptor, authUnaryInterceptor, rateLimitUnaryInterceptor),
+		grpc.ChainStreamInterceptor(streamLogInterceptor, authStreamInterceptor, rateLimitStreamInterceptor),
+	)
+	pb.RegisterOVSMetricsServer(s, grpcServer{})
+
+	hs := health.NewServer()
+	hs.SetServingStatus(pb.OVSMetrics_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+	reflection.Register(s)
+	return s, hs
+}
+
+// stopGRPC drains in-flight calls for up to timeout, then closes the rest.
+func stopGRPC(s *grpc.Server, hs *health.Server, timeout time.Duration) {
+	hs.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		s.Stop()
+	}
+}
+
+func (grpcServer) Count(ctx context.Context, req *pb.CountRequest) (*pb.MetricsResponse, error) {
+	if err := ovs_prom_client.ValidateMetric(req.GetMetric()); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	c, err := newOVSClient(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "error to create OVSClient")
+	}
+
+	queryResult, warnings, err := c.CountQueryContext(ctx, req.GetMetric())
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &pb.MetricsResponse{Series: toSeries(queryResult), Warnings: warnings}, nil
+}
+
+func (grpcServer) TopK(ctx context.Context, req *pb.TopKRequest) (*pb.MetricsResponse, error) {
+	if err := ovs_prom_client.ValidateMetric(req.GetMetric()); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	duration, err := validateDuration(req.GetDuration())
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	rank := int(req.GetRank())
+	if err := validateRank(rank); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	label, ok := topkGroups[req.GetGroupBy()]
+	if !ok && req.GetGroupBy() != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid group_by %q", req.GetGroupBy())
+	}
+
+	c, err := newOVSClient(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "error to create OVSClient")
+	}
+
+	var queryResult []ovs_prom_client.TSMetricObj
+	var warnings []string
+	if label == "" {
+		queryResult, warnings, err = c.NtopQueryWithRateContext(ctx, rank, req.GetMetric(), duration)
+	} else {
+		queryResult, warnings, err = c.RateQueryContext(ctx, req.GetMetric(), duration)
+		queryResult = enrich.TopK(enrich.Aggregate(queryResult, label), rank)
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &pb.MetricsResponse{Series: toSeries(queryResult), Warnings: warnings}, nil
+}
+
+func (grpcServer) GroupByRange(req *pb.GroupByRangeRequest, stream pb.OVSMetrics_GroupByRangeServer) error {
+	ctx := stream.Context()
+	if err := ovs_prom_client.ValidateMetric(req.GetMetric()); err != nil {
+		return grpcError(ctx, err)
+	}
+	duration, err := validateDuration(req.GetDuration())
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+
+	c, err := newOVSClient(ctx)
+	if err != nil {
+		return status.Error(codes.Internal, "error to create OVSClient")
+	}
+
+	// Series are sent as they are read; warnings are only known at the end.
+	warnings, err := c.AvgbyQueryWithRateStream(ctx, req.GetMetric(), duration, func(metric ovs_prom_client.TSMetricObj) error {
+		return stream.Send(&pb.SeriesChunk{Series: toSeries([]ovs_prom_client.TSMetricObj{metric})[0]})
+	})
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+	if len(warnings) > 0 {
+		return stream.Send(&pb.SeriesChunk{Warnings: warnings})
+	}
+	return nil
+}
+
+func (grpcServer) ListSeries(ctx context.Context, req *pb.ListSeriesRequest) (*pb.ListSeriesResponse, error) {
+	if req.GetMatch() == "" {
+		return nil, status.Error(codes.InvalidArgument, "match must not be empty")
+	}
+
+	c, err := newOVSClient(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "error to create OVSClient")
+	}
+
+	queryResult, warnings, err := c.SeriesContext(ctx, req.GetMatch())
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &pb.ListSeriesResponse{Series: toSeries(queryResult), Warnings: warnings}, nil
+}
+
+func (grpcServer) ListLabelValues(ctx context.Context, req *pb.ListLabelValuesRequest) (*pb.ListLabelValuesResponse, error) {
+	c, err := newOVSClient(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "error to create OVSClient")
+	}
+
+	values, warnings, err := c.LabelValuesContext(ctx, req.GetLabel(), req.GetMatch())
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &pb.ListLabelValuesResponse{Values: values, Warnings: warnings}, nil
+}
+
+// grpcError maps a failed query to a gRPC status the way writeUpstreamError
+// maps it to an HTTP status.
+func grpcError(ctx context.Context, err error) error {
+	var verr *ovs_prom_client.ValidationError
+	if errors.As(err, &verr) {
+		return status.Error(codes.InvalidArgument, verr.Error())
+	}
+	if errors.Is(err, ovs_prom_client.ErrRestricted) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, ovs_prom_client.ErrOverloaded) {
+		logger.WarnContext(ctx, "too many concurrent queries", "err", err)
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return status.Error(codes.Canceled, err.Error())
+	}
+
+	httpStatus, code := classifyUpstreamError(err)
+	logger.WarnContext(ctx, "error to query prometheus", "status", httpStatus, "err", err)
+	switch code {
+	case codeInvalidQuery:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case codeUpstreamTimeout:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// toSeries converts query results to protobuf series. Values that are not
+// numbers become NaN.
+func toSeries(metrics []ovs_prom_client.TSMetricObj) []*pb.Series {
+	series := make([]*pb.Series, 0, len(metrics))
+	for _, metric := range metrics {
+		s := &pb.Series{Labels: metric.Labels}
+		for i, val := range metric.Vals {
+			v, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				v = math.NaN()
+			}
+			sample := &pb.Sample{Value: v}
+			if i < len(metric.TimeSeries) {
+				if ts, err := strconv.ParseFloat(metric.TimeSeries[i], 64); err == nil {
+					sec, frac := math.Modf(ts)
+					sample.Timestamp = &timestamppb.Timestamp{Seconds: int64(sec), Nanos: int32(math.Round(frac * 1e9))}
+				}
+			}
+			s.Samples = append(s.Samples, sample)
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+func unaryLogInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logger.InfoContext(ctx, "rpc", "method", info.FullMethod, "code", status.Code(err).String(), "duration", time.Since(start))
+	return resp, err
+}
+
+func streamLogInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	logger.InfoContext(ss.Context(), "rpc", "method", info.FullMethod, "code", status.Code(err).String(), "duration", time.Since(start))
+	return err
+}
+
+// listenGRPC starts the gRPC API on addr in the background.
+func listenGRPC(addr string) (*grpc.Server, *health.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, hs := newGRPCServer()
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			logger.Error("error to serve gRPC", "err", err)
+		}
+	}()
+	logger.Info("listening for gRPC", "address", ln.Addr().String())
+	return s, hs, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	pb "github.com/kongseokhwan/Helios-prom-client/pkg/heliospb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPC serves the gRPC API in memory against a fake Prometheus
+// answering with handler.
+func newTestGRPC(t *testing.T, handler http.HandlerFunc) *grpc.ClientConn {
+	_, closeProm := newTestRouter(t, handler)
+	t.Cleanup(closeProm)
+
+	ln := bufconn.Listen(1 << 20)
+	s, _ := newGRPCServer()
+	go s.Serve(ln)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func fakeProm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/query_range"):
+		w.Write([]byte(`{"status":"success","warnings":["partial data"],"data":{"resultType":"matrix","result":[` +
+			`{"metric":{"bridge":"br-int"},"values":[[1600000000,"1"],[1600000015.5,"2"]]},` +
+			`{"metric":{"bridge":"br-ex"},"values":[[1600000000,"3"]]}]}}`))
+	case strings.HasSuffix(r.URL.Path, "/query"):
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"42"]}]}}`))
+	case strings.HasSuffix(r.URL.Path, "/label/bridge/values"):
+		w.Write([]byte(`{"status":"success","data":["br-int","br-ex"]}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestGRPCCount(t *testing.T) {
+	client := pb.NewOVSMetricsClient(newTestGRPC(t, fakeProm))
+
+	resp, err := client.Count(context.Background(), &pb.CountRequest{Metric: "ovs_interface_receive_bytes_total"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Series) != 1 || len(resp.Series[0].Samples) != 1 || resp.Series[0].Samples[0].Value != 42 {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	if ts := resp.Series[0].Samples[0].Timestamp; ts.GetSeconds() != 1600000000 {
+		t.Errorf("unexpected timestamp %v", ts)
+	}
+}
+
+func TestGRPCInvalidArgument(t *testing.T) {
+	client := pb.NewOVSMetricsClient(newTestGRPC(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected upstream query")
+	}))
+
+	for _, req := range []*pb.TopKRequest{
+		{Metric: "rate(x)", Duration: "5m", Rank: 10},
+		{Metric: "ovs_interface_receive_bytes_total", Duration: "30s", Rank: 10},
+		{Metric: "ovs_interface_receive_bytes_total", Duration: "5m", Rank: 1000},
+		{Metric: "ovs_interface_receive_bytes_total", Duration: "5m", Rank: 10, GroupBy: "datacenter"},
+	} {
+		_, err := client.TopK(context.Background(), req)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%v: expected InvalidArgument, got %v", req, err)
+		}
+	}
+}
+
+func TestGRPCUpstreamError(t *testing.T) {
+	client := pb.NewOVSMetricsClient(newTestGRPC(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	_, err := client.Count(context.Background(), &pb.CountRequest{Metric: "ovs_interface_receive_bytes_total"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+}
+
+func TestGRPCGroupByRange(t *testing.T) {
+	client := pb.NewOVSMetricsClient(newTestGRPC(t, fakeProm))
+
+	stream, err := client.GroupByRange(context.Background(), &pb.GroupByRangeRequest{
+		Metric:   "ovs_interface_receive_bytes_total",
+		Duration: "5m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks []*pb.SeriesChunk
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+	if last := chunks[2]; len(last.Warnings) != 1 || last.Series != nil || len(chunks[0].Warnings)+len(chunks[1].Warnings) != 0 {
+		t.Errorf("expected warnings on a last chunk only, got %v", chunks)
+	}
+	var samples []*pb.Sample
+	for _, chunk := range chunks {
+		if chunk.GetSeries().GetLabels()["bridge"] == "br-int" {
+			samples = chunk.GetSeries().GetSamples()
+		}
+	}
+	if len(samples) != 2 || samples[1].Value != 2 || samples[1].Timestamp.GetNanos() != 500000000 {
+		t.Errorf("unexpected samples %v", samples)
+	}
+}
+
+func TestGRPCListLabelValues(t *testing.T) {
+	client := pb.NewOVSMetricsClient(newTestGRPC(t, fakeProm))
+
+	resp, err := client.ListLabelValues(context.Background(), &pb.ListLabelValuesRequest{Label: "bridge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(resp.Values, ",") != "br-ex,br-int" {
+		t.Errorf("unexpected values %v", resp.Values)
+	}
+}
+
+func TestGRPCHealthAndReflection(t *testing.T) {
+	conn := newTestGRPC(t, fakeProm)
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{
+		Service: pb.OVSMetrics_ServiceDesc.ServiceName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("unexpected health status %v", resp.Status)
+	}
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, svc := range info.GetListServicesResponse().GetService() {
+		found = found || svc.Name == pb.OVSMetrics_ServiceDesc.ServiceName
+	}
+	if !found {
+		t.Errorf("service not listed by reflection: %v", info)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+)
+
+// readyCacheTTL is how long a readiness check result is reused, so that
+// frequent load balancer probes do not each query Prometheus
+const readyCacheTTL = 5 * time.Second
+
+// Status values of HealthStatus
+const (
+	statusOK       string = "ok"
+	statusReady    string = "ready"
+	statusNotReady string = "not_ready"
+)
+
+// HealthStatus is JSON response struct of /healthz and /readyz
+type HealthStatus struct {
+	Status    string                           `json:"status"`
+	Upstreams []ovs_prom_client.UpstreamStatus `json:"upstreams,omitempty"`
+}
+
+// readiness caches the last readiness check. Probes arriving during a check
+// wait for it instead of starting their own.
+type readiness struct {
+	mu      sync.Mutex
+	status  HealthStatus
+	checked time.Time
+}
+
+var ready readiness
+
+func (rd *readiness) check() (HealthStatus, error) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	if time.Since(rd.checked) < readyCacheTTL {
+		cacheLookup("readyz", true)
+		return rd.status, nil
+	}
+	cacheLookup("readyz", false)
+
+	c, err := newOVSClient(context.Background())
+	if err != nil {
+		return HealthStatus{}, err
+	}
+
+	// The result is shared by all probes, so a probe going away must not
+	// cancel the check. The client timeout bounds it.
+	status := HealthStatus{Status: statusNotReady, Upstreams: c.Ready(context.Background())}
+	// Fan-out queries answer as long as one upstream does.
+	for _, up := range status.Upstreams {
+		if up.Ready {
+			status.Status = statusReady
+		}
+	}
+	rd.status, rd.checked = status, time.Now()
+	return status, nil
+}
+
+func writeStatus(w http.ResponseWriter, code int, v interface{}) {
+	resp, err := json.MarshalIndent(v, "", "\t\t")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(resp)
+}
+
+// getHealthz reports that the process is alive. It never checks Prometheus.
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, &HealthStatus{Status: statusOK})
+}
+
+// getReadyz reports 200 when Prometheus is ready and answers queries, and
+// 503 otherwise.
+func getReadyz(w http.ResponseWriter, r *http.Request) {
+	status, err := ready.check()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "error to create OVSClient")
+		return
+	}
+
+	code := http.StatusOK
+	if status.Status != statusReady {
+		code = http.StatusServiceUnavailable
+	}
+	writeStatus(w, code, &status)
+}
+
+func getVersion(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, ovs_prom_client.Version())
+}
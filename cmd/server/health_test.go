@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	var checks int32
+	var promReady atomic.Value
+	promReady.Store(true)
+
+	router, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/-/ready":
+			atomic.AddInt32(&checks, 1)
+			if !promReady.Load().(bool) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("Prometheus Server is Ready.\n"))
+		case "/api/v1/query":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"1"]}]}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	})
+	defer closeProm()
+	router.HandleFunc("/readyz", getReadyz)
+	router.HandleFunc("/healthz", getHealthz)
+
+	probe := func(path string) (int, HealthStatus) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var status HealthStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, status
+	}
+
+	ready = readiness{}
+	if code, status := probe("/readyz"); code != http.StatusOK || status.Status != statusReady {
+		t.Errorf("expected ready, got %d %+v", code, status)
+	}
+
+	// Cached: Prometheus is not asked again.
+	promReady.Store(false)
+	if code, _ := probe("/readyz"); code != http.StatusOK || atomic.LoadInt32(&checks) != 1 {
+		t.Errorf("expected a cached result, got %d after %d checks", code, checks)
+	}
+
+	ready = readiness{}
+	code, status := probe("/readyz")
+	if code != http.StatusServiceUnavailable || status.Status != statusNotReady || len(status.Upstreams) != 1 || status.Upstreams[0].Error == "" {
+		t.Errorf("expected not ready, got %d %+v", code, status)
+	}
+
+	if code, status := probe("/healthz"); code != http.StatusOK || status.Status != statusOK {
+		t.Errorf("expected alive, got %d %+v", code, status)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// HEADERRETRYAFTER is Retry-After header of 429 responses, in seconds
+const HEADERRETRYAFTER string = "Retry-After"
+
+// grpcRetryPushback is the trailer telling gRPC clients when to retry
+const grpcRetryPushback string = "grpc-retry-pushback-ms"
+
+// rateLimitIdle is how long the token bucket of an idle client is kept
+const rateLimitIdle time.Duration = 10 * time.Minute
+
+// queryLimiter caps the concurrent upstream queries of all clients
+var queryLimiter = ovs_prom_client.NewQueryLimiter(0, 0)
+
+// rateLimits are the token buckets of the clients
+var rateLimits = newRateLimiter()
+
+// rateLimiter keeps a token bucket per client and route
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	route  string
+	client string
+}
+
+type bucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[bucketKey]*bucket), lastSweep: time.Now()}
+}
+
+// allow takes a token from the bucket of client on route. When none is
+// left it returns false and how long the client has to wait for one.
+// Buckets follow changes of limit.
+func (l *rateLimiter) allow(route string, client string, limit config.RateLimit, now time.Time) (bool, time.Duration) {
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > rateLimitIdle {
+		l.sweep(now)
+	}
+
+	key := bucketKey{route: route, client: client}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.buckets[key] = b
+		rateLimitBuckets.Set(float64(len(l.buckets)))
+	}
+	if b.limiter.Limit() != rate.Limit(limit.Rate) || b.limiter.Burst() != limit.Burst {
+		b.limiter.SetLimitAt(now, rate.Limit(limit.Rate))
+		b.limiter.SetBurstAt(now, limit.Burst)
+	}
+	b.seen = now
+
+	res := b.limiter.ReserveN(now, 1)
+	if !res.OK() {
+		return false, time.Second
+	}
+	if wait := res.DelayFrom(now); wait > 0 {
+		res.CancelAt(now)
+		return false, wait
+	}
+	return true, 0
+}
+
+// sweep drops the buckets of clients idle for rateLimitIdle. l.mu must be
+// held.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.seen) > rateLimitIdle {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+	rateLimitBuckets.Set(float64(len(l.buckets)))
+}
+
+// rateLimitClient identifies the client of a request by its principal or,
+// without authentication, by its IP address.
+func rateLimitClient(ctx context.Context, remoteAddr string) string {
+	if p := principal(ctx); p != nil {
+		return "principal:" + p.Name
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return "ip:" + host
+}
+
+// retryAfter rounds wait up to whole seconds.
+func retryAfter(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// rateLimitMiddleware answers 429 to clients exceeding the rate limit of a
+// route. Public routes are not limited.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		if publicRoutes[route] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := cfg.Load().Limits.RouteLimit(route)
+		ok, wait := rateLimits.allow(route, rateLimitClient(r.Context(), r.RemoteAddr), limit, time.Now())
+		if !ok {
+			rateLimitedRequests.WithLabelValues(route).Inc()
+			logger.WarnContext(r.Context(), "rate limit exceeded", "route", route, "retry_in", wait)
+			w.Header().Set(HEADERRETRYAFTER, strconv.Itoa(retryAfter(wait)))
+			writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded",
+				fmt.Sprintf("%g requests per second in bursts of %d", limit.Rate, limit.Burst))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeOverloaded sends 429 for a query that found no free upstream slot.
+func writeOverloaded(w http.ResponseWriter, r *http.Request, err error) {
+	logger.WarnContext(r.Context(), "too many concurrent queries", "err", err)
+	w.Header().Set(HEADERRETRYAFTER, strconv.Itoa(max(1, retryAfter(time.Duration(cfg.Load().Limits.QueueTimeout)))))
+	writeError(w, r, http.StatusTooManyRequests, codeOverloaded, "too many concurrent queries, retry later")
+}
+
+// setQueryLimits applies the limits section to the query limiter.
+func setQueryLimits(conf config.LimitsConfig) {
+	queryLimiter.SetLimits(conf.MaxConcurrentQueries, time.Duration(conf.QueueTimeout))
+}
+
+// grpcRateLimit takes a token of the client of a call, keyed by the full
+// method name.
+func grpcRateLimit(ctx context.Context, method string) error {
+	for _, prefix := range publicGRPCServices {
+		if strings.HasPrefix(method, prefix) {
+			return nil
+		}
+	}
+
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	limit := cfg.Load().Limits.RouteLimit(method)
+	ok, wait := rateLimits.allow(method, rateLimitClient(ctx, addr), limit, time.Now())
+	if ok {
+		return nil
+	}
+	rateLimitedRequests.WithLabelValues(method).Inc()
+	logger.WarnContext(ctx, "rate limit exceeded", "method", method, "retry_in", wait)
+	grpc.SetTrailer(ctx, metadata.Pairs(grpcRetryPushback, strconv.FormatInt(wait.Milliseconds(), 10)))
+	return status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry in %v", wait.Round(time.Millisecond))
+}
+
+func rateLimitUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := grpcRateLimit(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func rateLimitStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := grpcRateLimit(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
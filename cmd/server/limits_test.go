@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+	pb "github.com/kongseokhwan/Helios-prom-client/pkg/heliospb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// setTestLimits replaces the limits section and resets the token buckets.
+func setTestLimits(t *testing.T, limits func(*config.LimitsConfig)) {
+	conf := *cfg.Load()
+	limits(&conf.Limits)
+	cfg.Store(&conf)
+	setQueryLimits(conf.Limits)
+	rateLimits = newRateLimiter()
+	t.Cleanup(func() {
+		setQueryLimits(config.Default().Limits)
+		rateLimits = newRateLimiter()
+	})
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter()
+	limit := config.RateLimit{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("/r", "a", limit, now); !ok {
+			t.Fatalf("request %d refused within the burst", i)
+		}
+	}
+	ok, wait := l.allow("/r", "a", limit, now)
+	if ok || wait != time.Second {
+		t.Errorf("expected a wait of 1s, got %v %v", ok, wait)
+	}
+	if ok, _ := l.allow("/r", "b", limit, now); !ok {
+		t.Error("clients must not share buckets")
+	}
+	if ok, _ := l.allow("/other", "a", limit, now); !ok {
+		t.Error("routes must not share buckets")
+	}
+	if ok, _ := l.allow("/r", "a", limit, now.Add(time.Second)); !ok {
+		t.Error("expected a new token after 1s")
+	}
+	if ok, _ := l.allow("/r", "a", config.RateLimit{}, now); !ok {
+		t.Error("a zero rate must not limit")
+	}
+
+	// Raising the limit applies to existing buckets.
+	if ok, _ := l.allow("/r", "a", config.RateLimit{Rate: 100, Burst: 100}, now.Add(2*time.Second)); !ok {
+		t.Error("expected the raised limit to apply")
+	}
+
+	l.allow("/r", "c", limit, now.Add(rateLimitIdle+3*time.Second))
+	if len(l.buckets) != 1 {
+		t.Errorf("expected idle buckets to be dropped, got %d", len(l.buckets))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	_, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		resultType := "vector"
+		if r.URL.Path == "/api/v1/query_range" {
+			resultType = "matrix"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"` + resultType + `","result":[]}}`))
+	})
+	defer closeProm()
+	setTestLimits(t, func(l *config.LimitsConfig) {
+		l.Rate = 1
+		l.Burst = 2
+		l.Routes = map[string]config.RateLimit{
+			"/api/v1/groupby/metric/{metricID}/duration/{durationID}": {Rate: 0.1, Burst: 1},
+		}
+	})
+	router := newRouter()
+
+	get := func(path string, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	count := "/api/v1/count/metric/ovs_interface_receive_bytes_total"
+	groupby := "/api/v1/groupby/metric/ovs_interface_receive_bytes_total/duration/5m"
+
+	for i := 0; i < 2; i++ {
+		if rec := get(count, "192.0.2.1:1000"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d: %s", i, rec.Code, rec.Body)
+		}
+	}
+	rec := get(count, "192.0.2.1:1001")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get(HEADERRETRYAFTER) != "1" {
+		t.Fatalf("expected 429 with Retry-After 1, got %d %q", rec.Code, rec.Header().Get(HEADERRETRYAFTER))
+	}
+	var resp APIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error.Code != codeRateLimited {
+		t.Errorf("unexpected body %s", rec.Body)
+	}
+	if rec := get(count, "192.0.2.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("expected another client to pass, got %d", rec.Code)
+	}
+	if rec := get("/healthz", "192.0.2.1:1000"); rec.Code != http.StatusOK {
+		t.Errorf("expected public routes to pass, got %d", rec.Code)
+	}
+
+	// groupby has its own, lower limit.
+	if rec := get(groupby, "192.0.2.1:1000"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	rec = get(groupby, "192.0.2.1:1000")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get(HEADERRETRYAFTER) != "10" {
+		t.Errorf("expected 429 with Retry-After 10, got %d %q", rec.Code, rec.Header().Get(HEADERRETRYAFTER))
+	}
+	if n := testutil.ToFloat64(rateLimitedRequests.WithLabelValues("/api/v1/groupby/metric/{metricID}/duration/{durationID}")); n != 1 {
+		t.Errorf("expected 1 limited groupby request, got %v", n)
+	}
+}
+
+func TestRateLimitPrincipal(t *testing.T) {
+	_, closeProm := newTestRouter(t, fakeProm)
+	defer closeProm()
+	setTestAuthenticator(t)
+	setTestLimits(t, func(l *config.LimitsConfig) {
+		l.Rate = 1
+		l.Burst = 1
+	})
+	router := newRouter()
+
+	// The bucket belongs to the API key, whatever the client address.
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/count/metric/ovs_interface_receive_bytes_total", nil)
+		req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1000", i+1)
+		req.Header.Set(HEADERAPIKEY, "admin")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("request %d: expected %d, got %d: %s", i, want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestConcurrentQueryLimit(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	_, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	})
+	defer closeProm()
+	setTestLimits(t, func(l *config.LimitsConfig) {
+		l.MaxConcurrentQueries = 1
+		l.QueueTimeout = config.Duration(20 * time.Millisecond)
+	})
+	router := newRouter()
+	path := "/api/v1/count/metric/ovs_interface_receive_bytes_total"
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		done <- rec.Code
+	}()
+	<-started
+
+	rejected := testutil.ToFloat64(upstreamQueriesRejected)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get(HEADERRETRYAFTER) != "1" {
+		t.Errorf("expected 429 with Retry-After 1, got %d %q: %s", rec.Code, rec.Header().Get(HEADERRETRYAFTER), rec.Body)
+	}
+	var resp APIErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error.Code != codeOverloaded {
+		t.Errorf("unexpected body %s", rec.Body)
+	}
+	if n := testutil.ToFloat64(upstreamQueriesRejected); n != rejected+1 {
+		t.Errorf("expected one more rejected query, got %v", n-rejected)
+	}
+	if n := testutil.ToFloat64(upstreamQuerySlotsInUse); n != 1 {
+		t.Errorf("expected 1 slot in use, got %v", n)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("expected the running query to succeed, got %d", code)
+	}
+	if n := testutil.ToFloat64(upstreamQuerySlotsInUse); n != 0 {
+		t.Errorf("expected the slot to be released, got %v", n)
+	}
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	conn := newTestGRPC(t, fakeProm)
+	setTestLimits(t, func(l *config.LimitsConfig) {
+		l.Routes = map[string]config.RateLimit{
+			"/helios.v1.OVSMetrics/Count": {Rate: 0.5, Burst: 1},
+		}
+	})
+	client := pb.NewOVSMetricsClient(conn)
+	req := &pb.CountRequest{Metric: "ovs_interface_receive_bytes_total"}
+
+	if _, err := client.Count(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	var trailer metadata.MD
+	_, err := client.Count(context.Background(), req, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	if v := trailer.Get(grpcRetryPushback); len(v) != 1 || len(v[0]) != 4 {
+		t.Errorf("expected a pushback of about 2000ms, got %v", v)
+	}
+}
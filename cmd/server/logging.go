@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+)
+
+// logLevel is the level of logger, changed on SIGHUP
+var logLevel = new(slog.LevelVar)
+
+// logger is the server logger. Records get the request ID and trace ID of
+// their context.
+var logger = slog.Default()
+
+// probeRoutes are logged at debug level only, to keep probes and scrapes
+// out of the access log
+var probeRoutes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// newLogger returns a logger writing to w in the configured format.
+func newLogger(w io.Writer, conf config.LogConfig) *slog.Logger {
+	setLogLevel(conf)
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if conf.Format == config.LogFormatJSON {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(ovs_prom_client.NewContextHandler(h))
+}
+
+// setLogLevel applies log.level to logger.
+func setLogLevel(conf config.LogConfig) {
+	switch conf.Level {
+	case config.LogLevelDebug:
+		logLevel.Set(slog.LevelDebug)
+	case config.LogLevelWarn:
+		logLevel.Set(slog.LevelWarn)
+	case config.LogLevelError:
+		logLevel.Set(slog.LevelError)
+	default:
+		logLevel.Set(slog.LevelInfo)
+	}
+}
+
+// fatal logs msg at error level and exits. Deferred calls do not run, so it
+// is only called once nothing is left to close.
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// accessLogMiddleware logs every request once it is served.
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		route := routeTemplate(r)
+		level := slog.LevelInfo
+		if probeRoutes[route] {
+			level = slog.LevelDebug
+		}
+		logger.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", route,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+			"user_agent", r.UserAgent())
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	prev := logger
+	defer func() { logger = prev }()
+	logger = newLogger(&buf, config.LogConfig{Level: config.LogLevelDebug, Format: config.LogFormatJSON})
+
+	router, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"987654321"]}]}}`))
+	})
+	defer closeProm()
+	router.Use(accessLogMiddleware)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/count/metric/ovs_interface_receive_bytes_total", nil)
+	req.Header.Set(HEADERREQUESTID, "req-1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	records := make(map[string]map[string]interface{})
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		records[record["msg"].(string)] = record
+	}
+
+	access, ok := records["request"]
+	if !ok {
+		t.Fatalf("no access log in\n%s", buf.String())
+	}
+	if access["request_id"] != "req-1" || access["route"] != "/api/v1/count/metric/{metricID}" || access["status"] != float64(200) {
+		t.Errorf("unexpected access log %v", access)
+	}
+
+	query, ok := records["prometheus query"]
+	if !ok {
+		t.Fatalf("no query log in\n%s", buf.String())
+	}
+	if query["request_id"] != "req-1" || query["type"] != "count" {
+		t.Errorf("unexpected query log %v", query)
+	}
+	if strings.Contains(buf.String(), "987654321") {
+		t.Errorf("query results must not be logged:\n%s", buf.String())
+	}
+}
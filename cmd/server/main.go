@@ -1,25 +1,27 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"strconv"
+	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/alert"
 	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/enrich"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/filewatch"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/queries"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/saved"
+	"gopkg.in/yaml.v2"
 )
 
-// HOST is prometheus server IP
-const HOST string = "15.165.203.82"
-
-// PORT is prometheus server PORT
-const PORT string = "9090"
-
-// VERSION is OVS Client API Version
-const VERSION string = "v1"
-
 // PARAMMETRIC is metric parameter
 const PARAMMETRIC string = "metricID"
 
@@ -31,217 +33,335 @@ const PARAMDURATION string = "durationID"
 
 // TSMetrics is JSON response struct
 type TSMetrics struct {
-	Metrics []ovs_prom_client.TSMetricObj `json:"metrics"`
+	Metrics  []ovs_prom_client.TSMetricObj `json:"metrics"`
+	Warnings []string                      `json:"warnings,omitempty"`
+}
+
+// cfg is the server configuration, loaded at startup and swapped on SIGHUP
+var cfg atomic.Pointer[config.Config]
+
+// enrichers add workload labels to every query result
+var enrichers []ovs_prom_client.Enricher
+
+// recordedRules are the recording rules of the upstreams read by all clients
+var recordedRules = ovs_prom_client.NewRecordedRules(0)
+
+// newOVSClient returns a client for the configured prometheus server with
+// enrichers, restricted to the scope of the principal of ctx
+func newOVSClient(ctx context.Context) (*ovs_prom_client.OVSClient, error) {
+	conf := cfg.Load()
+	c, err := ovs_prom_client.NewOVSPClilent(conf.Prometheus.Host, conf.Prometheus.Port, conf.Prometheus.Version)
+	if err != nil {
+		return nil, err
+	}
+	c.Timeout = time.Duration(conf.Prometheus.Timeout)
+	c.Retries = conf.Prometheus.Retries
+	for _, up := range conf.Prometheus.Upstreams {
+		c.AddUpstream(up.Name, up.Host, up.Port)
+	}
+	c.Enrichers = enrichers
+	c.Matchers = scopeMatchers(ctx)
+	c.Limiter = queryLimiter
+	c.Recorded = recordedRules
+	c.Observer = queryObserver{}
+	c.Logger = logger
+	return c, nil
 }
 
 func getCountAPIQuery(w http.ResponseWriter, r *http.Request) {
-	c, err := ovs_prom_client.NewOVSPClilent(HOST, PORT, VERSION)
 	pathParams := mux.Vars(r)
-	metricID := ""
+	metricID := pathParams[PARAMMETRIC]
+	if !checkMetric(w, r, metricID) {
+		return
+	}
 
-	if val, ok := pathParams[PARAMMETRIC]; ok {
-		metricID = val
-	} else {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "need a number"}`))
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, r, http.StatusNotAcceptable, codeUnsupportedFormat, err.Error(), r.URL.Query().Get(PARAMFORMAT))
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	c, err := newOVSClient(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "error to create OVSClient")
+		return
+	}
 
 	/*
 		1. Make Query String according to the metricID
-		2. Call OVSClient API : countQuery(metric string) ([]TSMetricObj, error)
-		3. Marsha JSON
+		2. Call OVSClient API : CountQueryContext(ctx, metric string) ([]TSMetricObj, Warnings, error)
+		3. Write the result in the negotiated format
 	*/
 
-	queryResult, err := c.CountQuery(metricID)
+	queryResult, warnings, err := c.CountQueryContext(r.Context(), metricID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "error to get CountQuery"}`))
+		writeUpstreamError(w, r, err, warnings)
 		return
 	}
 
-	respObj := TSMetrics{}
-	respObj.Metrics = queryResult
-
-	resp, err := json.MarshalIndent(&respObj, "", "\t\t")
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "error to marshal JSON"}`))
-		return
+	if err := writeMetrics(w, format, queryResult, warnings); err != nil {
+		logger.ErrorContext(r.Context(), "error to write response", "format", format, "err", err)
 	}
-	w.Write(resp)
 }
 
 func getTopkAPIQuery(w http.ResponseWriter, r *http.Request) {
-	c, err := ovs_prom_client.NewOVSPClilent(HOST, PORT, VERSION)
 	pathParams := mux.Vars(r)
-	metricID := ""
-	durationID := ""
-	rankID := -1
-
-	if val, ok := pathParams[PARAMMETRIC]; ok {
-		metricID = val
-	} else {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "need a metric"}`))
+	metricID := pathParams[PARAMMETRIC]
+	if !checkMetric(w, r, metricID) {
 		return
 	}
 
-	if val, ok := pathParams[PARAMDURATION]; ok {
-		durationID = val
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"message": "need a duration"}`))
-			return
-		}
+	durationID, ok := checkDuration(w, r, pathParams[PARAMDURATION])
+	if !ok {
+		return
+	}
+	rankID, ok := checkRank(w, r, pathParams[PARAMRANK])
+	if !ok {
+		return
 	}
 
-	if val, ok := pathParams[PARAMRANK]; ok {
-		rankID, err = strconv.Atoi(val)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"message": "need a rank"}`))
-			return
-		}
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, r, http.StatusNotAcceptable, codeUnsupportedFormat, err.Error(), r.URL.Query().Get(PARAMFORMAT))
+		return
+	}
+
+	c, err := newOVSClient(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "error to create OVSClient")
+		return
 	}
 
 	/*
 		1. Make Query String
-		2. Call OVSClient API : ntopQueryWithRate(rankSize string, metric string, duration string) ([]TSMetricObj, error)
-		3. Marsha JSON
+		2. Call OVSClient API : NtopQueryWithRateContext(ctx, rankSize int, metric string, duration string) ([]TSMetricObj, Warnings, error)
+		3. Write the result in the negotiated format
 	*/
 
-	queryResult, err := c.NtopQueryWithRate(rankID, metricID, durationID)
+	queryResult, warnings, err := c.NtopQueryWithRateContext(r.Context(), rankID, metricID, durationID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "error to get NtopQueryWithRate"}`))
+		writeUpstreamError(w, r, err, warnings)
 		return
 	}
 
-	respObj := TSMetrics{}
-	respObj.Metrics = queryResult
-
-	resp, err := json.MarshalIndent(&respObj, "", "\t\t")
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "error to marshal JSON"}`))
-		return
+	if err := writeMetrics(w, format, queryResult, warnings); err != nil {
+		logger.ErrorContext(r.Context(), "error to write response", "format", format, "err", err)
 	}
-	w.Write(resp)
 }
 
 func getGroupbyAPIQueryRange(w http.ResponseWriter, r *http.Request) {
-	c, err := ovs_prom_client.NewOVSPClilent(HOST, PORT, VERSION)
 	pathParams := mux.Vars(r)
-	metricID := ""
-	durationID := ""
-
-	if val, ok := pathParams[PARAMMETRIC]; ok {
-		metricID = val
-	} else {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "need a metric"}`))
+	metricID := pathParams[PARAMMETRIC]
+	if !checkMetric(w, r, metricID) {
 		return
 	}
 
-	if val, ok := pathParams[PARAMDURATION]; ok {
-		durationID = val
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"message": "need a duration"}`))
-			return
-		}
+	durationID, ok := checkDuration(w, r, pathParams[PARAMDURATION])
+	if !ok {
+		return
 	}
 
-	/*
-		1. Make Query String
-		2. Call OVSClient API : avgbyQueryWithRate(metric string, duration string) ([]TSMetricObj, error)
-		3. Marsha JSON
-	*/
-	queryResult, err := c.AvgbyQueryWithRate(metricID, durationID)
+	format, err := negotiateFormat(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "error to get AvgbyQueryWithRate"}`))
+		writeError(w, r, http.StatusNotAcceptable, codeUnsupportedFormat, err.Error(), r.URL.Query().Get(PARAMFORMAT))
 		return
 	}
 
-	respObj := TSMetrics{}
-	respObj.Metrics = queryResult
-
-	resp, err := json.MarshalIndent(&respObj, "", "\t\t")
+	c, err := newOVSClient(r.Context())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "error to marshal JSON"}`))
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "error to create OVSClient")
 		return
 	}
-	w.Write(resp)
-}
 
-func post(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(`{"message": "post called"}`))
+	/*
+		1. Make Query String
+		2. Call OVSClient API : AvgbyQueryWithRateStream(ctx, metric string, duration string, fn SeriesFunc) (Warnings, error)
+		3. Write each series in the negotiated format as it is read
+	*/
+	stream := newMetricStream(w, format, c.LabelNames())
+	warnings, err := c.AvgbyQueryWithRateStream(r.Context(), metricID, durationID, stream.write)
+	if err != nil && !stream.started() {
+		writeUpstreamError(w, r, err, warnings)
+		return
+	}
+	if err == nil {
+		err = stream.close(warnings)
+	}
+	if err != nil {
+		logger.ErrorContext(r.Context(), "error to write response", "format", format, "err", err)
+	}
 }
 
-func put(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(`{"message": "put called"}`))
+// newRouter registers every route of the API server. openapi.json must
+// document each of them.
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.NotFoundHandler = tracingMiddleware(metricsMiddleware(requestIDMiddleware(accessLogMiddleware(http.HandlerFunc(notFound)))))
+	r.MethodNotAllowedHandler = tracingMiddleware(metricsMiddleware(requestIDMiddleware(accessLogMiddleware(http.HandlerFunc(methodNotAllowed)))))
+	r.Use(tracingMiddleware, metricsMiddleware, requestIDMiddleware, accessLogMiddleware, authMiddleware, rateLimitMiddleware)
+
+	r.HandleFunc("/healthz", getHealthz).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/readyz", getReadyz).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/version", getVersion).Methods(http.MethodGet)
+	r.Handle("/metrics", metricsHandler()).Methods(http.MethodGet)
+
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/count/metric/{metricID}", getCountAPIQuery).Methods(http.MethodGet)
+	api.HandleFunc("/topk/metric/{metricID}/duration/{durationID}/rank/{rankID}", getTopkAPIQuery).Methods(http.MethodGet)
+	api.HandleFunc("/groupby/metric/{metricID}/duration/{durationID}", getGroupbyAPIQueryRange).Methods(http.MethodGet)
+	api.HandleFunc("/topk/logical_switch/metric/{metricID}/duration/{durationID}/rank/{rankID}", getGroupTopkAPIQuery(enrich.LabelLogicalSwitch)).Methods(http.MethodGet)
+	api.HandleFunc("/topk/logical_router/metric/{metricID}/duration/{durationID}/rank/{rankID}", getGroupTopkAPIQuery(enrich.LabelLogicalRouter)).Methods(http.MethodGet)
+	api.HandleFunc("/topk/tenant/metric/{metricID}/duration/{durationID}/rank/{rankID}", getGroupTopkAPIQuery(enrich.LabelTenant)).Methods(http.MethodGet)
+	api.HandleFunc("/topk/owner/metric/{metricID}/duration/{durationID}/rank/{rankID}", getGroupTopkAPIQuery(enrich.LabelOwner)).Methods(http.MethodGet)
+	api.HandleFunc("/topk/environment/metric/{metricID}/duration/{durationID}/rank/{rankID}", getGroupTopkAPIQuery(enrich.LabelEnvironment)).Methods(http.MethodGet)
+	api.HandleFunc("/stream/topk/metric/{metricID}/duration/{durationID}/rank/{rankID}", getTopkStream).Methods(http.MethodGet)
+	api.HandleFunc("/queries", listNamedQueries).Methods(http.MethodGet)
+	api.HandleFunc("/queries/{queryName}", getNamedAPIQuery).Methods(http.MethodGet)
+	api.HandleFunc("/saved_queries", listSavedQueries).Methods(http.MethodGet)
+	api.HandleFunc("/saved_queries", createSavedQuery).Methods(http.MethodPost)
+	api.HandleFunc("/saved_queries/{id}", getSavedQuery).Methods(http.MethodGet)
+	api.HandleFunc("/saved_queries/{id}", updateSavedQuery).Methods(http.MethodPut)
+	api.HandleFunc("/saved_queries/{id}", deleteSavedQuery).Methods(http.MethodDelete)
+	api.HandleFunc("/dashboards", listDashboards).Methods(http.MethodGet)
+	api.HandleFunc("/dashboards", createDashboard).Methods(http.MethodPost)
+	api.HandleFunc("/dashboards/{id}", getDashboard).Methods(http.MethodGet)
+	api.HandleFunc("/dashboards/{id}", updateDashboard).Methods(http.MethodPut)
+	api.HandleFunc("/dashboards/{id}", deleteDashboard).Methods(http.MethodDelete)
+	api.HandleFunc("/alert_rules", listAlertRules).Methods(http.MethodGet)
+	api.HandleFunc("/alert_rules", createAlertRule).Methods(http.MethodPost)
+	api.HandleFunc("/alert_rules/{id}", getAlertRule).Methods(http.MethodGet)
+	api.HandleFunc("/alert_rules/{id}", updateAlertRule).Methods(http.MethodPut)
+	api.HandleFunc("/alert_rules/{id}", deleteAlertRule).Methods(http.MethodDelete)
+	api.HandleFunc("/alerts", listAlerts).Methods(http.MethodGet)
+	api.HandleFunc("/openapi.json", getOpenAPI).Methods(http.MethodGet)
+	api.HandleFunc("/docs", getDocs).Methods(http.MethodGet)
+
+	return r
 }
 
-func delete(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"message": "delete called"}`))
+func main() {
+	conf, err := config.Load(os.Args[1:], os.LookupEnv, os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logger = newLogger(os.Stderr, conf.Log)
+	slog.SetDefault(logger)
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		out, _ := yaml.Marshal(conf)
+		logger.Debug("configuration", "yaml", string(out))
+	}
+	cfg.Store(conf)
+	setQueryLimits(conf.Limits)
+	recordedRules.SetRefresh(time.Duration(conf.Prometheus.RecordingRulesRefresh))
+
+	// Exit once run has closed the storage and flushed the traces.
+	if err := run(conf); err != nil {
+		fatal("error to run the server", "err", err)
+	}
+	logger.Info("server stopped")
 }
 
-func params(w http.ResponseWriter, r *http.Request) {
-	pathParams := mux.Vars(r)
-	w.Header().Set("Content-Type", "application/json")
+// run serves the API until it is shut down. Whatever it started is stopped
+// before it returns.
+func run(conf *config.Config) error {
+	// Background refreshes stop once the server has shut down.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	userID := -1
-	var err error
-	if val, ok := pathParams["userID"]; ok {
-		userID, err = strconv.Atoi(val)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"message": "need a number"}`))
-			return
+	shutdownTracing, err := setupTracing(ctx, conf.Tracing)
+	if err != nil {
+		return fmt.Errorf("error to set up tracing: %w", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("error to flush traces", "err", err)
 		}
+	}()
+
+	namedQueries, err = queries.NewRegistry(conf.Queries.File)
+	if err != nil {
+		return fmt.Errorf("error to load named queries %s: %w", conf.Queries.File, err)
 	}
+	go namedQueries.Watch(ctx, filewatch.DefaultInterval, func(err error) {
+		logger.Error("error to reload named queries", "file", conf.Queries.File, "err", err)
+	})
+	reloadFiles := []func() error{namedQueries.Reload}
 
-	commentID := -1
-	if val, ok := pathParams["commentID"]; ok {
-		commentID, err = strconv.Atoi(val)
+	if conf.Auth.Enabled() {
+		a, files, err := newAuthenticator(ctx, conf.Auth)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(`{"message": "need a number"}`))
-			return
+			return fmt.Errorf("error to load auth files: %w", err)
 		}
+		authenticator = a
+		reloadFiles = append(reloadFiles, files...)
 	}
 
-	query := r.URL.Query()
-	location := query.Get("location")
+	store, err := saved.Open(conf.Storage.Backend, conf.Storage.Path)
+	if err != nil {
+		return fmt.Errorf("error to open %s storage: %w", conf.Storage.Backend, err)
+	}
+	library = saved.NewLibrary(store)
+	defer library.Close()
+	alertRules = saved.NewCollection[alert.Rule](store, alert.BucketRules)
+
+	refresh := time.Duration(conf.Enrich.RefreshInterval)
+	if addr := conf.Enrich.OVSDBAddress; addr != "" {
+		e := enrich.NewOVSDBEnricher(addr)
+		if err := e.Refresh(); err != nil {
+			logger.Error("error to read OVSDB", "address", addr, "err", err)
+		}
+		go e.Run(ctx, refresh, func(err error) {
+			logger.Error("error to refresh OVSDB", "address", addr, "err", err)
+		})
+		enrichers = append(enrichers, e)
+	}
 
-	w.Write([]byte(fmt.Sprintf(`{"userID": %d, "commentID": %d, "location": "%s" }`, userID, commentID, location)))
-}
+	if addr := conf.Enrich.OVNNBAddress; addr != "" {
+		m := enrich.NewOVNMapper(addr)
+		if err := m.Refresh(); err != nil {
+			logger.Error("error to read OVN Northbound DB", "address", addr, "err", err)
+		}
+		go m.Run(ctx, refresh, func(err error) {
+			logger.Error("error to refresh OVN Northbound DB", "address", addr, "err", err)
+		})
+		enrichers = append(enrichers, m)
+	}
 
-func main() {
-	r := mux.NewRouter()
+	if file := conf.Enrich.MappingFile; file != "" {
+		e, err := enrich.NewStaticEnricher(file)
+		if err != nil {
+			return fmt.Errorf("error to load mapping file %s: %w", file, err)
+		}
+		go e.Watch(ctx, filewatch.DefaultInterval, func(err error) {
+			logger.Error("error to reload mapping file", "file", file, "err", err)
+		})
+		enrichers = append(enrichers, e)
+		reloadFiles = append(reloadFiles, e.Reload)
+	}
 
-	api := r.PathPrefix("/api/v1").Subrouter()
-	api.HandleFunc("/count/metric/{metricID}", getCountAPIQuery).Methods(http.MethodGet)
-	api.HandleFunc("/topk/metric/{metricID}/duration/{durationID}/rank/{rankID}", getTopkAPIQuery).Methods(http.MethodGet)
-	api.HandleFunc("/groupby/metric/{metricID}/duration/{durationID}", getGroupbyAPIQueryRange).Methods(http.MethodGet)
+	// Rules are evaluated once the enrichers are in place.
+	evaluator = newEvaluator(ctx, conf.Alerting)
+	go evaluator.Run(ctx, time.Duration(conf.Alerting.EvaluationInterval))
 
-	// Sample
-	api.HandleFunc("", post).Methods(http.MethodPost)
-	api.HandleFunc("", put).Methods(http.MethodPut)
-	api.HandleFunc("", delete).Methods(http.MethodDelete)
-	api.HandleFunc("/user/{userID}/comment/{commentID}", params).Methods(http.MethodGet)
+	ln, err := net.Listen("tcp", conf.Web.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("error to listen on %s: %w", conf.Web.ListenAddress, err)
+	}
+	logger.Info("listening", "address", ln.Addr().String(), "version", ovs_prom_client.Version().AppVersion)
+
+	if addr := conf.GRPC.ListenAddress; addr != "" {
+		gs, hs, err := listenGRPC(addr)
+		if err != nil {
+			return fmt.Errorf("error to listen for gRPC on %s: %w", addr, err)
+		}
+		defer stopGRPC(gs, hs, time.Duration(conf.Web.ShutdownTimeout))
+	}
 
-	log.Fatal(http.ListenAndServe(":8081", r))
+	return serve(newServer(newRouter(), conf.Web), ln, func() {
+		reloadConfig(reloadFiles...)
+	})
 }
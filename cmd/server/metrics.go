@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// metricsNamespace prefixes the metrics of the API server
+const metricsNamespace string = "helios"
+
+// upstreamDefault is the upstream label of queries to the unnamed
+// prometheus.host and port
+const upstreamDefault string = "default"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	httpInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being served.",
+	})
+
+	upstreamQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_query_duration_seconds",
+		Help:      "Latency of Prometheus queries by upstream and query type, retries counted separately.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"upstream", "type"})
+	upstreamQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_query_errors_total",
+		Help:      "Failed Prometheus queries by upstream and query type.",
+	}, []string{"upstream", "type"})
+	upstreamQueryRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_query_retries_total",
+		Help:      "Retried Prometheus queries by upstream and query type.",
+	}, []string{"upstream", "type"})
+	upstreamInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_queries_in_flight",
+		Help:      "Prometheus queries waiting for an answer by upstream.",
+	}, []string{"upstream"})
+
+	streamClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "stream_clients",
+		Help:      "Clients subscribed to live streams.",
+	})
+	streamQueries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "stream_queries",
+		Help:      "Queries run on behalf of live streams, one per distinct subscription.",
+	})
+
+	rateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests refused by the rate limit by route or gRPC method.",
+	}, []string{"route"})
+	rateLimitBuckets = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limit_buckets",
+		Help:      "Token buckets of clients by route, dropped after 10 minutes idle.",
+	})
+	upstreamQuerySlots = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_query_slots",
+		Help:      "Concurrent Prometheus queries allowed, 0 when unlimited.",
+	}, func() float64 { return float64(queryLimiter.Max()) })
+	upstreamQuerySlotsInUse = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_query_slots_in_use",
+		Help:      "Prometheus queries holding a slot of the concurrency limit.",
+	}, func() float64 { return float64(queryLimiter.InUse()) })
+	upstreamQueriesRejected = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_queries_rejected_total",
+		Help:      "Prometheus queries refused after waiting for a slot of the concurrency limit.",
+	}, func() float64 { return float64(queryLimiter.Rejected()) })
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_lookups_total",
+		Help:      "Cache lookups by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpRequestDuration, httpInFlight,
+		upstreamQueryDuration, upstreamQueryErrors, upstreamQueryRetries, upstreamInFlight,
+		streamClients, streamQueries,
+		rateLimitedRequests, rateLimitBuckets,
+		upstreamQuerySlots, upstreamQuerySlotsInUse, upstreamQueriesRejected,
+		cacheLookups,
+	)
+}
+
+// metricsHandler serves the metrics of the API server. Exemplars are only
+// exposed in the OpenMetrics format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// observeWithExemplar observes v, linked to the trace of ctx when it is
+// sampled.
+func observeWithExemplar(ctx context.Context, o prometheus.Observer, v float64) {
+	sc := trace.SpanContextFromContext(ctx)
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && sc.IsSampled() {
+		eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": sc.TraceID().String()})
+		return
+	}
+	o.Observe(v)
+}
+
+func cacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// statusRecorder remembers the status code and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket handlers take over the connection. The status is
+// recorded as 101 Switching Protocols.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap is used by http.ResponseController.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// routeTemplate returns the template of the matched route, or "none".
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tmpl, err := current.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "none"
+}
+
+// metricsMiddleware counts requests and their latency by route template,
+// e.g. /api/v1/count/metric/{metricID}, to keep the label set bounded.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		observeWithExemplar(r.Context(), httpRequestDuration.WithLabelValues(route, r.Method), time.Since(start).Seconds())
+	})
+}
+
+// queryObserver records the upstream queries of OVSClient
+type queryObserver struct{}
+
+func (queryObserver) StartQuery(ctx context.Context, upstream string, queryType string) func(err error) {
+	if upstream == "" {
+		upstream = upstreamDefault
+	}
+	inFlight := upstreamInFlight.WithLabelValues(upstream)
+	inFlight.Inc()
+	start := time.Now()
+
+	return func(err error) {
+		inFlight.Dec()
+		observeWithExemplar(ctx, upstreamQueryDuration.WithLabelValues(upstream, queryType), time.Since(start).Seconds())
+		if err != nil {
+			upstreamQueryErrors.WithLabelValues(upstream, queryType).Inc()
+		}
+	}
+}
+
+func (queryObserver) RetryQuery(ctx context.Context, upstream string, queryType string, err error) {
+	if upstream == "" {
+		upstream = upstreamDefault
+	}
+	upstreamQueryRetries.WithLabelValues(upstream, queryType).Inc()
+}
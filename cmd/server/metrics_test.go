@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	// Other tests share the registry.
+	httpRequests.Reset()
+	upstreamQueryDuration.Reset()
+	upstreamQueryErrors.Reset()
+	upstreamQueryRetries.Reset()
+
+	var attempts int
+	router, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		// Fail once to be retried, then answer.
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"42"]}]}}`))
+	})
+	defer closeProm()
+	router.Handle("/metrics", metricsHandler())
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/count/metric/ovs_interface_receive_bytes_total", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	for _, want := range []string{
+		`helios_http_requests_total{code="200",method="GET",route="/api/v1/count/metric/{metricID}"} 1`,
+		`helios_upstream_query_errors_total{type="count",upstream="default"} 1`,
+		`helios_upstream_query_retries_total{type="count",upstream="default"} 1`,
+		`helios_upstream_query_duration_seconds_count{type="count",upstream="default"} 2`,
+		`helios_upstream_queries_in_flight{upstream="default"} 0`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("missing %s in\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/queries"
+)
+
+// PARAMQUERY is named query parameter
+const PARAMQUERY string = "queryName"
+
+// namedQueries holds the configured named queries, reloaded on change
+var namedQueries *queries.Registry
+
+// NamedQueries is JSON response struct of the named query list
+type NamedQueries struct {
+	Queries []*queries.Query `json:"queries"`
+}
+
+func listNamedQueries(w http.ResponseWriter, r *http.Request) {
+	respObj := NamedQueries{}
+	respObj.Queries = namedQueries.List()
+
+	resp, err := json.MarshalIndent(&respObj, "", "\t\t")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "error to marshal JSON")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+func getNamedAPIQuery(w http.ResponseWriter, r *http.Request) {
+	pathParams := mux.Vars(r)
+
+	q, ok := namedQueries.Get(pathParams[PARAMQUERY])
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "unknown query", pathParams[PARAMQUERY])
+		return
+	}
+
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, r, http.StatusNotAcceptable, codeUnsupportedFormat, err.Error(), r.URL.Query().Get(PARAMFORMAT))
+		return
+	}
+
+	values := make(map[string]string)
+	for name, val := range r.URL.Query() {
+		if name == PARAMFORMAT || len(val) == 0 {
+			continue
+		}
+		values[name] = val[0]
+	}
+
+	query, err := q.Render(values)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+
+	c, err := newOVSClient(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "error to create OVSClient")
+		return
+	}
+
+	var queryResult []ovs_prom_client.TSMetricObj
+	var warnings []string
+	if q.Type == queries.TypeRange {
+		queryResult, warnings, err = c.QueryRangeContext(r.Context(), query)
+	} else {
+		queryResult, warnings, err = c.QueryContext(r.Context(), query)
+	}
+	if err != nil {
+		writeUpstreamError(w, r, err, warnings)
+		return
+	}
+
+	if err := writeMetrics(w, format, queryResult, warnings); err != nil {
+		logger.ErrorContext(r.Context(), "error to write response", "format", format, "err", err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/saved"
+)
+
+// PARAMID is saved query, dashboard or alert rule ID parameter
+const PARAMID string = "id"
+
+// maxBodyBytes bounds the JSON body of a saved query or dashboard
+const maxBodyBytes int64 = 1 << 20
+
+// library keeps the saved queries and dashboards
+var library *saved.Library
+
+// SavedQueries is JSON response struct of the saved query list
+type SavedQueries struct {
+	Queries []*saved.Query `json:"queries"`
+}
+
+// Dashboards is JSON response struct of the dashboard list
+type Dashboards struct {
+	Dashboards []*saved.Dashboard `json:"dashboards"`
+}
+
+// checkSpec validates a spec against the server limits and the named
+// queries. field prefixes the field of the error.
+func checkSpec(field string, spec saved.QuerySpec) error {
+	if spec.Duration != "" {
+		if _, err := validateDuration(spec.Duration); err != nil {
+			return &saved.ValidationError{Field: field + "duration", Reason: err.Error()}
+		}
+	}
+	if spec.Rank != 0 {
+		if err := validateRank(spec.Rank); err != nil {
+			return &saved.ValidationError{Field: field + "rank", Reason: err.Error()}
+		}
+	}
+	if spec.Type == saved.SpecNamed {
+		if _, ok := namedQueries.Get(spec.Query); !ok {
+			return &saved.ValidationError{Field: field + "query", Reason: fmt.Sprintf("unknown named query %q", spec.Query)}
+		}
+	}
+	return nil
+}
+
+// owner returns the name of the authenticated principal, empty without
+// authentication.
+func owner(r *http.Request) string {
+	if p := principal(r.Context()); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+// readable reports whether the principal of r may read a resource: those
+// restricted by the policy only read their own, as the queries of others may
+// show series out of their scope.
+func readable(r *http.Request, m *saved.Meta) bool {
+	p := principal(r.Context())
+	return p == nil || !p.Scope.Restricted() || m.Owner == p.Name
+}
+
+// readResource decodes the JSON body of r into v.
+func readResource(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, "error to parse JSON body", err.Error())
+		return false
+	}
+	return true
+}
+
+func writeResource(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	resp, err := json.MarshalIndent(v, "", "\t\t")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "error to marshal JSON")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// writeSavedError maps an error of the library to 400 for invalid fields,
+// 403 for resources of another principal, 404 for unknown IDs and 500
+// otherwise.
+func writeSavedError(w http.ResponseWriter, r *http.Request, err error) {
+	var verr *saved.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		writeError(w, r, http.StatusBadRequest, codeInvalidParameter, verr.Error(), verr.Field)
+	case errors.Is(err, saved.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, codeNotFound, "no such resource", mux.Vars(r)[PARAMID])
+	case errors.Is(err, saved.ErrNotOwner):
+		writeError(w, r, http.StatusForbidden, codeForbidden, err.Error())
+	default:
+		logger.ErrorContext(r.Context(), "error to access storage", "err", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "error to access storage")
+	}
+}
+
+func checkSavedQuery(q *saved.Query) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	return checkSpec("spec.", q.Spec)
+}
+
+func checkDashboard(d *saved.Dashboard) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	for i, p := range d.Panels {
+		if err := checkSpec(fmt.Sprintf("panels[%d].spec.", i), p.Spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listSavedQueries(w http.ResponseWriter, r *http.Request) {
+	queries, err := library.Queries()
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	resp := &SavedQueries{Queries: []*saved.Query{}}
+	for _, q := range queries {
+		if readable(r, &q.Meta) {
+			resp.Queries = append(resp.Queries, q)
+		}
+	}
+	writeResource(w, r, http.StatusOK, resp)
+}
+
+func getSavedQuery(w http.ResponseWriter, r *http.Request) {
+	q, err := library.Query(mux.Vars(r)[PARAMID])
+	if err == nil && !readable(r, &q.Meta) {
+		err = saved.ErrNotFound
+	}
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	writeResource(w, r, http.StatusOK, q)
+}
+
+func createSavedQuery(w http.ResponseWriter, r *http.Request) {
+	var q saved.Query
+	if !readResource(w, r, &q) {
+		return
+	}
+	if name := owner(r); name != "" {
+		q.Owner = name
+	}
+
+	err := checkSavedQuery(&q)
+	if err == nil {
+		err = library.CreateQuery(&q)
+	}
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/saved_queries/"+q.ID)
+	writeResource(w, r, http.StatusCreated, &q)
+}
+
+func updateSavedQuery(w http.ResponseWriter, r *http.Request) {
+	var q saved.Query
+	if !readResource(w, r, &q) {
+		return
+	}
+	q.ID = mux.Vars(r)[PARAMID]
+
+	err := checkSavedQuery(&q)
+	if err == nil {
+		err = library.UpdateQuery(&q, owner(r))
+	}
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	writeResource(w, r, http.StatusOK, &q)
+}
+
+func deleteSavedQuery(w http.ResponseWriter, r *http.Request) {
+	if err := library.DeleteQuery(mux.Vars(r)[PARAMID], owner(r)); err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listDashboards(w http.ResponseWriter, r *http.Request) {
+	dashboards, err := library.Dashboards()
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	resp := &Dashboards{Dashboards: []*saved.Dashboard{}}
+	for _, d := range dashboards {
+		if readable(r, &d.Meta) {
+			resp.Dashboards = append(resp.Dashboards, d)
+		}
+	}
+	writeResource(w, r, http.StatusOK, resp)
+}
+
+func getDashboard(w http.ResponseWriter, r *http.Request) {
+	d, err := library.Dashboard(mux.Vars(r)[PARAMID])
+	if err == nil && !readable(r, &d.Meta) {
+		err = saved.ErrNotFound
+	}
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	writeResource(w, r, http.StatusOK, d)
+}
+
+func createDashboard(w http.ResponseWriter, r *http.Request) {
+	var d saved.Dashboard
+	if !readResource(w, r, &d) {
+		return
+	}
+	if name := owner(r); name != "" {
+		d.Owner = name
+	}
+
+	err := checkDashboard(&d)
+	if err == nil {
+		err = library.CreateDashboard(&d)
+	}
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/dashboards/"+d.ID)
+	writeResource(w, r, http.StatusCreated, &d)
+}
+
+func updateDashboard(w http.ResponseWriter, r *http.Request) {
+	var d saved.Dashboard
+	if !readResource(w, r, &d) {
+		return
+	}
+	d.ID = mux.Vars(r)[PARAMID]
+
+	err := checkDashboard(&d)
+	if err == nil {
+		err = library.UpdateDashboard(&d, owner(r))
+	}
+	if err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	writeResource(w, r, http.StatusOK, &d)
+}
+
+func deleteDashboard(w http.ResponseWriter, r *http.Request) {
+	if err := library.DeleteDashboard(mux.Vars(r)[PARAMID], owner(r)); err != nil {
+		writeSavedError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
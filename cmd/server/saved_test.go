@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/saved"
+)
+
+// newTestLibrary serves the API with an empty in-memory library.
+func newTestLibrary(t *testing.T) *mux.Router {
+	_, closeProm := newTestRouter(t, fakeProm)
+	t.Cleanup(closeProm)
+	library = saved.NewLibrary(saved.NewMemoryStore())
+	t.Cleanup(func() { library = nil })
+	return newRouter()
+}
+
+func serveJSON(router http.Handler, method string, path string, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSavedQueries(t *testing.T) {
+	router := newTestLibrary(t)
+
+	body := `{"name": "busiest ports", "spec": {"type": "topk", "metric": "ovs_interface_receive_bytes_total", "duration": "5m", "rank": 10}, "filters": {"tenant": "acme"}}`
+	rec := serveJSON(router, http.MethodPost, "/api/v1/saved_queries", body, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var q saved.Query
+	if err := json.Unmarshal(rec.Body.Bytes(), &q); err != nil {
+		t.Fatal(err)
+	}
+	if q.ID == "" || rec.Header().Get("Location") != "/api/v1/saved_queries/"+q.ID {
+		t.Fatalf("unexpected ID %q and location %q", q.ID, rec.Header().Get("Location"))
+	}
+
+	rec = serveJSON(router, http.MethodGet, "/api/v1/saved_queries/"+q.ID, "", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"busiest ports"`) {
+		t.Errorf("unexpected get %d: %s", rec.Code, rec.Body)
+	}
+
+	body = `{"name": "port count", "spec": {"type": "count", "metric": "ovs_interface_receive_bytes_total"}}`
+	if rec = serveJSON(router, http.MethodPut, "/api/v1/saved_queries/"+q.ID, body, nil); rec.Code != http.StatusOK {
+		t.Errorf("unexpected update %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = serveJSON(router, http.MethodGet, "/api/v1/saved_queries", "", nil)
+	var list SavedQueries
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Queries) != 1 || list.Queries[0].Name != "port count" {
+		t.Errorf("unexpected list %s", rec.Body)
+	}
+
+	if rec = serveJSON(router, http.MethodDelete, "/api/v1/saved_queries/"+q.ID, "", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("unexpected delete %d: %s", rec.Code, rec.Body)
+	}
+	if rec = serveJSON(router, http.MethodGet, "/api/v1/saved_queries/"+q.ID, "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", rec.Code)
+	}
+}
+
+func TestSavedQueryErrors(t *testing.T) {
+	router := newTestLibrary(t)
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		field  string
+	}{
+		{
+			name:   "not JSON",
+			method: http.MethodPost,
+			path:   "/api/v1/saved_queries",
+			body:   `name=x`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown field",
+			method: http.MethodPost,
+			path:   "/api/v1/saved_queries",
+			body:   `{"name": "x", "query": "up"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown type",
+			method: http.MethodPost,
+			path:   "/api/v1/saved_queries",
+			body:   `{"name": "x", "spec": {"type": "sum"}}`,
+			status: http.StatusBadRequest,
+			field:  "spec.type",
+		},
+		{
+			name:   "rank above limits.max-rank",
+			method: http.MethodPost,
+			path:   "/api/v1/saved_queries",
+			body:   `{"name": "x", "spec": {"type": "topk", "metric": "up", "duration": "5m", "rank": 1000}}`,
+			status: http.StatusBadRequest,
+			field:  "spec.rank",
+		},
+		{
+			name:   "panel with a short window",
+			method: http.MethodPost,
+			path:   "/api/v1/dashboards",
+			body:   `{"name": "x", "panels": [{"title": "a", "spec": {"type": "groupby", "metric": "up", "duration": "15s"}}]}`,
+			status: http.StatusBadRequest,
+			field:  "panels[0].spec.duration",
+		},
+		{
+			name:   "unknown ID",
+			method: http.MethodPut,
+			path:   "/api/v1/dashboards/missing",
+			body:   `{"name": "x", "panels": []}`,
+			status: http.StatusNotFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serveJSON(router, tc.method, tc.path, tc.body, nil)
+			if rec.Code != tc.status {
+				t.Fatalf("expected %d, got %d: %s", tc.status, rec.Code, rec.Body)
+			}
+			var resp APIErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if tc.field != "" && (len(resp.Error.Details) != 1 || resp.Error.Details[0] != tc.field) {
+				t.Errorf("expected field %s, got %+v", tc.field, resp.Error)
+			}
+		})
+	}
+}
+
+func TestDashboardOwner(t *testing.T) {
+	router := newTestLibrary(t)
+	setTestAuthenticator(t)
+	wall := map[string]string{HEADERAPIKEY: "wall"}
+	admin := map[string]string{HEADERAPIKEY: "admin"}
+
+	body := `{"name": "noc", "owner": "admin", "panels": [{"title": "ports", "spec": {"type": "count", "metric": "ovs_interface_receive_bytes_total"}}]}`
+	rec := serveJSON(router, http.MethodPost, "/api/v1/dashboards", body, wall)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var d saved.Dashboard
+	if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Owner != "noc-wall" {
+		t.Errorf("expected the principal as owner, got %q", d.Owner)
+	}
+
+	if rec = serveJSON(router, http.MethodGet, "/api/v1/dashboards/"+d.ID, "", admin); rec.Code != http.StatusOK {
+		t.Errorf("expected others to read, got %d", rec.Code)
+	}
+	if rec = serveJSON(router, http.MethodPut, "/api/v1/dashboards/"+d.ID, body, admin); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 to update another's dashboard, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec = serveJSON(router, http.MethodDelete, "/api/v1/dashboards/"+d.ID, "", admin); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 to delete another's dashboard, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec = serveJSON(router, http.MethodDelete, "/api/v1/dashboards/"+d.ID, "", wall); rec.Code != http.StatusNoContent {
+		t.Errorf("expected the owner to delete, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSavedRestrictedRead(t *testing.T) {
+	router := newTestLibrary(t)
+	setTestAuthenticator(t)
+	wall := map[string]string{HEADERAPIKEY: "wall"}
+	admin := map[string]string{HEADERAPIKEY: "admin"}
+
+	create := func(path string, body string, header map[string]string) string {
+		rec := serveJSON(router, http.MethodPost, path, body, header)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+		}
+		var m saved.Meta
+		if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m.ID
+	}
+	query := func(name string) string {
+		return `{"name": "` + name + `", "spec": {"type": "count", "metric": "ovs_interface_receive_bytes_total"}}`
+	}
+	dashboard := func(name string) string {
+		return `{"name": "` + name + `", "panels": [{"title": "ports", "spec": {"type": "count", "metric": "ovs_interface_receive_bytes_total"}}]}`
+	}
+
+	for _, tc := range []struct {
+		path string
+		body func(name string) string
+		list func(body []byte) []string
+	}{
+		{
+			path: "/api/v1/saved_queries",
+			body: query,
+			list: func(body []byte) []string {
+				var resp SavedQueries
+				json.Unmarshal(body, &resp)
+				var names []string
+				for _, q := range resp.Queries {
+					names = append(names, q.Name)
+				}
+				return names
+			},
+		},
+		{
+			path: "/api/v1/dashboards",
+			body: dashboard,
+			list: func(body []byte) []string {
+				var resp Dashboards
+				json.Unmarshal(body, &resp)
+				var names []string
+				for _, d := range resp.Dashboards {
+					names = append(names, d.Name)
+				}
+				return names
+			},
+		},
+	} {
+		adminID := create(tc.path, tc.body("admin's"), admin)
+		wallID := create(tc.path, tc.body("wall's"), wall)
+
+		rec := serveJSON(router, http.MethodGet, tc.path, "", wall)
+		if names := tc.list(rec.Body.Bytes()); rec.Code != http.StatusOK || strings.Join(names, ",") != "wall's" {
+			t.Errorf("%s: expected a restricted principal to list its own only, got %d %v", tc.path, rec.Code, names)
+		}
+		rec = serveJSON(router, http.MethodGet, tc.path, "", admin)
+		if names := tc.list(rec.Body.Bytes()); rec.Code != http.StatusOK || strings.Join(names, ",") != "admin's,wall's" {
+			t.Errorf("%s: expected an unrestricted principal to list all, got %d %v", tc.path, rec.Code, names)
+		}
+
+		if rec = serveJSON(router, http.MethodGet, tc.path+"/"+adminID, "", wall); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404 to read another's, got %d: %s", tc.path, rec.Code, rec.Body)
+		}
+		if rec = serveJSON(router, http.MethodGet, tc.path+"/"+wallID, "", wall); rec.Code != http.StatusOK {
+			t.Errorf("%s: expected the owner to read, got %d: %s", tc.path, rec.Code, rec.Body)
+		}
+		if rec = serveJSON(router, http.MethodGet, tc.path+"/"+wallID, "", admin); rec.Code != http.StatusOK {
+			t.Errorf("%s: expected an unrestricted principal to read, got %d: %s", tc.path, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+)
+
+// newServer returns the API server with the configured timeouts. Its live
+// streams end once it starts shutting down, as they would never finish.
+func newServer(handler http.Handler, web config.WebConfig) *http.Server {
+	streams, stopStreams := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Addr:              web.ListenAddress,
+		Handler:           handler,
+		ReadTimeout:       time.Duration(web.ReadTimeout),
+		ReadHeaderTimeout: time.Duration(web.ReadTimeout),
+		WriteTimeout:      time.Duration(web.WriteTimeout),
+		IdleTimeout:       time.Duration(web.IdleTimeout),
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), streamsContextKey{}, streams)
+		},
+	}
+	srv.RegisterOnShutdown(stopStreams)
+	return srv
+}
+
+// serve runs srv on ln until SIGTERM or SIGINT, then stops accepting
+// connections and lets in-flight requests finish for up to
+// web.shutdown-timeout. SIGHUP calls reload.
+func serve(srv *http.Server, ln net.Listener, reload func()) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				reload()
+				continue
+			}
+
+			timeout := time.Duration(cfg.Load().Web.ShutdownTimeout)
+			logger.Info("draining in-flight requests", "signal", sig.String(), "timeout", timeout)
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+				return fmt.Errorf("error to drain in-flight requests: %v", err)
+			}
+			return nil
+		}
+	}
+}
+
+// reloadConfig reads the configuration again and re-reads the named queries
+// and the static mapping file. On error the running configuration is kept.
+// The listen address, server timeouts, log format, enrichment sources and
+// storage are only read at startup.
+func reloadConfig(reloadFiles ...func() error) {
+	next, err := config.Load(os.Args[1:], os.LookupEnv, os.Stderr)
+	if err != nil {
+		logger.Error("error to reload configuration, keeping the running one", "err", err)
+		return
+	}
+
+	prev := cfg.Load()
+	if next.Web != prev.Web {
+		logger.Warn("settings changed, restart to apply them", "section", "web")
+	}
+	if next.Log.Format != prev.Log.Format {
+		logger.Warn("settings changed, restart to apply them", "section", "log.format")
+	}
+	if next.Enrich != prev.Enrich {
+		logger.Warn("settings changed, restart to apply them", "section", "enrich")
+	}
+	if a, b := next.Auth, prev.Auth; a.APIKeysFile != b.APIKeysFile || a.JWKSFile != b.JWKSFile ||
+		a.JWTIssuer != b.JWTIssuer || a.JWTAudience != b.JWTAudience || a.PolicyFile != b.PolicyFile || a.TenantLabel != b.TenantLabel {
+		logger.Warn("settings changed, restart to apply them", "section", "auth")
+	}
+	if next.Queries != prev.Queries {
+		logger.Warn("settings changed, restart to apply them", "section", "queries")
+	}
+	if next.Storage != prev.Storage {
+		logger.Warn("settings changed, restart to apply them", "section", "storage")
+	}
+	if !reflect.DeepEqual(next.Alerting, prev.Alerting) {
+		logger.Warn("settings changed, restart to apply them", "section", "alerting")
+	}
+	cfg.Store(next)
+	setLogLevel(next.Log)
+	setQueryLimits(next.Limits)
+	recordedRules.SetRefresh(time.Duration(next.Prometheus.RecordingRulesRefresh))
+
+	for _, reloadFile := range reloadFiles {
+		if err := reloadFile(); err != nil {
+			logger.Error("error to reload", "err", err)
+		}
+	}
+	logger.Info("configuration reloaded")
+}
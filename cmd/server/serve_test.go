@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+)
+
+func TestServeDrainsOnSIGTERM(t *testing.T) {
+	cfg.Store(config.Default())
+
+	started := make(chan struct{})
+	srv := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	}), config.Default().Web)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan struct{}, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(srv, ln, func() { reloaded <- struct{}{} })
+	}()
+
+	resp := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			t.Error(err)
+			resp <- ""
+			return
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		resp <- string(body)
+	}()
+	<-started
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("SIGHUP did not reload")
+	}
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	if body := <-resp; body != "done" {
+		t.Errorf("in-flight request was dropped, got %q", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Errorf("server still accepts connections")
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/enrich"
+	"github.com/prometheus/common/model"
+)
+
+// PARAMINTERVAL is query interval parameter of the live streams
+const PARAMINTERVAL string = "interval"
+
+// PARAMTHRESHOLD is relative change parameter of the live streams
+const PARAMTHRESHOLD string = "threshold"
+
+// PARAMFILTER is label filter parameter of the live streams, label=value
+const PARAMFILTER string = "filter"
+
+// Events of the live streams
+const (
+	eventTopK  string = "topk"
+	eventError string = "error"
+)
+
+// streamKeepAlive is the interval of SSE comments and WebSocket pings
+const streamKeepAlive = 15 * time.Second
+
+var upgrader = websocket.Upgrader{}
+
+// streamsContextKey holds the context of the live streams of a server, done
+// once it shuts down
+type streamsContextKey struct{}
+
+// serverClosing returns a channel closed once the server of the request
+// shuts down, or nil outside of newServer.
+func serverClosing(ctx context.Context) <-chan struct{} {
+	if streams, ok := ctx.Value(streamsContextKey{}).(context.Context); ok {
+		return streams.Done()
+	}
+	return nil
+}
+
+// streamKey identifies a subscription. Clients with the same key share one
+// upstream query.
+type streamKey struct {
+	metric    string
+	duration  string
+	rank      int
+	interval  time.Duration
+	threshold float64
+	filter    string
+	scope     string
+}
+
+// streamEvent is one update of a live stream
+type streamEvent struct {
+	id   int
+	name string
+	data []byte
+}
+
+// topkStream runs the query of a subscription on its interval and fans the
+// changed results out to the subscribers.
+type topkStream struct {
+	key      streamKey
+	filters  map[string]string
+	matchers []ovs_prom_client.Matcher
+
+	mu     sync.Mutex
+	subs   map[chan streamEvent]struct{}
+	last   *streamEvent
+	cancel context.CancelFunc
+}
+
+var streams = struct {
+	sync.Mutex
+	m map[streamKey]*topkStream
+}{m: make(map[streamKey]*topkStream)}
+
+// subscribe joins the stream of key, starting it for the first subscriber.
+// The channel first gets the latest event, if any.
+func subscribe(key streamKey, filters map[string]string, matchers []ovs_prom_client.Matcher) (*topkStream, chan streamEvent) {
+	streams.Lock()
+	defer streams.Unlock()
+
+	s, ok := streams.m[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		s = &topkStream{key: key, filters: filters, matchers: matchers, subs: make(map[chan streamEvent]struct{}), cancel: cancel}
+		streams.m[key] = s
+		streamQueries.Inc()
+		go s.run(ctx)
+	}
+
+	ch := make(chan streamEvent, 1)
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	if s.last != nil {
+		ch <- *s.last
+	}
+	s.mu.Unlock()
+	streamClients.Inc()
+	return s, ch
+}
+
+// unsubscribe leaves the stream, stopping it with the last subscriber.
+func (s *topkStream) unsubscribe(ch chan streamEvent) {
+	streams.Lock()
+	defer streams.Unlock()
+
+	s.mu.Lock()
+	delete(s.subs, ch)
+	empty := len(s.subs) == 0
+	s.mu.Unlock()
+	streamClients.Dec()
+
+	if empty {
+		s.cancel()
+		delete(streams.m, s.key)
+		streamQueries.Dec()
+	}
+}
+
+func (s *topkStream) run(ctx context.Context) {
+	ticker := time.NewTicker(s.key.interval)
+	defer ticker.Stop()
+
+	var prev []ovs_prom_client.TSMetricObj
+	var prevErr string
+	id := 0
+	for {
+		metrics, warnings, err := s.query(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err != nil:
+			if err.Error() != prevErr {
+				prevErr = err.Error()
+				id++
+				s.publish(streamEvent{id: id, name: eventError, data: streamError(err)})
+			}
+		case prev == nil || prevErr != "" || rankingChanged(prev, metrics, s.key.threshold):
+			data, err := json.Marshal(&TSMetrics{Metrics: metrics, Warnings: warnings})
+			if err != nil {
+				logger.Error("error to encode stream event", "err", err)
+				break
+			}
+			prev, prevErr = metrics, ""
+			id++
+			s.publish(streamEvent{id: id, name: eventTopK, data: data})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// query runs the ranking. Filters apply to enriched labels, so the rates of
+// every port are fetched and ranked here when there are any.
+func (s *topkStream) query(ctx context.Context) ([]ovs_prom_client.TSMetricObj, []string, error) {
+	c, err := newOVSClient(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Matchers = s.matchers
+	if len(s.filters) == 0 {
+		return c.NtopQueryWithRateContext(ctx, s.key.rank, s.key.metric, s.key.duration)
+	}
+
+	metrics, warnings, err := c.RateQueryContext(ctx, s.key.metric, s.key.duration)
+	if err != nil {
+		return nil, warnings, err
+	}
+	return enrich.TopK(enrich.SortByValue(enrich.Filter(metrics, s.filters)), s.key.rank), warnings, nil
+}
+
+// publish sends ev to every subscriber. A subscriber still holding an older
+// event only gets the newest one.
+func (s *topkStream) publish(ev streamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last = &ev
+	for ch := range s.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- ev
+	}
+}
+
+// streamError encodes a failed query like writeUpstreamError does.
+func streamError(err error) []byte {
+	_, code := classifyUpstreamError(err)
+	data, _ := json.Marshal(&APIErrorResponse{Error: APIError{
+		Code:    code,
+		Message: "error to query prometheus",
+		Details: []string{err.Error()},
+	}})
+	return data
+}
+
+// rankingChanged reports whether the order of the series changed, or a
+// value moved by more than threshold relative to its previous value.
+func rankingChanged(prev, next []ovs_prom_client.TSMetricObj, threshold float64) bool {
+	if len(prev) != len(next) {
+		return true
+	}
+	for i := range next {
+		if labelsKey(prev[i].Labels) != labelsKey(next[i].Labels) {
+			return true
+		}
+		if valueChanged(latestValue(prev[i]), latestValue(next[i]), threshold) {
+			return true
+		}
+	}
+	return false
+}
+
+func valueChanged(prev, next float64, threshold float64) bool {
+	if math.IsNaN(prev) || math.IsNaN(next) {
+		return math.IsNaN(prev) != math.IsNaN(next)
+	}
+	if prev == next {
+		return false
+	}
+	if prev == 0 {
+		return true
+	}
+	return math.Abs(next-prev)/math.Abs(prev) > threshold
+}
+
+func latestValue(metric ovs_prom_client.TSMetricObj) float64 {
+	if len(metric.Vals) == 0 {
+		return math.NaN()
+	}
+	val, err := strconv.ParseFloat(metric.Vals[len(metric.Vals)-1], 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return val
+}
+
+// labelsKey returns labels as a sorted name=value list.
+func labelsKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+strconv.Quote(value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// matchersKey returns matchers as a sorted PromQL matcher list.
+func matchersKey(matchers []ovs_prom_client.Matcher) string {
+	pairs := make([]string, len(matchers))
+	for i, m := range matchers {
+		pairs[i] = m.String()
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// parseStreamParams reads the interval, threshold and filter parameters.
+// The interval defaults to, and may not be shorter than, the scrape
+// interval: the ranking cannot change faster.
+func parseStreamParams(r *http.Request) (time.Duration, float64, map[string]string, error) {
+	query := r.URL.Query()
+
+	interval := time.Duration(cfg.Load().Prometheus.ScrapeInterval)
+	if val := query.Get(PARAMINTERVAL); val != "" {
+		d, err := ovs_prom_client.ParseDuration(val)
+		if err != nil {
+			return 0, 0, nil, &ovs_prom_client.ValidationError{Param: PARAMINTERVAL, Value: val, Reason: "not a duration"}
+		}
+		if d < interval {
+			return 0, 0, nil, &ovs_prom_client.ValidationError{
+				Param:      PARAMINTERVAL,
+				Value:      val,
+				Reason:     "shorter than the scrape interval",
+				Suggestion: model.Duration(interval).String(),
+			}
+		}
+		interval = d
+	}
+
+	threshold := 0.0
+	if val := query.Get(PARAMTHRESHOLD); val != "" {
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, 0, nil, &ovs_prom_client.ValidationError{Param: PARAMTHRESHOLD, Value: val, Reason: "not a ratio, e.g. 0.05 for 5%"}
+		}
+		threshold = f
+	}
+
+	filters := make(map[string]string)
+	for _, val := range query[PARAMFILTER] {
+		name, value, ok := strings.Cut(val, "=")
+		if !ok {
+			return 0, 0, nil, &ovs_prom_client.ValidationError{Param: PARAMFILTER, Value: val, Reason: "not label=value"}
+		}
+		if err := ovs_prom_client.ValidateLabel(name); err != nil {
+			return 0, 0, nil, err
+		}
+		filters[name] = value
+	}
+	return interval, threshold, filters, nil
+}
+
+// getTopkStream pushes the top-K ranking whenever it changes, as Server-Sent
+// Events or, on an upgrade request, over a WebSocket.
+func getTopkStream(w http.ResponseWriter, r *http.Request) {
+	pathParams := mux.Vars(r)
+	metricID := pathParams[PARAMMETRIC]
+	if !checkMetric(w, r, metricID) {
+		return
+	}
+
+	durationID, ok := checkDuration(w, r, pathParams[PARAMDURATION])
+	if !ok {
+		return
+	}
+	rankID, ok := checkRank(w, r, pathParams[PARAMRANK])
+	if !ok {
+		return
+	}
+	interval, threshold, filters, err := parseStreamParams(r)
+	if err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	/*
+		1. Join the stream of the same subscription, or start it
+		2. The stream calls OVSClient API on every interval : NtopQueryWithRateContext, or RateQueryContext when filtered
+		3. Push the events that changed the ranking until the client leaves
+	*/
+	matchers := scopeMatchers(r.Context())
+	key := streamKey{
+		metric:    metricID,
+		duration:  durationID,
+		rank:      rankID,
+		interval:  interval,
+		threshold: threshold,
+		filter:    labelsKey(filters),
+		scope:     matchersKey(matchers),
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		serveWebSocket(w, r, key, filters, matchers)
+		return
+	}
+	serveSSE(w, r, key, filters, matchers)
+}
+
+func serveSSE(w http.ResponseWriter, r *http.Request, key streamKey, filters map[string]string, matchers []ovs_prom_client.Matcher) {
+	rc := http.NewResponseController(w)
+	clearDeadlines(r.Context(), rc)
+
+	s, ch := subscribe(key, filters, matchers)
+	defer s.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	closing := serverClosing(r.Context())
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closing:
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case ev := <-ch:
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.id, ev.name, ev.data)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// clearDeadlines lifts web.read-timeout and web.write-timeout, which would
+// end a stream.
+func clearDeadlines(ctx context.Context, rc *http.ResponseController) {
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		logger.WarnContext(ctx, "error to clear read deadline", "err", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.WarnContext(ctx, "error to clear write deadline", "err", err)
+	}
+}
+
+// streamMessage is a WebSocket message of a live stream
+type streamMessage struct {
+	ID    int             `json:"id"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+func serveWebSocket(w http.ResponseWriter, r *http.Request, key streamKey, filters map[string]string, matchers []ovs_prom_client.Matcher) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has answered with an error already.
+		return
+	}
+	defer conn.Close()
+	if err := conn.NetConn().SetDeadline(time.Time{}); err != nil {
+		logger.WarnContext(r.Context(), "error to clear deadlines", "err", err)
+	}
+
+	// Client messages are ignored; reading notices when the client leaves.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	s, ch := subscribe(key, filters, matchers)
+	defer s.unsubscribe(ch)
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	closing := serverClosing(r.Context())
+
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+		case <-r.Context().Done():
+			return
+		case <-closing:
+			// Hijacked connections are not closed by the shutdown.
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+				logger.WarnContext(r.Context(), "error to close WebSocket", "err", err)
+			}
+			return
+		case <-keepAlive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamKeepAlive))
+		case ev := <-ch:
+			err = conn.WriteJSON(&streamMessage{ID: ev.id, Event: ev.name, Data: ev.data})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const streamPath = "/api/v1/stream/topk/metric/ovs_interface_receive_bytes_total/duration/5m/rank/2"
+
+// newTestStream serves the live streams against a fake Prometheus answering
+// with the port rates of the current phase.
+func newTestStream(t *testing.T, phases [][2]string, phase *atomic.Int32) string {
+	srv, ln := newTestStreamServer(t, phases, phase)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// newTestStreamServer is the server of newTestStream, not yet serving.
+func newTestStreamServer(t *testing.T, phases [][2]string, phase *atomic.Int32) (*http.Server, net.Listener) {
+	_, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		rates := phases[phase.Load()]
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[`+
+			`{"metric":{"bridge":"br-int","port":"a"},"value":[1600000000,%q]},`+
+			`{"metric":{"bridge":"br-int","port":"b"},"value":[1600000000,%q]}]}}`, rates[0], rates[1])
+	})
+	t.Cleanup(closeProm)
+
+	conf := *cfg.Load()
+	conf.Prometheus.ScrapeInterval = config.Duration(20 * time.Millisecond)
+	cfg.Store(&conf)
+
+	r := mux.NewRouter()
+	r.Use(tracingMiddleware, metricsMiddleware, requestIDMiddleware, accessLogMiddleware)
+	r.HandleFunc("/api/v1/stream/topk/metric/{metricID}/duration/{durationID}/rank/{rankID}", getTopkStream)
+
+	// Streams must outlive the read and write timeouts.
+	web := conf.Web
+	web.ReadTimeout = config.Duration(100 * time.Millisecond)
+	web.WriteTimeout = config.Duration(100 * time.Millisecond)
+	srv := newServer(r, web)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, ln
+}
+
+type sseEvent struct {
+	id, name, data string
+}
+
+// readSSE sends the events of the stream at url until it ends.
+func readSSE(t *testing.T, url string) (<-chan sseEvent, func()) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan sseEvent, 10)
+	go func() {
+		defer close(events)
+		var ev sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if ev.name != "" {
+					events <- ev
+				}
+				ev = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events, func() { resp.Body.Close() }
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+	return sseEvent{}
+}
+
+func rankedPorts(t *testing.T, data string) string {
+	t.Helper()
+	var resp TSMetrics
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		t.Fatal(err)
+	}
+	ports := make([]string, 0, len(resp.Metrics))
+	for _, metric := range resp.Metrics {
+		ports = append(ports, metric.Labels["port"])
+	}
+	return strings.Join(ports, ",")
+}
+
+func TestTopkStreamSSE(t *testing.T) {
+	var phase atomic.Int32
+	addr := newTestStream(t, [][2]string{{"100", "50"}, {"101", "50"}, {"100", "200"}}, &phase)
+	url := "http://" + addr + streamPath + "?threshold=0.05"
+
+	events1, close1 := readSSE(t, url)
+	ev := nextEvent(t, events1)
+	if ev.name != eventTopK || ev.id != "1" || rankedPorts(t, ev.data) != "a,b" {
+		t.Fatalf("unexpected first event %+v", ev)
+	}
+
+	// The second client shares the query and gets the latest ranking.
+	events2, close2 := readSSE(t, url)
+	if ev := nextEvent(t, events2); ev.id != "1" {
+		t.Fatalf("unexpected replayed event %+v", ev)
+	}
+	if n := testutil.ToFloat64(streamQueries); n != 1 {
+		t.Errorf("expected one shared query, got %v", n)
+	}
+
+	// A 1% change stays below the threshold.
+	phase.Store(1)
+	time.Sleep(200 * time.Millisecond)
+	phase.Store(2)
+
+	for _, events := range []<-chan sseEvent{events1, events2} {
+		ev := nextEvent(t, events)
+		if ev.id != "2" || rankedPorts(t, ev.data) != "b,a" {
+			t.Errorf("unexpected update %+v", ev)
+		}
+	}
+
+	close1()
+	close2()
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(streamQueries) != 0 || testutil.ToFloat64(streamClients) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream not stopped after the last client left")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTopkStreamWebSocket(t *testing.T) {
+	var phase atomic.Int32
+	addr := newTestStream(t, [][2]string{{"100", "50"}, {"100", "200"}}, &phase)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+streamPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i, want := range []string{"a,b", "b,a"} {
+		var msg streamMessage
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Event != eventTopK || rankedPorts(t, string(msg.Data)) != want {
+			t.Errorf("unexpected message %d: %+v", i, msg)
+		}
+		// Outlive the server timeouts before the update.
+		time.Sleep(200 * time.Millisecond)
+		phase.Store(1)
+	}
+}
+
+func TestTopkStreamParams(t *testing.T) {
+	var phase atomic.Int32
+	addr := newTestStream(t, [][2]string{{"100", "50"}}, &phase)
+
+	for _, tc := range []struct {
+		query string
+		hint  string
+	}{
+		{query: "interval=1ms", hint: "20ms"},
+		{query: "threshold=-1"},
+		{query: "filter=tenant"},
+		{query: "filter=0tenant=acme"},
+	} {
+		resp, err := http.Get("http://" + addr + streamPath + "?" + tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body APIErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || body.Error.Suggestion != tc.hint {
+			t.Errorf("%s: unexpected response %d %+v", tc.query, resp.StatusCode, body.Error)
+		}
+	}
+}
+
+func TestTopkStreamFilter(t *testing.T) {
+	var phase atomic.Int32
+	addr := newTestStream(t, [][2]string{{"100", "50"}}, &phase)
+
+	events, closeStream := readSSE(t, "http://"+addr+streamPath+"?filter=port=b")
+	defer closeStream()
+	if ev := nextEvent(t, events); rankedPorts(t, ev.data) != "b" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestServeEndsStreamsOnSIGTERM(t *testing.T) {
+	var phase atomic.Int32
+	srv, ln := newTestStreamServer(t, [][2]string{{"100", "50"}}, &phase)
+	t.Cleanup(func() { srv.Close() })
+	conf := *cfg.Load()
+	conf.Web.ShutdownTimeout = config.Duration(5 * time.Second)
+	cfg.Store(&conf)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(srv, ln, func() {})
+	}()
+
+	events, closeSSE := readSSE(t, "http://"+ln.Addr().String()+streamPath)
+	defer closeSSE()
+	nextEvent(t, events)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+streamPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var msg streamMessage
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("shutdown waited for the streams")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %s", elapsed)
+	}
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("expected the SSE stream to end")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("SSE stream still open")
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going away close frame, got %v", err)
+	}
+}
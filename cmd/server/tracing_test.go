@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingPropagatesToPrometheus(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var upstreamParent string
+	router, closeProm := newTestRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamParent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"42"]}]}}`))
+	})
+	defer closeProm()
+	router.Use(tracingMiddleware)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/count/metric/ovs_interface_receive_bytes_total", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	if !strings.HasPrefix(upstreamParent, "00-"+traceID+"-") {
+		t.Errorf("trace context not propagated to Prometheus, got %q", upstreamParent)
+	}
+
+	names := make(map[string]bool)
+	for _, span := range spans.Ended() {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("span %s is not in the caller's trace", span.Name())
+		}
+		names[span.Name()] = true
+		if span.Name() == "prometheus.query" {
+			found := false
+			for _, attr := range span.Attributes() {
+				found = found || (attr.Key == ovs_prom_client.AttrPromQL && strings.Contains(attr.Value.AsString(), "ovs_interface_receive_bytes_total"))
+			}
+			if !found {
+				t.Errorf("prometheus.query span without PromQL: %v", span.Attributes())
+			}
+		}
+	}
+	for _, name := range []string{"GET /api/v1/count/metric/{metricID}", "prometheus.query", "prometheus.decode"} {
+		if !names[name] {
+			t.Errorf("missing span %s, got %v", name, names)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/prometheus/common/model"
+)
+
+var validationErrorCodes = map[string]string{
+	ovs_prom_client.ParamMetric:   codeInvalidMetric,
+	ovs_prom_client.ParamDuration: codeInvalidDuration,
+	ovs_prom_client.ParamRank:     codeInvalidRank,
+}
+
+// writeValidationError sends 400 for a *ValidationError, with the suggested
+// value when there is one. It reports whether err was one.
+func writeValidationError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var verr *ovs_prom_client.ValidationError
+	if !errors.As(err, &verr) {
+		return false
+	}
+
+	code, ok := validationErrorCodes[verr.Param]
+	if !ok {
+		code = codeInvalidParameter
+	}
+	writeErrorWithWarnings(w, r, http.StatusBadRequest, APIError{
+		Code:       code,
+		Message:    verr.Error(),
+		Details:    []string{verr.Param, verr.Value},
+		Suggestion: verr.Suggestion,
+	})
+	return true
+}
+
+// checkMetric validates the metric path parameter.
+func checkMetric(w http.ResponseWriter, r *http.Request, metricID string) bool {
+	if err := ovs_prom_client.ValidateMetric(metricID); err != nil {
+		writeValidationError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// checkDuration validates the duration path parameter as a rate() window
+// and returns it in canonical form.
+func checkDuration(w http.ResponseWriter, r *http.Request, durationID string) (string, bool) {
+	duration, err := validateDuration(durationID)
+	if err != nil {
+		writeValidationError(w, r, err)
+		return "", false
+	}
+	return duration, true
+}
+
+// checkRank validates the rank path parameter against limits.max-rank.
+func checkRank(w http.ResponseWriter, r *http.Request, rankID string) (int, bool) {
+	rank, err := strconv.Atoi(rankID)
+	if err != nil {
+		err = &ovs_prom_client.ValidationError{Param: ovs_prom_client.ParamRank, Value: rankID, Reason: "not a number"}
+	} else {
+		err = validateRank(rank)
+	}
+	if err != nil {
+		writeValidationError(w, r, err)
+		return 0, false
+	}
+	return rank, true
+}
+
+// validateDuration checks a rate() window against the scrape interval and
+// limits.max-duration and returns it in canonical form.
+func validateDuration(duration string) (string, error) {
+	conf := cfg.Load()
+	d, err := ovs_prom_client.ParseDuration(duration)
+	if err != nil {
+		return "", err
+	}
+	err = ovs_prom_client.CheckRateWindow(d,
+		time.Duration(conf.Prometheus.ScrapeInterval), time.Duration(conf.Limits.MaxDuration))
+	if err != nil {
+		return "", err
+	}
+	return model.Duration(d).String(), nil
+}
+
+// validateRank checks rank against limits.max-rank.
+func validateRank(rank int) error {
+	return ovs_prom_client.ValidateRank(rank, cfg.Load().Limits.MaxRank)
+}
@@ -0,0 +1,240 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/saved"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// receiver is an httptest webhook that records the payloads it accepts and
+// fails with the statuses of fail first.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	fail     []int
+	attempts int
+	payloads []WebhookPayload
+}
+
+func newReceiver(t *testing.T, fail ...int) *receiver {
+	rcv := &receiver{fail: fail}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.attempts++
+		if len(rcv.fail) > 0 {
+			w.WriteHeader(rcv.fail[0])
+			rcv.fail = rcv.fail[1:]
+			return
+		}
+		var p WebhookPayload
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&p) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rcv.payloads = append(rcv.payloads, p)
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func (rcv *receiver) received() []WebhookPayload {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]WebhookPayload(nil), rcv.payloads...)
+}
+
+func (rcv *receiver) tries() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.attempts
+}
+
+func series(bridge string, port string, value string) ovs_prom_client.TSMetricObj {
+	return ovs_prom_client.TSMetricObj{
+		Labels: map[string]string{"bridge": bridge, "port": port},
+		Vals:   []string{value},
+	}
+}
+
+func TestEvaluator(t *testing.T) {
+	rcv := newReceiver(t)
+	rule := &Rule{
+		Meta:      saved.Meta{ID: "r1", Name: "PortErrors"},
+		Signal:    SignalErrorRatio,
+		Op:        OpGreater,
+		Threshold: 0.01,
+		For:       "5m",
+		Labels:    map[string]string{"severity": "warning"},
+	}
+	rules := []*Rule{rule}
+
+	var result []ovs_prom_client.TSMetricObj
+	var queries []string
+	query := func(ctx context.Context, q string) ([]ovs_prom_client.TSMetricObj, v1.Warnings, error) {
+		queries = append(queries, q)
+		return result, nil, nil
+	}
+	e := NewEvaluator(func() ([]*Rule, error) { return rules, nil }, query, NewWebhook(rcv.URL, 0, time.Second))
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	step := func(d time.Duration, metrics ...ovs_prom_client.TSMetricObj) {
+		t.Helper()
+		now = now.Add(d)
+		result = metrics
+		if err := e.Eval(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	step(0, series("br0", "p1", "0.05"), series("br0", "p2", "0.001"), series("br0", "p3", "NaN"))
+	if !strings.Contains(queries[0], "rate(ovs_interface_receive_errors_total[5m])") {
+		t.Errorf("unexpected query %s", queries[0])
+	}
+	alerts := e.Alerts()
+	if len(alerts) != 1 || alerts[0].State != StatePending || alerts[0].Labels["port"] != "p1" ||
+		alerts[0].Labels[LabelAlertName] != "PortErrors" || alerts[0].Labels["severity"] != "warning" {
+		t.Fatalf("expected p1 pending, got %+v", alerts)
+	}
+	if got := rcv.received(); len(got) != 0 {
+		t.Fatalf("pending alerts must not be notified, got %+v", got)
+	}
+
+	// p2 crosses the threshold but clears before its for duration.
+	step(3*time.Minute, series("br0", "p1", "0.05"), series("br0", "p2", "0.02"))
+	step(time.Minute, series("br0", "p1", "0.05"))
+	if got := rcv.received(); len(got) != 0 {
+		t.Fatalf("unexpected notification %+v", got)
+	}
+
+	step(time.Minute, series("br0", "p1", "0.06"))
+	got := rcv.received()
+	if len(got) != 1 || got[0].Version != WebhookVersion || len(got[0].Alerts) != 1 {
+		t.Fatalf("expected p1 to fire, got %+v", got)
+	}
+	if a := got[0].Alerts[0]; a.State != StateFiring || a.Value != 0.06 || !a.FiredAt.Equal(now) || !a.ActiveAt.Add(5*time.Minute).Equal(now) {
+		t.Errorf("unexpected firing alert %+v", a)
+	}
+
+	// Query errors keep the firing alert.
+	result = nil
+	e.Query = func(ctx context.Context, q string) ([]ovs_prom_client.TSMetricObj, v1.Warnings, error) {
+		return nil, nil, errors.New("prometheus down")
+	}
+	if err := e.Eval(context.Background()); err == nil {
+		t.Error("expected the query error")
+	}
+	if alerts := e.Alerts(); len(alerts) != 1 || alerts[0].State != StateFiring {
+		t.Fatalf("expected p1 to keep firing, got %+v", alerts)
+	}
+
+	e.Query = query
+	step(time.Minute, series("br0", "p1", "0.001"))
+	got = rcv.received()
+	if len(got) != 2 || got[1].Alerts[0].State != StateResolved || !got[1].Alerts[0].ResolvedAt.Equal(now) {
+		t.Fatalf("expected p1 to resolve, got %+v", got)
+	}
+	if alerts := e.Alerts(); len(alerts) != 0 {
+		t.Errorf("expected no alerts, got %+v", alerts)
+	}
+
+	// Without for, alerts fire at once and resolve when the rule is removed.
+	rule.For = ""
+	step(time.Minute, series("br0", "p1", "0.05"))
+	rules = nil
+	step(time.Minute)
+	got = rcv.received()
+	if len(got) != 4 || got[2].Alerts[0].State != StateFiring || got[3].Alerts[0].State != StateResolved {
+		t.Fatalf("expected p1 to fire and resolve, got %+v", got)
+	}
+}
+
+func TestEvaluatorFilters(t *testing.T) {
+	rule := &Rule{
+		Meta:      saved.Meta{ID: "r1", Name: "Busy"},
+		Expr:      "port_utilization",
+		Op:        OpGreaterEqual,
+		Threshold: 0.9,
+		Filters:   map[string]string{"bridge": "br-int"},
+	}
+	e := NewEvaluator(func() ([]*Rule, error) { return []*Rule{rule}, nil },
+		func(ctx context.Context, q string) ([]ovs_prom_client.TSMetricObj, v1.Warnings, error) {
+			if q != "port_utilization" {
+				t.Errorf("unexpected query %s", q)
+			}
+			return []ovs_prom_client.TSMetricObj{series("br-int", "p1", "0.9"), series("br-ex", "p1", "1")}, nil, nil
+		}, nil)
+	if err := e.Eval(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if alerts := e.Alerts(); len(alerts) != 1 || alerts[0].Labels["bridge"] != "br-int" || alerts[0].State != StateFiring {
+		t.Errorf("unexpected alerts %+v", alerts)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	alerts := []Alert{{RuleID: "r1", State: StateFiring, Labels: map[string]string{LabelAlertName: "x"}}}
+
+	rcv := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	w := NewWebhook(rcv.URL, 2, time.Second)
+	w.Backoff = time.Millisecond
+	if err := w.Notify(context.Background(), alerts); err != nil {
+		t.Fatal(err)
+	}
+	if rcv.tries() != 3 || len(rcv.received()) != 1 {
+		t.Errorf("expected 3 attempts and 1 payload, got %d and %d", rcv.tries(), len(rcv.received()))
+	}
+
+	rcv = newReceiver(t, http.StatusBadGateway, http.StatusBadGateway)
+	w = NewWebhook(rcv.URL, 1, time.Second)
+	w.Backoff = time.Millisecond
+	if err := w.Notify(context.Background(), alerts); err == nil || rcv.tries() != 2 {
+		t.Errorf("expected to give up after 2 attempts, got %d: %v", rcv.tries(), err)
+	}
+
+	rcv = newReceiver(t, http.StatusBadRequest)
+	w = NewWebhook(rcv.URL, 3, time.Second)
+	if err := w.Notify(context.Background(), alerts); err == nil || rcv.tries() != 1 {
+		t.Errorf("expected no retry of 400, got %d attempts: %v", rcv.tries(), err)
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	valid := func() *Rule {
+		return &Rule{Meta: saved.Meta{Name: "x"}, Signal: SignalUtilization, Op: OpGreater, Threshold: 0.9}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for field, change := range map[string]func(r *Rule){
+		"name":        func(r *Rule) { r.Name = "" },
+		"signal":      func(r *Rule) { r.Signal = "latency" },
+		"expr":        func(r *Rule) { r.Expr = "up" },
+		"window":      func(r *Rule) { r.Window = "5m])" },
+		"op":          func(r *Rule) { r.Op = "==" },
+		"for":         func(r *Rule) { r.For = "soon" },
+		"filters":     func(r *Rule) { r.Filters = map[string]string{"0bridge": "br0"} },
+		"labels":      func(r *Rule) { r.Labels = map[string]string{LabelAlertName: "y"} },
+		"threshold":   func(r *Rule) { r.Threshold = math.Inf(1) },
+		"annotations": func(r *Rule) { r.Annotations = map[string]string{"summary": "{{.Labels"} },
+	} {
+		r := valid()
+		change(r)
+		var verr *saved.ValidationError
+		if err := r.Validate(); !errors.As(err, &verr) || verr.Field != field {
+			t.Errorf("%s: unexpected error %v", field, err)
+		}
+	}
+}
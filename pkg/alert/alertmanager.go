@@ -0,0 +1,123 @@
+package alert
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertmanagerPath is the alerts endpoint of the Alertmanager v2 API
+const AlertmanagerPath string = "/api/v2/alerts"
+
+// DefaultResendInterval is how often an Alertmanager without ResendInterval
+// is reminded of the firing alerts
+const DefaultResendInterval time.Duration = time.Minute
+
+// PostableAlert is an alert of the Alertmanager v2 API
+type PostableAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt,omitzero"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// Alertmanager posts alerts to the v2 API of an Alertmanager through
+// Webhook. Firing alerts are resent every ResendInterval by Run and expire
+// after four intervals without one, should the server go away; resolved
+// alerts are sent with their EndsAt. ExternalURL, the URL of this server,
+// links every alert to its rule.
+type Alertmanager struct {
+	Webhook        *Webhook
+	ResendInterval time.Duration
+	ExternalURL    string
+
+	now func() time.Time
+	mu  sync.Mutex // orders resends and notifications
+}
+
+// NewAlertmanager returns the Alertmanager at url, e.g.
+// "http://localhost:9093", with the default resend interval.
+func NewAlertmanager(url string, retries int, timeout time.Duration) *Alertmanager {
+	return &Alertmanager{
+		Webhook:        NewWebhook(strings.TrimSuffix(url, "/")+AlertmanagerPath, retries, timeout),
+		ResendInterval: DefaultResendInterval,
+		now:            time.Now,
+	}
+}
+
+// Notify implements Notifier.
+func (am *Alertmanager) Notify(ctx context.Context, alerts []Alert) error {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	return am.post(ctx, alerts)
+}
+
+// Run resends the firing alerts returned by alerts, usually
+// Evaluator.Alerts, every ResendInterval until ctx is done. onError is
+// called with every failed resend.
+func (am *Alertmanager) Run(ctx context.Context, alerts func() []Alert, onError func(error)) {
+	ticker := time.NewTicker(am.resendInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := am.resend(ctx, alerts); err != nil {
+			onError(err)
+		}
+	}
+}
+
+// resend posts the firing alerts. They are read under the lock so that an
+// alert resolved meanwhile is never resent after its resolution.
+func (am *Alertmanager) resend(ctx context.Context, alerts func() []Alert) error {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	var firing []Alert
+	for _, a := range alerts() {
+		if a.State == StateFiring {
+			firing = append(firing, a)
+		}
+	}
+	if len(firing) == 0 {
+		return nil
+	}
+	return am.post(ctx, firing)
+}
+
+func (am *Alertmanager) post(ctx context.Context, alerts []Alert) error {
+	now := time.Now
+	if am.now != nil {
+		now = am.now
+	}
+	expires := now().UTC().Add(4 * am.resendInterval())
+
+	postable := make([]PostableAlert, 0, len(alerts))
+	for _, a := range alerts {
+		p := PostableAlert{
+			Labels:      a.Labels,
+			Annotations: a.Annotations,
+			StartsAt:    a.FiredAt,
+			EndsAt:      expires,
+		}
+		if a.State == StateResolved {
+			p.EndsAt = a.ResolvedAt
+		}
+		if am.ExternalURL != "" {
+			p.GeneratorURL = strings.TrimSuffix(am.ExternalURL, "/") + "/api/v1/alert_rules/" + a.RuleID
+		}
+		postable = append(postable, p)
+	}
+	return am.Webhook.Post(ctx, postable)
+}
+
+func (am *Alertmanager) resendInterval() time.Duration {
+	if am.ResendInterval > 0 {
+		return am.ResendInterval
+	}
+	return DefaultResendInterval
+}
@@ -0,0 +1,150 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/saved"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// fakeAlertmanager records the alerts posted to its v2 API.
+type fakeAlertmanager struct {
+	*httptest.Server
+	mu    sync.Mutex
+	posts [][]PostableAlert
+}
+
+func newFakeAlertmanager(t *testing.T) *fakeAlertmanager {
+	am := &fakeAlertmanager{}
+	am.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != AlertmanagerPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var alerts []PostableAlert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		am.mu.Lock()
+		am.posts = append(am.posts, alerts)
+		am.mu.Unlock()
+	}))
+	t.Cleanup(am.Close)
+	return am
+}
+
+func (am *fakeAlertmanager) received() [][]PostableAlert {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	return append([][]PostableAlert(nil), am.posts...)
+}
+
+func TestAlertmanager(t *testing.T) {
+	fake := newFakeAlertmanager(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	am := NewAlertmanager(fake.URL+"/", 0, time.Second)
+	am.ExternalURL = "http://helios.example.com:8081"
+	am.now = func() time.Time { return now }
+
+	rule := &Rule{
+		Meta:        saved.Meta{ID: "r1", Name: "PortDrops", Description: "Packets are dropped"},
+		Signal:      SignalDropRatio,
+		Op:          OpGreater,
+		Threshold:   0.01,
+		Labels:      map[string]string{LabelSeverity: "critical"},
+		Annotations: map[string]string{"runbook": "drops on {{.Labels.bridge}}/{{.Labels.port}} at {{.Value}}"},
+	}
+	value := "0.5"
+	e := NewEvaluator(func() ([]*Rule, error) { return []*Rule{rule}, nil },
+		func(ctx context.Context, q string) ([]ovs_prom_client.TSMetricObj, v1.Warnings, error) {
+			return []ovs_prom_client.TSMetricObj{series("br-int", "vm1", value)}, nil, nil
+		}, am)
+	e.now = func() time.Time { return now }
+
+	if err := e.Eval(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	posts := fake.received()
+	if len(posts) != 1 || len(posts[0]) != 1 {
+		t.Fatalf("expected one firing alert, got %+v", posts)
+	}
+	a := posts[0][0]
+	for name, want := range map[string]string{"bridge": "br-int", "port": "vm1", "severity": "critical", "alertname": "PortDrops"} {
+		if a.Labels[name] != want {
+			t.Errorf("expected label %s=%q, got %q", name, want, a.Labels[name])
+		}
+	}
+	if a.Annotations["description"] != "Packets are dropped" || a.Annotations["runbook"] != "drops on br-int/vm1 at 0.5" || a.Annotations["summary"] == "" {
+		t.Errorf("unexpected annotations %v", a.Annotations)
+	}
+	if !a.StartsAt.Equal(now) || !a.EndsAt.Equal(now.Add(4*DefaultResendInterval)) {
+		t.Errorf("unexpected times %v %v", a.StartsAt, a.EndsAt)
+	}
+	if a.GeneratorURL != "http://helios.example.com:8081/api/v1/alert_rules/r1" {
+		t.Errorf("unexpected generator URL %q", a.GeneratorURL)
+	}
+
+	// Resends carry the firing alert with a later expiry.
+	now = now.Add(time.Minute)
+	if err := am.resend(context.Background(), e.Alerts); err != nil {
+		t.Fatal(err)
+	}
+	posts = fake.received()
+	if len(posts) != 2 || !posts[1][0].StartsAt.Equal(a.StartsAt) || !posts[1][0].EndsAt.Equal(now.Add(4*DefaultResendInterval)) {
+		t.Fatalf("unexpected resend %+v", posts)
+	}
+
+	now = now.Add(time.Minute)
+	value = "0"
+	if err := e.Eval(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	posts = fake.received()
+	if len(posts) != 3 || !posts[2][0].EndsAt.Equal(now) || posts[2][0].Labels["port"] != "vm1" {
+		t.Fatalf("expected endsAt on resolve, got %+v", posts)
+	}
+	if err := am.resend(context.Background(), e.Alerts); err != nil || len(fake.received()) != 3 {
+		t.Errorf("expected no resend without firing alerts, got %v", err)
+	}
+}
+
+func TestAlertmanagerRun(t *testing.T) {
+	fake := newFakeAlertmanager(t)
+	am := NewAlertmanager(fake.URL, 0, time.Second)
+	am.ResendInterval = 10 * time.Millisecond
+	alerts := func() []Alert {
+		return []Alert{
+			{RuleID: "r1", State: StateFiring, Labels: map[string]string{LabelAlertName: "a"}},
+			{RuleID: "r1", State: StatePending, Labels: map[string]string{LabelAlertName: "b"}},
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		am.Run(ctx, alerts, func(err error) { t.Error(err) })
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.received()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	posts := fake.received()
+	if len(posts) < 2 {
+		t.Fatalf("expected resends, got %+v", posts)
+	}
+	if len(posts[0]) != 1 || posts[0][0].Labels[LabelAlertName] != "a" {
+		t.Errorf("expected only the firing alert, got %+v", posts[0])
+	}
+}
@@ -0,0 +1,267 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/enrich"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// Alert states
+const (
+	StatePending  string = "pending"
+	StateFiring   string = "firing"
+	StateResolved string = "resolved"
+)
+
+// QueryFunc runs an instant query, usually OVSClient.QueryContext
+type QueryFunc func(ctx context.Context, query string) ([]ovs_prom_client.TSMetricObj, v1.Warnings, error)
+
+// Alert is one series of a rule crossing its threshold. Labels are those of
+// the series, the labels of the rule, alertname and severity.
+type Alert struct {
+	RuleID      string            `json:"rule_id"`
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Value       float64           `json:"value"`
+	ActiveAt    time.Time         `json:"active_at"`
+	FiredAt     time.Time         `json:"fired_at,omitzero"`
+	ResolvedAt  time.Time         `json:"resolved_at,omitzero"`
+}
+
+// Evaluator runs the rules returned by Rules through Query and tells
+// Notifier about the alerts that start firing or are resolved. Pending
+// alerts that clear before firing are dropped silently.
+type Evaluator struct {
+	Rules    func() ([]*Rule, error)
+	Query    QueryFunc
+	Notifier Notifier
+	Logger   *slog.Logger
+
+	now func() time.Time
+
+	mu     sync.Mutex
+	active map[string]map[string]*Alert // by rule ID and labels
+}
+
+// NewEvaluator returns an evaluator without active alerts.
+func NewEvaluator(rules func() ([]*Rule, error), query QueryFunc, notifier Notifier) *Evaluator {
+	return &Evaluator{
+		Rules:    rules,
+		Query:    query,
+		Notifier: notifier,
+		Logger:   slog.New(slog.DiscardHandler),
+		now:      time.Now,
+		active:   make(map[string]map[string]*Alert),
+	}
+}
+
+// Alerts returns the pending and firing alerts ordered by rule and labels.
+func (e *Evaluator) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	alerts := make([]Alert, 0)
+	for _, byLabels := range e.active {
+		for _, a := range byLabels {
+			alerts = append(alerts, a.copy())
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].RuleID != alerts[j].RuleID {
+			return alerts[i].RuleID < alerts[j].RuleID
+		}
+		return labelsKey(alerts[i].Labels) < labelsKey(alerts[j].Labels)
+	})
+	return alerts
+}
+
+// Run evaluates the rules every interval until ctx is done.
+func (e *Evaluator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := e.Eval(ctx); err != nil {
+			e.Logger.Error("error to evaluate alert rules", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Eval evaluates every rule once and notifies the changes. A rule whose
+// query fails keeps its alerts until the next evaluation; alerts of removed
+// rules are resolved.
+func (e *Evaluator) Eval(ctx context.Context) error {
+	rules, err := e.Rules()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	results := make(map[string][]ovs_prom_client.TSMetricObj, len(rules))
+	for _, rule := range rules {
+		metrics, _, err := e.Query(ctx, rule.Query())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.ID, err))
+			continue
+		}
+		results[rule.ID] = enrich.Filter(metrics, rule.Filters)
+	}
+
+	e.mu.Lock()
+	now := e.now().UTC()
+	var changed []Alert
+	kept := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		kept[rule.ID] = true
+		if metrics, ok := results[rule.ID]; ok {
+			changed = append(changed, e.update(rule, metrics, now)...)
+		}
+	}
+	for id := range e.active {
+		if !kept[id] {
+			changed = append(changed, e.resolve(id, nil, now)...)
+			delete(e.active, id)
+		}
+	}
+	e.mu.Unlock()
+
+	if len(changed) > 0 && e.Notifier != nil {
+		if err := e.Notifier.Notify(ctx, changed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// update moves the alerts of rule to the states of its latest result and
+// returns those that started firing or were resolved.
+func (e *Evaluator) update(rule *Rule, metrics []ovs_prom_client.TSMetricObj, now time.Time) []Alert {
+	var forDuration time.Duration
+	if rule.For != "" {
+		forDuration, _ = ovs_prom_client.ParseDuration(rule.For)
+	}
+	byLabels := e.active[rule.ID]
+	if byLabels == nil {
+		byLabels = make(map[string]*Alert)
+		e.active[rule.ID] = byLabels
+	}
+
+	var changed []Alert
+	seen := make(map[string]bool, len(metrics))
+	for _, metric := range metrics {
+		value, ok := latest(metric)
+		if !ok || !rule.Matches(value) {
+			continue
+		}
+		labels := alertLabels(rule, metric.Labels)
+		key := labelsKey(labels)
+		seen[key] = true
+
+		a := byLabels[key]
+		if a == nil {
+			a = &Alert{RuleID: rule.ID, State: StatePending, Labels: labels, ActiveAt: now}
+			byLabels[key] = a
+		}
+		a.Value = value
+		a.Annotations = rule.annotations(labels, value)
+		if a.State == StatePending && now.Sub(a.ActiveAt) >= forDuration {
+			a.State = StateFiring
+			a.FiredAt = now
+			changed = append(changed, a.copy())
+		}
+	}
+	return append(changed, e.resolve(rule.ID, seen, now)...)
+}
+
+// resolve drops the alerts of a rule not in seen and returns the firing ones
+// among them as resolved.
+func (e *Evaluator) resolve(id string, seen map[string]bool, now time.Time) []Alert {
+	var resolved []Alert
+	for key, a := range e.active[id] {
+		if seen[key] {
+			continue
+		}
+		if a.State == StateFiring {
+			a.State = StateResolved
+			a.ResolvedAt = now
+			resolved = append(resolved, a.copy())
+		}
+		delete(e.active[id], key)
+	}
+	return resolved
+}
+
+func (a *Alert) copy() Alert {
+	c := *a
+	c.Labels = copyMap(a.Labels)
+	c.Annotations = copyMap(a.Annotations)
+	return c
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// latest returns the latest sample of metric. Values that are not finite,
+// such as the ratios of idle ports, never match.
+func latest(metric ovs_prom_client.TSMetricObj) (float64, bool) {
+	if len(metric.Vals) == 0 {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(metric.Vals[len(metric.Vals)-1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
+}
+
+func alertLabels(rule *Rule, series map[string]string) map[string]string {
+	labels := make(map[string]string, len(series)+len(rule.Labels)+2)
+	for name, value := range series {
+		if name != "__name__" {
+			labels[name] = value
+		}
+	}
+	labels[LabelSeverity] = DefaultSeverity
+	for name, value := range rule.Labels {
+		labels[name] = value
+	}
+	labels[LabelAlertName] = rule.Name
+	return labels
+}
+
+// labelsKey returns labels in a stable form, e.g. `bridge="br0",port="p1"`.
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(labels[name]))
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,222 @@
+// Package alert evaluates threshold rules over the OVS metrics and notifies
+// webhooks and Alertmanagers of the alerts that start and stop firing.
+//
+// A rule compares every series of a signal, or of a PromQL expression, with
+// a threshold:
+//
+//	{
+//	  "name": "port errors",
+//	  "signal": "error_ratio",
+//	  "window": "5m",
+//	  "op": ">",
+//	  "threshold": 0.01,
+//	  "for": "5m"
+//	}
+//
+// Series above the threshold are pending until they have been for the For
+// duration, then firing until they drop below it again and are resolved.
+package alert
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"text/template"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/saved"
+)
+
+// BucketRules is the bucket of the rules in a saved.Store
+const BucketRules string = "alert_rules"
+
+// DefaultWindow is the rate() window of a signal without Window
+const DefaultWindow string = "5m"
+
+// Signals are the per-port series a rule may compare without writing PromQL.
+// The ratios are fractions, e.g. 0.01 for 1%, and bit_rate is in bits per
+// second.
+const (
+	SignalErrorRatio  string = ovs_prom_client.SignalErrorRatio
+	SignalDropRatio   string = ovs_prom_client.SignalDropRatio
+	SignalUtilization string = ovs_prom_client.SignalUtilization
+	SignalBitRate     string = ovs_prom_client.SignalBitRate
+)
+
+// Comparison operators of a rule
+const (
+	OpGreater      string = ">"
+	OpGreaterEqual string = ">="
+	OpLess         string = "<"
+	OpLessEqual    string = "<="
+)
+
+// Labels set on every alert
+const (
+	// LabelAlertName holds the rule name
+	LabelAlertName string = "alertname"
+	// LabelSeverity is DefaultSeverity unless the rule labels set it
+	LabelSeverity string = "severity"
+)
+
+// DefaultSeverity is the severity of a rule without a severity label
+const DefaultSeverity string = "warning"
+
+// Annotations every alert has, unless the rule annotations replace them
+const (
+	AnnotationSummary     string = "summary"
+	AnnotationDescription string = "description"
+)
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Signals returns the names of the signals.
+func Signals() []string {
+	return ovs_prom_client.Signals()
+}
+
+// Rule is a threshold on a signal or an instant vector expression. Filters
+// keep the series whose label has the value, enriched labels included, and
+// Labels are added to each of its alerts. Annotations are text/template
+// templates of the alert labels and value, e.g.
+// "{{.Labels.port}} drops {{.Value}}".
+type Rule struct {
+	saved.Meta
+	Signal      string            `json:"signal,omitempty"`
+	Expr        string            `json:"expr,omitempty"`
+	Window      string            `json:"window,omitempty"`
+	Op          string            `json:"op"`
+	Threshold   float64           `json:"threshold"`
+	For         string            `json:"for,omitempty"`
+	Filters     map[string]string `json:"filters,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// templateData is what the annotation templates of a rule see
+type templateData struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Metadata returns the common fields of r.
+func (r *Rule) Metadata() *saved.Meta { return &r.Meta }
+
+// Validate checks r without the server limits.
+func (r *Rule) Validate() error {
+	invalid := func(field string, reason string) error {
+		return &saved.ValidationError{Field: field, Reason: reason}
+	}
+
+	if r.Name == "" {
+		return invalid("name", "must not be empty")
+	}
+	switch {
+	case r.Signal == "" && r.Expr == "":
+		return invalid("signal", "one of signal or expr is required")
+	case r.Signal != "" && r.Expr != "":
+		return invalid("expr", "must be empty with a signal")
+	case r.Signal != "":
+		if _, ok := ovs_prom_client.SignalExpr(r.Signal, DefaultWindow); !ok {
+			return invalid("signal", fmt.Sprintf("%q is not one of %v", r.Signal, Signals()))
+		}
+		if r.Window != "" {
+			if _, err := ovs_prom_client.ParseDuration(r.Window); err != nil {
+				return invalid("window", err.Error())
+			}
+		}
+	case r.Window != "":
+		return invalid("window", "only applies to a signal")
+	}
+
+	switch r.Op {
+	case OpGreater, OpGreaterEqual, OpLess, OpLessEqual:
+	default:
+		return invalid("op", fmt.Sprintf("%q is not one of >, >=, <, <=", r.Op))
+	}
+	if math.IsNaN(r.Threshold) || math.IsInf(r.Threshold, 0) {
+		return invalid("threshold", "must be a finite number")
+	}
+	if r.For != "" {
+		if _, err := ovs_prom_client.ParseDuration(r.For); err != nil {
+			return invalid("for", err.Error())
+		}
+	}
+	for field, labels := range map[string]map[string]string{"filters": r.Filters, "labels": r.Labels} {
+		for label := range labels {
+			if !labelNamePattern.MatchString(label) {
+				return invalid(field, fmt.Sprintf("%q is not a label name", label))
+			}
+		}
+	}
+	if _, ok := r.Labels[LabelAlertName]; ok {
+		return invalid("labels", fmt.Sprintf("%q is set to the rule name", LabelAlertName))
+	}
+	for name, text := range r.Annotations {
+		if !labelNamePattern.MatchString(name) {
+			return invalid("annotations", fmt.Sprintf("%q is not an annotation name", name))
+		}
+		if _, err := template.New(name).Parse(text); err != nil {
+			return invalid("annotations", err.Error())
+		}
+	}
+	return nil
+}
+
+// annotations returns the annotations of an alert of r. A template that
+// fails is left as it is.
+func (r *Rule) annotations(labels map[string]string, value float64) map[string]string {
+	what := r.Signal
+	if what == "" {
+		what = r.Expr
+	}
+	annotations := map[string]string{
+		AnnotationSummary: fmt.Sprintf("%s %s %s %g", r.Name, what, r.Op, r.Threshold),
+	}
+	if r.Description != "" {
+		annotations[AnnotationDescription] = r.Description
+	}
+	for name, text := range r.Annotations {
+		var b strings.Builder
+		t, err := template.New(name).Parse(text)
+		if err == nil {
+			err = t.Execute(&b, templateData{Labels: labels, Value: value})
+		}
+		if err != nil {
+			annotations[name] = text
+			continue
+		}
+		annotations[name] = b.String()
+	}
+	return annotations
+}
+
+// Query returns the PromQL expression of r. An OVSClient with Recorded rules
+// reads the recorded series of a signal instead.
+func (r *Rule) Query() string {
+	if r.Signal == "" {
+		return r.Expr
+	}
+	window := r.Window
+	if window == "" {
+		window = DefaultWindow
+	}
+	expr, _ := ovs_prom_client.SignalExpr(r.Signal, window)
+	return expr
+}
+
+// Matches reports whether value crosses the threshold of r.
+func (r *Rule) Matches(value float64) bool {
+	switch r.Op {
+	case OpGreater:
+		return value > r.Threshold
+	case OpGreaterEqual:
+		return value >= r.Threshold
+	case OpLess:
+		return value < r.Threshold
+	case OpLessEqual:
+		return value <= r.Threshold
+	}
+	return false
+}
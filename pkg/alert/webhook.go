@@ -0,0 +1,122 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookVersion is the version of the webhook payload
+const WebhookVersion string = "1"
+
+// DefaultBackoff is the delay before the first retry of a webhook
+const DefaultBackoff time.Duration = time.Second
+
+// Notifier is told about alerts that started firing or were resolved
+type Notifier interface {
+	Notify(ctx context.Context, alerts []Alert) error
+}
+
+// Notifiers notifies each of its notifiers in turn.
+type Notifiers []Notifier
+
+// Notify implements Notifier and returns the errors of all notifiers.
+func (n Notifiers) Notify(ctx context.Context, alerts []Alert) error {
+	var errs []error
+	for _, notifier := range n {
+		if err := notifier.Notify(ctx, alerts); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WebhookPayload is the JSON body posted to a webhook
+type WebhookPayload struct {
+	Version string  `json:"version"`
+	Alerts  []Alert `json:"alerts"`
+}
+
+// Webhook posts alerts as a WebhookPayload to URL. Connection errors, 429
+// and 5xx responses are retried up to Retries times, Backoff apart at first
+// and twice as long each time. Timeout bounds each attempt.
+type Webhook struct {
+	URL     string
+	Retries int
+	Backoff time.Duration
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+// NewWebhook returns a webhook of url with the default backoff.
+func NewWebhook(url string, retries int, timeout time.Duration) *Webhook {
+	return &Webhook{URL: url, Retries: retries, Backoff: DefaultBackoff, Timeout: timeout, Client: http.DefaultClient}
+}
+
+// Notify implements Notifier.
+func (w *Webhook) Notify(ctx context.Context, alerts []Alert) error {
+	return w.Post(ctx, &WebhookPayload{Version: WebhookVersion, Alerts: alerts})
+}
+
+// Post sends v as JSON to URL with the retries of w.
+func (w *Webhook) Post(ctx context.Context, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.Retries {
+			return fmt.Errorf("webhook %s: %w", w.URL, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("webhook %s: %w", w.URL, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends body once and reports whether a failure may be retried.
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, fmt.Errorf("unexpected status %s", resp.Status)
+}
@@ -0,0 +1,150 @@
+// Package auth authenticates API clients with static API keys or JWTs and
+// tells which bridges, ports and tenants they may see.
+//
+// An API keys file lists the SHA-256 of every key, so that the file does not
+// hold the keys themselves:
+//
+//	keys:
+//	  - name: noc-wall
+//	    sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//
+// JWTs are verified against the keys of a local JWKS file. The subject of
+// the token is the principal name.
+//
+// A policy file limits principals, API key names or JWT subjects, to label
+// values. A principal without an entry is denied; an entry without lists
+// sees every series. Tenants are only allowed when the series carry a
+// tenant label:
+//
+//	principals:
+//	  noc-wall: {}
+//	  alice@example.com:
+//	    bridges: [br-int]
+//	    tenants: [acme]
+package auth
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/filewatch"
+)
+
+// Authentication methods of a Principal
+const (
+	MethodAPIKey string = "api_key"
+	MethodJWT    string = "jwt"
+)
+
+var (
+	// ErrNoCredentials is returned for a request without an API key or token
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned for an unknown API key or a token
+	// that does not verify
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrForbidden is returned for a principal without a policy entry
+	ErrForbidden = errors.New("principal is not allowed by the policy")
+)
+
+// Principal is an authenticated client
+type Principal struct {
+	Name   string
+	Method string
+	Scope  Scope
+}
+
+// Authenticator checks credentials against the configured sources. Nil
+// sources are not used.
+type Authenticator struct {
+	Keys   *KeyStore
+	JWT    *JWTVerifier
+	Policy *Policy
+}
+
+// Authenticate returns the principal of an API key or, when there is none,
+// of a bearer token.
+func (a *Authenticator) Authenticate(apiKey string, token string) (*Principal, error) {
+	var p *Principal
+	switch {
+	case apiKey != "" && a.Keys != nil:
+		name, ok := a.Keys.Lookup(apiKey)
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+		p = &Principal{Name: name, Method: MethodAPIKey}
+	case token != "" && a.JWT != nil:
+		subject, err := a.JWT.Verify(token)
+		if err != nil {
+			return nil, err
+		}
+		p = &Principal{Name: subject, Method: MethodJWT}
+	case apiKey != "" || token != "":
+		return nil, ErrInvalidCredentials
+	default:
+		return nil, ErrNoCredentials
+	}
+
+	if a.Policy != nil {
+		scope, ok := a.Policy.Scope(p.Name)
+		if !ok {
+			return p, ErrForbidden
+		}
+		p.Scope = scope
+	}
+	return p, nil
+}
+
+// watchedFile holds the parsed content of a file and reloads it when it
+// changes.
+type watchedFile[T any] struct {
+	path  string
+	parse func(content []byte) (T, error)
+
+	mu    sync.RWMutex
+	value T
+}
+
+func newWatchedFile[T any](path string, parse func([]byte) (T, error)) (*watchedFile[T], error) {
+	f := &watchedFile[T]{path: path, parse: parse}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload re-reads the file. On error the current content is kept.
+func (f *watchedFile[T]) Reload() error {
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	value, err := f.parse(content)
+	if err != nil {
+		return &os.PathError{Op: "parse", Path: f.path, Err: err}
+	}
+
+	f.mu.Lock()
+	f.value = value
+	f.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the file whenever it changes, until ctx is cancelled. Reload
+// errors are passed to onError.
+func (f *watchedFile[T]) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	filewatch.Watch(ctx, f.path, interval, func() {
+		if err := f.Reload(); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+func (f *watchedFile[T]) get() T {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.value
+}
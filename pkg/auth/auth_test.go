@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestKeyStore(t *testing.T) {
+	path := writeFile(t, "keys.yaml", []byte("keys:\n  - name: noc-wall\n    sha256: "+keyHash("s3cret")+"\n"))
+	keys, err := NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, ok := keys.Lookup("s3cret"); !ok || name != "noc-wall" {
+		t.Errorf("expected noc-wall, got %q %v", name, ok)
+	}
+	if _, ok := keys.Lookup("S3cret"); ok {
+		t.Error("expected unknown key")
+	}
+
+	for _, content := range []string{
+		"keys:\n  - name: noc-wall\n    sha256: s3cret\n",
+		"keys:\n  - sha256: " + keyHash("s3cret") + "\n",
+		"keys:\n  - name: a\n    sha256: " + keyHash("x") + "\n  - name: b\n    sha256: " + keyHash("x") + "\n",
+	} {
+		if _, err := parseKeys([]byte(content)); err == nil {
+			t.Errorf("expected error for %q", content)
+		}
+	}
+}
+
+// testJWT signs tokens with a fresh key published in a JWKS file.
+type testJWT struct {
+	signer jose.Signer
+	jwks   string
+}
+
+func newTestJWT(t *testing.T) *testJWT {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: key, KeyID: "k1"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "k1", Algorithm: string(jose.ES256), Use: "sig"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testJWT{signer: signer, jwks: writeFile(t, "jwks.json", jwks)}
+}
+
+func (tj *testJWT) token(t *testing.T, claims jwt.Claims) string {
+	t.Helper()
+	token, err := jwt.Signed(tj.signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWTVerifier(t *testing.T) {
+	tj := newTestJWT(t)
+	v, err := NewJWTVerifier(tj.jwks, "https://sso.example.com", "helios")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := jwt.Claims{
+		Subject:  "alice",
+		Issuer:   "https://sso.example.com",
+		Audience: jwt.Audience{"helios"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	if subject, err := v.Verify(tj.token(t, valid)); err != nil || subject != "alice" {
+		t.Fatalf("expected alice, got %q %v", subject, err)
+	}
+
+	expired := valid
+	expired.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	otherIssuer := valid
+	otherIssuer.Issuer = "https://evil.example.com"
+	otherAudience := valid
+	otherAudience.Audience = jwt.Audience{"grafana"}
+	noExpiry := valid
+	noExpiry.Expiry = nil
+	noSubject := valid
+	noSubject.Subject = ""
+
+	for name, token := range map[string]string{
+		"expired":        tj.token(t, expired),
+		"other issuer":   tj.token(t, otherIssuer),
+		"other audience": tj.token(t, otherAudience),
+		"no expiry":      tj.token(t, noExpiry),
+		"no subject":     tj.token(t, noSubject),
+		"other key":      newTestJWT(t).token(t, valid),
+		"not a token":    "s3cret",
+	} {
+		if _, err := v.Verify(token); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: expected ErrInvalidCredentials, got %v", name, err)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys, err := NewKeyStore(writeFile(t, "keys.yaml", []byte(
+		"keys:\n  - name: noc-wall\n    sha256: "+keyHash("wall")+"\n  - name: intern\n    sha256: "+keyHash("intern")+"\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewPolicy(writeFile(t, "policy.yaml", []byte(
+		"principals:\n  noc-wall:\n    bridges: [br-int]\n    tenants: [acme]\n  alice: {}\n")), "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tj := newTestJWT(t)
+	v, err := NewJWTVerifier(tj.jwks, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Authenticator{Keys: keys, JWT: v, Policy: policy}
+
+	p, err := a.Authenticate("wall", "")
+	if err != nil || p.Name != "noc-wall" || p.Method != MethodAPIKey || !p.Scope.Restricted() || p.Scope.Bridges[0] != "br-int" {
+		t.Errorf("unexpected principal %+v %v", p, err)
+	}
+
+	token := tj.token(t, jwt.Claims{Subject: "alice", Expiry: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+	p, err = a.Authenticate("", token)
+	if err != nil || p.Name != "alice" || p.Method != MethodJWT || p.Scope.Restricted() {
+		t.Errorf("unexpected principal %+v %v", p, err)
+	}
+
+	if _, err := a.Authenticate("intern", ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+	if _, err := a.Authenticate("unknown", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := a.Authenticate("", ""); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("expected ErrNoCredentials, got %v", err)
+	}
+	if _, err := (&Authenticator{Keys: keys}).Authenticate("", token); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials without a JWKS file, got %v", err)
+	}
+}
+
+func TestPolicyTenants(t *testing.T) {
+	tenants := []byte("principals:\n  noc-wall:\n    tenants: [acme]\n")
+	bridges := []byte("principals:\n  noc-wall:\n    bridges: [br-int]\n")
+
+	if _, err := NewPolicy(writeFile(t, "policy.yaml", tenants), ""); err == nil || !strings.Contains(err.Error(), "principals.noc-wall") {
+		t.Errorf("expected tenants without a tenant label to be refused, got %v", err)
+	}
+
+	path := writeFile(t, "policy.yaml", bridges)
+	policy, err := NewPolicy(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, tenants, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Reload(); err == nil {
+		t.Error("expected a reload adding tenants to be refused")
+	}
+	if scope, _ := policy.Scope("noc-wall"); len(scope.Bridges) != 1 || len(scope.Tenants) != 0 {
+		t.Errorf("expected the previous policy to be kept, got %+v", scope)
+	}
+
+	policy, err = NewPolicy(path, "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scope, _ := policy.Scope("noc-wall"); policy.TenantLabel != "tenant" || scope.Tenants[0] != "acme" {
+		t.Errorf("unexpected policy %s %+v", policy.TenantLabel, scope)
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// jwtAlgorithms are the signature algorithms accepted for tokens
+var jwtAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// JWTVerifier checks tokens against the keys of a JWKS file. Issuer and
+// Audience are checked when set.
+type JWTVerifier struct {
+	*watchedFile[*jose.JSONWebKeySet]
+	Issuer   string
+	Audience string
+}
+
+// NewJWTVerifier loads the JWKS file at path.
+func NewJWTVerifier(path string, issuer string, audience string) (*JWTVerifier, error) {
+	f, err := newWatchedFile(path, parseJWKS)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTVerifier{watchedFile: f, Issuer: issuer, Audience: audience}, nil
+}
+
+func parseJWKS(content []byte) (*jose.JSONWebKeySet, error) {
+	var keys jose.JSONWebKeySet
+	if err := json.Unmarshal(content, &keys); err != nil {
+		return nil, err
+	}
+	for i, key := range keys.Keys {
+		if !key.IsPublic() {
+			return nil, fmt.Errorf("keys[%d]: %q is not a public key", i, key.KeyID)
+		}
+	}
+	return &keys, nil
+}
+
+// Verify checks the signature, expiry, issuer and audience of token and
+// returns its subject. Tokens must expire and have a subject.
+func (v *JWTVerifier) Verify(token string) (string, error) {
+	tok, err := jwt.ParseSigned(token, jwtAlgorithms)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	var claims jwt.Claims
+	if err := tok.Claims(v.get(), &claims); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	expected := jwt.Expected{Issuer: v.Issuer, Time: time.Now()}
+	if v.Audience != "" {
+		expected.AnyAudience = jwt.Audience{v.Audience}
+	}
+	if err := claims.ValidateWithLeeway(expected, jwt.DefaultLeeway); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if claims.Expiry == nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredentials, errors.New("token does not expire"))
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredentials, errors.New("token has no subject"))
+	}
+	return claims.Subject, nil
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// APIKey is an entry of an API keys file
+type APIKey struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"`
+}
+
+type keysFile struct {
+	Keys []APIKey `yaml:"keys"`
+}
+
+// KeyStore holds the API keys of a file by their hash
+type KeyStore struct {
+	*watchedFile[map[[sha256.Size]byte]string]
+}
+
+// NewKeyStore loads the API keys file at path.
+func NewKeyStore(path string) (*KeyStore, error) {
+	f, err := newWatchedFile(path, parseKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyStore{f}, nil
+}
+
+// parseKeys parses and validates the content of an API keys file.
+func parseKeys(content []byte) (map[[sha256.Size]byte]string, error) {
+	var file keysFile
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[[sha256.Size]byte]string, len(file.Keys))
+	for i, key := range file.Keys {
+		if key.Name == "" {
+			return nil, fmt.Errorf("keys[%d]: name must not be empty", i)
+		}
+		sum, err := hex.DecodeString(key.SHA256)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("keys[%d]: sha256 of %q is not a hex SHA-256 sum", i, key.Name)
+		}
+		var h [sha256.Size]byte
+		copy(h[:], sum)
+		if name, ok := keys[h]; ok {
+			return nil, fmt.Errorf("keys[%d]: key of %q is also the key of %q", i, key.Name, name)
+		}
+		keys[h] = key.Name
+	}
+	return keys, nil
+}
+
+// Lookup returns the name of key. Keys are compared by hash, so lookups
+// take the same time whatever the key.
+func (s *KeyStore) Lookup(key string) (string, bool) {
+	name, ok := s.get()[sha256.Sum256([]byte(key))]
+	return name, ok
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Scope is what a principal may see: series of the listed bridges, ports
+// and tenants. Empty lists do not restrict.
+type Scope struct {
+	Bridges []string `yaml:"bridges,omitempty"`
+	Ports   []string `yaml:"ports,omitempty"`
+	Tenants []string `yaml:"tenants,omitempty"`
+}
+
+// Restricted reports whether the scope limits any label.
+func (s Scope) Restricted() bool {
+	return len(s.Bridges) > 0 || len(s.Ports) > 0 || len(s.Tenants) > 0
+}
+
+type policyFile struct {
+	Principals map[string]Scope `yaml:"principals"`
+}
+
+// labelValuePattern keeps policy values printable; they are quoted into
+// PromQL regular expressions.
+var labelValuePattern = regexp.MustCompile(`^[[:print:]]+$`)
+
+// Policy holds the scopes of a policy file by principal name
+type Policy struct {
+	*watchedFile[map[string]Scope]
+	// TenantLabel is the label of the series the tenants of a scope are
+	// matched on
+	TenantLabel string
+}
+
+// NewPolicy loads the policy file at path. Without tenantLabel the series
+// carry no tenant to match, so scopes listing tenants are refused rather
+// than matching nothing.
+func NewPolicy(path string, tenantLabel string) (*Policy, error) {
+	parse := ParsePolicy
+	if tenantLabel == "" {
+		parse = func(content []byte) (map[string]Scope, error) {
+			scopes, err := ParsePolicy(content)
+			if err != nil {
+				return nil, err
+			}
+			for name, scope := range scopes {
+				if len(scope.Tenants) > 0 {
+					return nil, fmt.Errorf("principals.%s: tenants need a tenant label on the series", name)
+				}
+			}
+			return scopes, nil
+		}
+	}
+
+	f, err := newWatchedFile(path, parse)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{watchedFile: f, TenantLabel: tenantLabel}, nil
+}
+
+// ParsePolicy parses and validates the content of a policy file.
+func ParsePolicy(content []byte) (map[string]Scope, error) {
+	var file policyFile
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, err
+	}
+
+	for name, scope := range file.Principals {
+		for _, values := range [][]string{scope.Bridges, scope.Ports, scope.Tenants} {
+			for _, v := range values {
+				if !labelValuePattern.MatchString(v) {
+					return nil, fmt.Errorf("principals.%s: %q is not a label value", name, v)
+				}
+			}
+		}
+	}
+	if file.Principals == nil {
+		file.Principals = map[string]Scope{}
+	}
+	return file.Principals, nil
+}
+
+// Scope returns the scope of the named principal.
+func (p *Policy) Scope(name string) (Scope, bool) {
+	scope, ok := p.get()[name]
+	return scope, ok
+}
@@ -0,0 +1,144 @@
+package ovs_prom_client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Metric is a counter of the OVS exporter the server ranks and graphs
+type Metric struct {
+	Name string
+	Help string
+}
+
+// Catalog lists the per-port counters of the OVS exporter. Their rates are
+// what the built-in queries compute and what the recording rules precompute.
+var Catalog = []Metric{
+	{"ovs_interface_receive_bytes_total", "Bytes received"},
+	{"ovs_interface_receive_packets_total", "Packets received"},
+	{"ovs_interface_receive_errors_total", "Receive errors"},
+	{"ovs_interface_receive_drop_total", "Packets dropped on receive"},
+	{"ovs_interface_receive_crc_total", "Frames received with a bad CRC"},
+	{"ovs_interface_transmit_bytes_total", "Bytes transmitted"},
+	{"ovs_interface_transmit_packets_total", "Packets transmitted"},
+	{"ovs_interface_transmit_errors_total", "Transmit errors"},
+	{"ovs_interface_transmit_drop_total", "Packets dropped on transmit"},
+	{"ovs_interface_transmit_collisions_total", "Transmit collisions"},
+}
+
+// Signals are the per-port series derived from several counters. The ratios
+// are fractions, e.g. 0.01 for 1%, and bit_rate is in bits per second.
+const (
+	SignalErrorRatio  string = "error_ratio"
+	SignalDropRatio   string = "drop_ratio"
+	SignalUtilization string = "utilization"
+	SignalBitRate     string = "bit_rate"
+)
+
+// RecordLevel is the aggregation level of the recorded series, the first
+// part of their names
+const RecordLevel string = "bridge_port"
+
+// signals are the PromQL expressions of the signals, by their rate() window
+var signals = map[string]string{
+	SignalErrorRatio: `sum by (bridge, port) (rate(ovs_interface_receive_errors_total[%[1]s]) + rate(ovs_interface_transmit_errors_total[%[1]s]))` +
+		` / sum by (bridge, port) (rate(ovs_interface_receive_packets_total[%[1]s]) + rate(ovs_interface_transmit_packets_total[%[1]s]))`,
+	SignalDropRatio: `sum by (bridge, port) (rate(ovs_interface_receive_drop_total[%[1]s]) + rate(ovs_interface_transmit_drop_total[%[1]s]))` +
+		` / sum by (bridge, port) (rate(ovs_interface_receive_packets_total[%[1]s]) + rate(ovs_interface_transmit_packets_total[%[1]s]))`,
+	// The busier direction against the link speed in bits per second;
+	// ports without a known speed are left out.
+	SignalUtilization: `max by (bridge, port) (label_replace(rate(ovs_interface_receive_bytes_total[%[1]s]), "direction", "rx", "", "")` +
+		` or label_replace(rate(ovs_interface_transmit_bytes_total[%[1]s]), "direction", "tx", "", "")) * 8` +
+		` / max by (bridge, port) (ovs_interface_link_speed > 0)`,
+	SignalBitRate: `sum by (bridge, port) (rate(ovs_interface_receive_bytes_total[%[1]s]) + rate(ovs_interface_transmit_bytes_total[%[1]s])) * 8`,
+}
+
+// RecordingRule is an expression Prometheus evaluates ahead of time into the
+// series Record
+type RecordingRule struct {
+	Record string
+	Expr   string
+}
+
+// Signals returns the names of the signals.
+func Signals() []string {
+	names := make([]string, 0, len(signals))
+	for name := range signals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SignalExpr returns the expression of signal over the rate() window, false
+// for an unknown signal.
+func SignalExpr(signal string, window string) (string, bool) {
+	expr, ok := signals[signal]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf(expr, window), true
+}
+
+// RateRule returns the recording of the per-port rate of metric in bits
+// that RateQuery, NtopQueryWithRate and AvgbyQueryWithRate compute, e.g.
+// bridge_port:ovs_interface_receive_bytes_total:bits_rate5m.
+func RateRule(metric string, window string) RecordingRule {
+	return RecordingRule{
+		Record: RecordLevel + ":" + metric + ":bits_rate" + window,
+		Expr:   fmt.Sprintf(avgbyQueryWithRate, metric, window),
+	}
+}
+
+// SignalRule returns the recording of signal over window, e.g.
+// bridge_port:ovs_interface_error_ratio:rate5m, false for an unknown signal.
+func SignalRule(signal string, window string) (RecordingRule, bool) {
+	expr, ok := SignalExpr(signal, window)
+	if !ok {
+		return RecordingRule{}, false
+	}
+	return RecordingRule{Record: RecordLevel + ":ovs_interface_" + signal + ":rate" + window, Expr: expr}, true
+}
+
+// RecordingRules returns the rate of every Catalog metric and every signal
+// over each window.
+func RecordingRules(windows []string) []RecordingRule {
+	var rules []RecordingRule
+	for _, window := range windows {
+		for _, m := range Catalog {
+			rules = append(rules, RateRule(m.Name, window))
+		}
+		for _, signal := range Signals() {
+			rule, _ := SignalRule(signal, window)
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// parseRecord returns the rule of a series named by RateRule or SignalRule,
+// false for any other name.
+func parseRecord(record string) (RecordingRule, bool) {
+	parts := strings.Split(record, ":")
+	if len(parts) != 3 || parts[0] != RecordLevel {
+		return RecordingRule{}, false
+	}
+	if window, ok := strings.CutPrefix(parts[2], "bits_rate"); ok {
+		if ValidateMetric(parts[1]) != nil || !validWindow(window) {
+			return RecordingRule{}, false
+		}
+		return RateRule(parts[1], window), true
+	}
+	window, ok := strings.CutPrefix(parts[2], "rate")
+	signal, isSignal := strings.CutPrefix(parts[1], "ovs_interface_")
+	if !ok || !isSignal || !validWindow(window) {
+		return RecordingRule{}, false
+	}
+	return SignalRule(signal, window)
+}
+
+func validWindow(window string) bool {
+	_, err := ParseDuration(window)
+	return err == nil
+}
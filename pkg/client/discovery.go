@@ -0,0 +1,148 @@
+package ovs_prom_client
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// DiscoveryWindow is how far back series and label values are discovered
+const DiscoveryWindow time.Duration = time.Hour
+
+// Query types of discovery reported to an Observer
+const (
+	QueryTypeSeries      string = "series"
+	QueryTypeLabelValues string = "label_values"
+)
+
+// ParamLabel is the label name checked by ValidateLabel
+const ParamLabel string = "label"
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateLabel checks that label is a plain label name.
+func ValidateLabel(label string) error {
+	if !labelNamePattern.MatchString(label) {
+		return &ValidationError{Param: ParamLabel, Value: label, Reason: "not a label name"}
+	}
+	return nil
+}
+
+// Series is query for the label sets of the series matching a selector, e.g.
+// `ovs_interface_receive_bytes_total{bridge="br-int"}`
+func (c *OVSClient) Series(match string) ([]TSMetricObj, error) {
+	queryResult, _, err := c.SeriesContext(context.Background(), match)
+	return queryResult, err
+}
+
+// SeriesContext is Series with a context and the warnings of the upstreams.
+// Only Labels of the returned objects are set, enriched like query results.
+func (c *OVSClient) SeriesContext(ctx context.Context, match string) ([]TSMetricObj, v1.Warnings, error) {
+	match, err := c.restrictMatch(match)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.fanOut(ctx, QueryTypeSeries, seriesAPIQuery, match)
+}
+
+// LabelValues is query for the values of label, e.g. every bridge, among the
+// series matching a selector. An empty selector matches every series.
+func (c *OVSClient) LabelValues(label string, match string) ([]string, error) {
+	values, _, err := c.LabelValuesContext(context.Background(), label, match)
+	return values, err
+}
+
+// LabelValuesContext is LabelValues with a context and the warnings of the
+// upstreams. Values are merged across upstreams and sorted.
+func (c *OVSClient) LabelValuesContext(ctx context.Context, label string, match string) ([]string, v1.Warnings, error) {
+	if err := ValidateLabel(label); err != nil {
+		return nil, nil, err
+	}
+	match, err := c.restrictMatch(match)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fn := func(ctx context.Context, host string, port string, timeout time.Duration, match string) ([]TSMetricObj, v1.Warnings, error) {
+		return labelValuesAPIQuery(ctx, host, port, timeout, label, match)
+	}
+	queryResult, warnings, err := c.fanOut(ctx, QueryTypeLabelValues, fn, match)
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	seen := make(map[string]bool)
+	values := []string{}
+	for _, metric := range queryResult {
+		if v := metric.Label; !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return values, warnings, nil
+}
+
+func seriesAPIQuery(ctx context.Context, host string, port string, timeout time.Duration, match string) ([]TSMetricObj, v1.Warnings, error) {
+	client, err := newAPIClient(host, port)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v1api := v1.NewAPI(client)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	end := time.Now()
+	spanCtx, span := startSpan(ctx, "prometheus.series", host, port, match)
+	result, warnings, err := v1api.Series(spanCtx, []string{match}, end.Add(-DiscoveryWindow), end)
+	endSpan(span, err)
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	queryResult := make([]TSMetricObj, 0, len(result))
+	for _, set := range result {
+		labels := make(map[string]string, len(set))
+		for name, value := range set {
+			labels[string(name)] = string(value)
+		}
+		queryResult = append(queryResult, TSMetricObj{Label: set.String(), Labels: labels})
+	}
+	return queryResult, warnings, nil
+}
+
+// labelValuesAPIQuery returns one object per value of label, the value in
+// Label.
+func labelValuesAPIQuery(ctx context.Context, host string, port string, timeout time.Duration, label string, match string) ([]TSMetricObj, v1.Warnings, error) {
+	client, err := newAPIClient(host, port)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v1api := v1.NewAPI(client)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var matches []string
+	if match != "" {
+		matches = []string{match}
+	}
+	end := time.Now()
+	spanCtx, span := startSpan(ctx, "prometheus.label_values", host, port, match)
+	span.SetAttributes(AttrLabel.String(label))
+	result, warnings, err := v1api.LabelValues(spanCtx, label, matches, end.Add(-DiscoveryWindow), end)
+	endSpan(span, err)
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	queryResult := make([]TSMetricObj, 0, len(result))
+	for _, value := range result {
+		queryResult = append(queryResult, TSMetricObj{Label: string(value)})
+	}
+	return queryResult, warnings, nil
+}
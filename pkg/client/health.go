@@ -0,0 +1,71 @@
+package ovs_prom_client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// readyQuery is the trivial query run to check that an upstream answers
+const readyQuery string = "vector(1)"
+
+// UpstreamStatus is the readiness of one Prometheus upstream
+type UpstreamStatus struct {
+	Name  string `json:"name,omitempty"`
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// Ready checks every upstream concurrently: its /-/ready endpoint must
+// answer 200 and a trivial query must succeed.
+func (c *OVSClient) Ready(ctx context.Context) []UpstreamStatus {
+	ups := c.upstreams()
+	statuses := make([]UpstreamStatus, len(ups))
+
+	var wg sync.WaitGroup
+	for i, u := range ups {
+		wg.Add(1)
+		go func(i int, u Upstream) {
+			defer wg.Done()
+			statuses[i] = UpstreamStatus{Name: u.Name, Ready: true}
+			if err := checkReady(ctx, u.Host, u.Port, c.timeout()); err != nil {
+				statuses[i].Ready = false
+				statuses[i].Error = err.Error()
+			}
+		}(i, u)
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+func checkReady(ctx context.Context, host string, port string, timeout time.Duration) error {
+	client, err := newAPIClient(host, port)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, client.URL("/-/ready", nil).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, _, err := client.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("/-/ready returned %s", resp.Status)
+	}
+
+	if _, _, err := v1.NewAPI(client).Query(ctx, readyQuery, time.Now()); err != nil {
+		return fmt.Errorf("query %s: %v", readyQuery, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package ovs_prom_client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOverloaded is returned for a query that found no free slot of the
+// QueryLimiter in time
+var ErrOverloaded = errors.New("too many concurrent queries")
+
+// QueryLimiter caps the concurrent upstream queries of the clients sharing
+// it. A query waits up to the queue timeout for a slot.
+type QueryLimiter struct {
+	mu      sync.Mutex
+	max     int
+	timeout time.Duration
+	inUse   int
+	// freed is closed and replaced whenever a slot is released
+	freed    chan struct{}
+	rejected atomic.Uint64
+}
+
+// NewQueryLimiter returns a limiter of max concurrent queries, unlimited
+// when max is not positive.
+func NewQueryLimiter(max int, timeout time.Duration) *QueryLimiter {
+	return &QueryLimiter{max: max, timeout: timeout, freed: make(chan struct{})}
+}
+
+// SetLimits changes the limits; queries already running keep their slots.
+func (l *QueryLimiter) SetLimits(max int, timeout time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max = max
+	l.timeout = timeout
+	l.wake()
+}
+
+// InUse returns the number of running queries.
+func (l *QueryLimiter) InUse() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inUse
+}
+
+// Max returns the query limit, not positive when unlimited.
+func (l *QueryLimiter) Max() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.max
+}
+
+// Rejected returns the number of queries that failed with ErrOverloaded.
+func (l *QueryLimiter) Rejected() uint64 {
+	return l.rejected.Load()
+}
+
+// Acquire waits for a slot and returns the function releasing it. It fails
+// with ErrOverloaded after the queue timeout and with the error of ctx once
+// it is done.
+func (l *QueryLimiter) Acquire(ctx context.Context) (func(), error) {
+	var timer *time.Timer
+	for {
+		l.mu.Lock()
+		if l.max <= 0 || l.inUse < l.max {
+			l.inUse++
+			l.mu.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			return l.release, nil
+		}
+		freed := l.freed
+		if timer == nil {
+			timer = time.NewTimer(l.timeout)
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-timer.C:
+			l.rejected.Add(1)
+			return nil, ErrOverloaded
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *QueryLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inUse--
+	l.wake()
+}
+
+// wake lets waiting queries check for a free slot. l.mu must be held.
+func (l *QueryLimiter) wake() {
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
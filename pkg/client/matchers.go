@@ -0,0 +1,64 @@
+package ovs_prom_client
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParamMatch is the series selector checked against Matchers
+const ParamMatch string = "match"
+
+// ErrRestricted is returned for arbitrary PromQL on a client with Matchers,
+// which cannot be applied to it.
+var ErrRestricted = errors.New("arbitrary queries are not allowed for a restricted client")
+
+// Matcher limits the series of the built-in queries to those whose label
+// Name has one of Values, e.g. the bridges a user may see. Without Values no
+// series matches, not even those without the label.
+type Matcher struct {
+	Name   string
+	Values []string
+}
+
+// String returns the matcher in PromQL, e.g. `bridge=~"br-int|br-ex"`, or
+// `bridge!~".*"` without Values.
+func (m Matcher) String() string {
+	if len(m.Values) == 0 {
+		return m.Name + `!~".*"`
+	}
+	values := make([]string, len(m.Values))
+	for i, v := range m.Values {
+		values[i] = regexp.QuoteMeta(v)
+	}
+	return m.Name + "=~" + strconv.Quote(strings.Join(values, "|"))
+}
+
+// selector returns the selector of metric restricted by the matchers of c,
+// e.g. `ovs_interface_receive_bytes_total{bridge=~"br-int"}`.
+func (c *OVSClient) selector(metric string) string {
+	if len(c.Matchers) == 0 {
+		return metric
+	}
+
+	matchers := make([]string, len(c.Matchers))
+	for i, m := range c.Matchers {
+		matchers[i] = m.String()
+	}
+	sort.Strings(matchers)
+	return metric + "{" + strings.Join(matchers, ",") + "}"
+}
+
+// restrictMatch applies the matchers of c to the selector of a discovery
+// query. A restricted client only accepts a metric name or no selector.
+func (c *OVSClient) restrictMatch(match string) (string, error) {
+	if len(c.Matchers) == 0 {
+		return match, nil
+	}
+	if match != "" && ValidateMetric(match) != nil {
+		return "", &ValidationError{Param: ParamMatch, Value: match, Reason: "only a metric name is allowed for a restricted client"}
+	}
+	return c.selector(match), nil
+}
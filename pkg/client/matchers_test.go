@@ -0,0 +1,60 @@
+package ovs_prom_client
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatcherString(t *testing.T) {
+	for _, tc := range []struct {
+		matcher Matcher
+		want    string
+	}{
+		{Matcher{Name: "bridge", Values: []string{"br-int"}}, `bridge=~"br-int"`},
+		{Matcher{Name: "port", Values: []string{"vm.1", `a"b`}}, `port=~"vm\\.1|a\"b"`},
+		// `port=~""` would match every series without the label
+		{Matcher{Name: "port"}, `port!~".*"`},
+		{Matcher{Name: "port", Values: []string{}}, `port!~".*"`},
+	} {
+		if got := tc.matcher.String(); got != tc.want {
+			t.Errorf("%+v: got %s, want %s", tc.matcher, got, tc.want)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	c := &OVSClient{}
+	if got := c.selector("m"); got != "m" {
+		t.Errorf("unrestricted selector %s", got)
+	}
+
+	c.Matchers = []Matcher{{Name: "port", Values: []string{"p1"}}, {Name: "bridge"}}
+	if got := c.selector("m"); got != `m{bridge!~".*",port=~"p1"}` {
+		t.Errorf("unexpected selector %s", got)
+	}
+	if got, err := c.restrictMatch(""); err != nil || got != `{bridge!~".*",port=~"p1"}` {
+		t.Errorf("unexpected match %s %v", got, err)
+	}
+	var verr *ValidationError
+	if _, err := c.restrictMatch(`m{bridge="x"}`); !errors.As(err, &verr) {
+		t.Errorf("expected a selector to be refused, got %v", err)
+	}
+}
+
+func TestRestrictedQueriesDenyWithoutValues(t *testing.T) {
+	p := newFakePrometheus(t, func(path string, query string) (int, string) {
+		return vector()
+	})
+	c := p.client(t)
+	c.Matchers = []Matcher{{Name: "tenant"}}
+
+	if _, err := c.RateQuery("ovs_interface_receive_bytes_total", "5m"); err != nil {
+		t.Fatal(err)
+	}
+	if q := p.lastQuery(); q != `avg by(bridge, port) (rate(ovs_interface_receive_bytes_total{tenant!~".*"}[5m])*8)` {
+		t.Errorf("unexpected query %s", q)
+	}
+	if _, err := c.Query("up"); err != ErrRestricted {
+		t.Errorf("expected arbitrary queries to be refused, got %v", err)
+	}
+}
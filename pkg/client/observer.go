@@ -0,0 +1,98 @@
+package ovs_prom_client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// Query types reported to an Observer
+const (
+	QueryTypeTopK       string = "topk"
+	QueryTypeRate       string = "rate"
+	QueryTypeCount      string = "count"
+	QueryTypeAvgBy      string = "avgby"
+	QueryTypeInstant    string = "query"
+	QueryTypeQueryRange string = "query_range"
+)
+
+// retryBackoff is the wait before the first retry, doubled on every retry
+const retryBackoff time.Duration = 100 * time.Millisecond
+
+// Observer is told about every upstream query of a client, e.g. to export
+// metrics. Upstream is empty for a client without named upstreams.
+type Observer interface {
+	// StartQuery is called before every attempt of a query. The returned
+	// function is called with the outcome of the attempt.
+	StartQuery(ctx context.Context, upstream string, queryType string) func(err error)
+	// RetryQuery is called before a failed query is repeated.
+	RetryQuery(ctx context.Context, upstream string, queryType string, err error)
+}
+
+// query runs fn on the upstream u, retrying transient failures up to
+// c.Retries times within the client timeout. Every attempt takes a slot of
+// c.Limiter, if any.
+func (c *OVSClient) query(ctx context.Context, u Upstream, queryType string, fn queryFunc, query string) ([]TSMetricObj, v1.Warnings, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		release := func() {}
+		if c.Limiter != nil {
+			var err error
+			if release, err = c.Limiter.Acquire(ctx); err != nil {
+				c.logger().WarnContext(ctx, "prometheus query not started",
+					"upstream", u.Name, "type", queryType, "err", err)
+				return nil, nil, err
+			}
+		}
+		var done func(error)
+		if c.Observer != nil {
+			done = c.Observer.StartQuery(ctx, u.Name, queryType)
+		}
+		start := time.Now()
+		metrics, warnings, err := fn(ctx, u.Host, u.Port, c.timeout(), query)
+		release()
+		if done != nil {
+			done(err)
+		}
+		c.logger().DebugContext(ctx, "prometheus query",
+			"upstream", u.Name, "type", queryType, "query", query, "attempt", attempt,
+			"duration", time.Since(start), "series", len(metrics), "warnings", len(warnings), "err", err)
+		if err == nil || attempt >= c.Retries || !retryable(err) {
+			return metrics, warnings, err
+		}
+
+		c.logger().WarnContext(ctx, "retrying prometheus query",
+			"upstream", u.Name, "type", queryType, "backoff", backoff, "err", err)
+		if c.Observer != nil {
+			c.Observer.RetryQuery(ctx, u.Name, queryType, err)
+		}
+		select {
+		case <-ctx.Done():
+			return metrics, warnings, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether err may go away on retry: a server error of
+// Prometheus or a failed connection. Rejected queries and timeouts are not
+// retried.
+func retryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var promErr *v1.Error
+	if errors.As(err, &promErr) {
+		return promErr.Type == v1.ErrServer
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && !netErr.Timeout()
+}
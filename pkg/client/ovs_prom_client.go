@@ -18,12 +18,15 @@ package ovs_prom_client
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
-	"github.com/prometheus/common/log"
 )
 
 const ovsInterfaceReceiveBytesTotal string = "ovs_interface_receive_bytes_total"
@@ -44,18 +47,43 @@ const ntopQueryWithRate string = "topk(%d, avg by (bridge, port)(rate(%s[%s])*8)
 const countQuery string = "count(count by (bridge, port)(%s))"                     // metric
 const avgbyQueryWithRate string = "avg by(bridge, port) (rate(%s[%s])*8)"          // metric, duration
 
+// DefaultTimeout is the query timeout of a client without Timeout
+const DefaultTimeout time.Duration = 10 * time.Second
+
 // TSMetricObj struct is response structutre of metric query
 type TSMetricObj struct {
-	Label      string   `jsong:"label"`
-	Vals       []string `jsong:"vals"`
-	TimeSeries []string `jsong:"timeseries"`
+	Label      string            `jsong:"label"`
+	Labels     map[string]string `jsong:"labels"`
+	Vals       []string          `jsong:"vals"`
+	TimeSeries []string          `jsong:"timeseries"`
+}
+
+// Enricher attaches extra labels to query results, e.g. the workload behind
+// a port. It must only add to TSMetricObj.Labels.
+type Enricher interface {
+	Enrich(metrics []TSMetricObj)
 }
 
-// OVSClient struct is client for interconnection with prometheus server
+// OVSClient struct is client for interconnection with prometheus server.
+// Queries go to Host and Port, or to every one of Upstreams when set.
+// Transient failures are retried up to Retries times within Timeout.
+// Matchers restrict the series of every query and Limiter, often shared by
+// several clients, caps their concurrent queries. Rates and signals the
+// upstreams record, as listed by Recorded, are read instead of computed.
+// Nothing is logged unless Logger is set.
 type OVSClient struct {
-	Host    string
-	Port    string
-	Version string
+	Host      string
+	Port      string
+	Version   string
+	Timeout   time.Duration
+	Retries   int
+	Upstreams []Upstream
+	Enrichers []Enricher
+	Matchers  []Matcher
+	Limiter   *QueryLimiter
+	Recorded  *RecordedRules
+	Observer  Observer
+	Logger    *slog.Logger
 }
 
 // NewOVSPClilent returns an initialized Client.
@@ -64,14 +92,74 @@ func NewOVSPClilent(host string, port string, version string) (*OVSClient, error
 		Host:    host,
 		Port:    port,
 		Version: version,
+		Timeout: DefaultTimeout,
+		Logger:  slog.New(slog.DiscardHandler),
 	}
-
-	log.Debug("NewOVSPClilent() initialized successfully")
 	return &c, nil
 }
 
+// logger returns the logger of c, discarding when none is set.
+func (c *OVSClient) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.New(slog.DiscardHandler)
+}
+
+// enrich runs the enrichers of c over a query result.
+func (c *OVSClient) enrich(metrics []TSMetricObj) {
+	for _, e := range c.Enrichers {
+		e.Enrich(metrics)
+	}
+}
+
+// parseLabels expands a series key such as `{bridge="br0", port="eth0"}` into
+// its label pairs. Keys without label pairs (e.g. "count") yield an empty map.
+func parseLabels(key string) map[string]string {
+	labels := make(map[string]string)
+	s := strings.TrimSpace(key)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, ", ")
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " ")
+		if !strings.HasPrefix(s, "\"") {
+			break
+		}
+
+		// Find the closing quote, skipping escaped characters.
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			break
+		}
+		value, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			value = s[1:end]
+		}
+		labels[name] = value
+		s = s[end+1:]
+	}
+
+	return labels
+}
+
 func parseCountMetric(res string) map[string][]string {
 	metricMap := make(map[string][]string)
+	if !strings.Contains(res, "=>") {
+		// Empty vector: nothing matched the metric
+		return metricMap
+	}
 	res = strings.Split(res, "=>")[1]
 	repTimestamp := strings.NewReplacer(
 		"[", "",
@@ -102,6 +190,10 @@ func parseTopkMetric(res string) map[string][]string {
 
 	for _, line := range testTmp1 {
 		metricStr := strings.Split(line, "=>")
+		if len(metricStr) < 2 {
+			// Empty vector or non-vector result
+			continue
+		}
 
 		keyStr = repTimestamp.Replace(metricStr[0])
 		valStr := repTimestamp.Replace(metricStr[1])
@@ -110,204 +202,278 @@ func parseTopkMetric(res string) map[string][]string {
 		metricMap[keyStr] = append(metricMap[keyStr], valStr)
 	}
 
-	for key, val := range metricMap {
-		fmt.Printf("key: %s, val: %s\n", key, val)
-	}
-
 	return metricMap
 }
 
-func parseGroupByMetric(res string) map[string][]string {
-	var keyStr string
-
-	metricMap := make(map[string][]string)
-	repTimestamp := strings.NewReplacer(
-		"[", "",
-		"]", "",
-		"@", "")
-
-	res = strings.Replace(res, "=>", "", -1)
-	testTmp1 := strings.Split(res, "\n")
-
-	for _, line := range testTmp1 {
-		if strings.Contains(line, "{") {
-			keyStr = line
-			metricMap[keyStr] = []string{""}
-		} else {
-			line = repTimestamp.Replace(line)
-			metricMap[keyStr] = append(metricMap[keyStr], line)
-		}
-	}
-
-	for key, val := range metricMap {
-		fmt.Printf("key: %s, val: %s\n", key, val)
-	}
-
-	return metricMap
-}
-
-func countAPIQuery(host string, port string, query string) ([]TSMetricObj, error) {
+func countAPIQuery(ctx context.Context, host string, port string, timeout time.Duration, query string) ([]TSMetricObj, v1.Warnings, error) {
 	var queryResult []TSMetricObj
 
-	client, err := api.NewClient(api.Config{
-		Address: fmt.Sprintf("http://%s:%s", host, port),
-	})
+	client, err := newAPIClient(host, port)
 	if err != nil {
-		fmt.Printf("Error creating client: %v\n", err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	v1api := v1.NewAPI(client)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	fmt.Printf("Debug: querying %v\n", query)
-
-	result, warnings, err := v1api.Query(ctx, query, time.Now())
+	spanCtx, span := startSpan(ctx, "prometheus.query", host, port, query)
+	result, warnings, err := v1api.Query(spanCtx, query, time.Now())
+	endSpan(span, err)
 	if err != nil {
-		fmt.Printf("Error querying Prometheus: %v\n", err)
-		return nil, err
+		return nil, warnings, err
 	}
-	if len(warnings) > 0 {
-		fmt.Printf("Warnings: %v\n", warnings)
-	}
-
-	fmt.Printf("Result Strnings: %v\n", result)
 
+	span = startDecodeSpan(ctx)
 	resMetric := parseCountMetric(result.String())
 
 	for key, val := range resMetric {
 		var metricObj TSMetricObj
 		metricObj.Label = key
+		metricObj.Labels = parseLabels(key)
 		for i, v := range val {
 			if i > 0 {
 				metricList := strings.Fields(v)
 				metricObj.Vals = append(metricObj.Vals, metricList[0])
 				metricObj.TimeSeries = append(metricObj.TimeSeries, metricList[1])
-				fmt.Printf("Val: %s, Time : %s \n", metricList[0], metricList[1])
 			}
 		}
 		queryResult = append(queryResult, metricObj)
 	}
-	return queryResult, err
+	span.SetAttributes(AttrSeries.Int(len(queryResult)))
+	span.End()
+	return queryResult, warnings, err
 }
 
-func topkAPIQuery(host string, port string, query string) ([]TSMetricObj, error) {
+func topkAPIQuery(ctx context.Context, host string, port string, timeout time.Duration, query string) ([]TSMetricObj, v1.Warnings, error) {
 	var queryResult []TSMetricObj
 
-	client, err := api.NewClient(api.Config{
-		Address: fmt.Sprintf("http://%s:%s", host, port),
-	})
+	client, err := newAPIClient(host, port)
 	if err != nil {
-		fmt.Printf("Error creating client: %v\n", err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	v1api := v1.NewAPI(client)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	result, warnings, err := v1api.Query(ctx, query, time.Now())
+	spanCtx, span := startSpan(ctx, "prometheus.query", host, port, query)
+	result, warnings, err := v1api.Query(spanCtx, query, time.Now())
+	endSpan(span, err)
 	if err != nil {
-		fmt.Printf("Error querying Prometheus: %v\n", err)
-		return nil, err
+		return nil, warnings, err
 	}
-	if len(warnings) > 0 {
-		fmt.Printf("Warnings: %v\n", warnings)
-	}
-
-	fmt.Printf("Result Strnings: %v\n", result)
 
+	span = startDecodeSpan(ctx)
 	resMetric := parseTopkMetric(result.String())
 
 	for key, val := range resMetric {
 		var metricObj TSMetricObj
 		metricObj.Label = key
+		metricObj.Labels = parseLabels(key)
 		for i, v := range val {
 			if i > 0 {
 				metricList := strings.Fields(v)
 				metricObj.Vals = append(metricObj.Vals, metricList[0])
 				metricObj.TimeSeries = append(metricObj.TimeSeries, metricList[1])
-				fmt.Printf("Val: %s, Time : %s \n", metricList[0], metricList[1])
 			}
 		}
 		queryResult = append(queryResult, metricObj)
 	}
+	span.SetAttributes(AttrSeries.Int(len(queryResult)))
+	span.End()
 
-	return queryResult, nil
+	return queryResult, warnings, nil
 }
 
-func groupbyAPIQueryRange(host string, port string, query string) ([]TSMetricObj, error) {
+// groupbyAPIQueryRange collects the series of groupbyAPIQueryRangeStream, so
+// that buffered and streamed range queries return the same series.
+func groupbyAPIQueryRange(ctx context.Context, host string, port string, timeout time.Duration, query string) ([]TSMetricObj, v1.Warnings, error) {
 	var queryResult []TSMetricObj
 
-	client, err := api.NewClient(api.Config{
-		Address: fmt.Sprintf("http://%s:%s", host, port),
+	warnings, err := groupbyAPIQueryRangeStream(ctx, host, port, timeout, query, func(metric TSMetricObj) error {
+		queryResult = append(queryResult, metric)
+		return nil
 	})
+	if err != nil {
+		return nil, warnings, err
+	}
+	return queryResult, warnings, nil
+}
 
+// groupbyAPIQueryRangeStream runs the range query of the last hour and passes
+// every series to fn as it is decoded. The request is that of the Prometheus
+// API client, sent through its configuration, as api.Client.Do reads the
+// whole body before returning.
+func groupbyAPIQueryRangeStream(ctx context.Context, host string, port string, timeout time.Duration, query string, fn SeriesFunc) (v1.Warnings, error) {
+	conf := apiConfig(host, port)
+	client, err := api.NewClient(conf)
 	if err != nil {
-		fmt.Printf("Error creating client: %v\n", err)
 		return nil, err
 	}
 
-	v1api := v1.NewAPI(client)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	r := v1.Range{
-		Start: time.Now().Add(-time.Hour),
-		End:   time.Now(),
-		Step:  time.Minute,
+	end := time.Now()
+	args := url.Values{
+		"query": {query},
+		"start": {formatTime(end.Add(-time.Hour))},
+		"end":   {formatTime(end)},
+		"step":  {strconv.FormatFloat(time.Minute.Seconds(), 'f', -1, 64)},
 	}
 
-	result, warnings, err := v1api.QueryRange(ctx, query, r)
+	spanCtx, span := startSpan(ctx, "prometheus.query_range", host, port, query)
+	req, err := http.NewRequestWithContext(spanCtx, http.MethodPost, client.URL("/api/v1/query_range", nil).String(), strings.NewReader(args.Encode()))
 	if err != nil {
-		fmt.Printf("Error querying Prometheus: %v\n", err)
+		endSpan(span, err)
 		return nil, err
 	}
-	if len(warnings) > 0 {
-		fmt.Printf("Warnings: %v\n", warnings)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := (&http.Client{Transport: conf.RoundTripper}).Do(req)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	resMetric := parseGroupByMetric(result.String())
+	series := 0
+	warnings, err := decodeRangeResponse(resp, func(metric TSMetricObj) error {
+		series++
+		return fn(metric)
+	})
+	span.SetAttributes(AttrSeries.Int(series))
+	endSpan(span, err)
+	return warnings, err
+}
 
-	for key, val := range resMetric {
-		var metricObj TSMetricObj
-		metricObj.Label = key
-		for i, v := range val {
-			if i > 0 {
-				metricList := strings.Fields(v)
-				metricObj.Vals = append(metricObj.Vals, metricList[0])
-				metricObj.TimeSeries = append(metricObj.TimeSeries, metricList[1])
-				fmt.Printf("Val: %s, Time : %s \n", metricList[0], metricList[1])
-			}
-		}
-		queryResult = append(queryResult, metricObj)
-	}
-	return queryResult, nil
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
 }
 
 // NtopQueryWithRate is qeury for tonN method
 func (c *OVSClient) NtopQueryWithRate(rankSize int, metric string, duration string) ([]TSMetricObj, error) {
+	queryResult, _, err := c.NtopQueryWithRateContext(context.Background(), rankSize, metric, duration)
+	return queryResult, err
+}
+
+// NtopQueryWithRateContext is NtopQueryWithRate with a context and the
+// warnings of the upstreams. The top rankSize is recomputed across upstreams.
+func (c *OVSClient) NtopQueryWithRateContext(ctx context.Context, rankSize int, metric string, duration string) ([]TSMetricObj, v1.Warnings, error) {
+	if err := ValidateRank(rankSize, 0); err != nil {
+		return nil, nil, err
+	}
+	duration, err := rateParams(metric, duration)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Make Query String
-	query := fmt.Sprintf(ntopQueryWithRate, rankSize, metric, duration)
+	query := fmt.Sprintf(ntopQueryWithRate, rankSize, c.selector(metric), duration)
+	if record, ok := c.recorded(ctx, RateRule(metric, duration)); ok {
+		query = fmt.Sprintf(recordedTopkQuery, rankSize, record)
+	}
 
-	// Call ovsAPIQueryRange() & return result
-	return topkAPIQuery(c.Host, c.Port, query)
+	// Call topkAPIQuery() on every upstream & merge the rankings
+	queryResult, warnings, err := c.fanOut(ctx, QueryTypeTopK, topkAPIQuery, query)
+	if err != nil {
+		return nil, warnings, err
+	}
+	return topK(queryResult, rankSize), warnings, nil
+}
+
+// RateQuery is query for the current rate of every bridge and port
+func (c *OVSClient) RateQuery(metric string, duration string) ([]TSMetricObj, error) {
+	queryResult, _, err := c.RateQueryContext(context.Background(), metric, duration)
+	return queryResult, err
+}
+
+// RateQueryContext is RateQuery with a context and the warnings of the
+// upstreams.
+func (c *OVSClient) RateQueryContext(ctx context.Context, metric string, duration string) ([]TSMetricObj, v1.Warnings, error) {
+	duration, err := rateParams(metric, duration)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Make Query String
+	query := fmt.Sprintf(avgbyQueryWithRate, c.selector(metric), duration)
+	if record, ok := c.recorded(ctx, RateRule(metric, duration)); ok {
+		query = record
+	}
+
+	return c.fanOut(ctx, QueryTypeRate, topkAPIQuery, query)
 }
 
 // CountQuery is qeury for count method
 func (c *OVSClient) CountQuery(metric string) ([]TSMetricObj, error) {
+	queryResult, _, err := c.CountQueryContext(context.Background(), metric)
+	return queryResult, err
+}
+
+// CountQueryContext is CountQuery with a context and the warnings of the
+// upstreams. Every upstream reports its own count.
+func (c *OVSClient) CountQueryContext(ctx context.Context, metric string) ([]TSMetricObj, v1.Warnings, error) {
+	if err := ValidateMetric(metric); err != nil {
+		return nil, nil, err
+	}
+
 	// Make Query String
-	query := fmt.Sprintf(countQuery, metric)
+	query := fmt.Sprintf(countQuery, c.selector(metric))
 
-	// Call ovsAPIQueryRange() & return result
-	return countAPIQuery(c.Host, c.Port, query)
+	// Call countAPIQuery() on every upstream & return result
+	return c.fanOut(ctx, QueryTypeCount, countAPIQuery, query)
 }
 
 // AvgbyQueryWithRate is qeury for range method
 func (c *OVSClient) AvgbyQueryWithRate(metric string, duration string) ([]TSMetricObj, error) {
+	queryResult, _, err := c.AvgbyQueryWithRateContext(context.Background(), metric, duration)
+	return queryResult, err
+}
+
+// AvgbyQueryWithRateContext is AvgbyQueryWithRate with a context and the
+// warnings of the upstreams.
+func (c *OVSClient) AvgbyQueryWithRateContext(ctx context.Context, metric string, duration string) ([]TSMetricObj, v1.Warnings, error) {
+	duration, err := rateParams(metric, duration)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Make Query String
-	query := fmt.Sprintf(avgbyQueryWithRate, metric, duration)
+	query := fmt.Sprintf(avgbyQueryWithRate, c.selector(metric), duration)
+	if record, ok := c.recorded(ctx, RateRule(metric, duration)); ok {
+		query = record
+	}
+
+	// Call groupbyAPIQueryRange() on every upstream & return result
+	return c.fanOut(ctx, QueryTypeAvgBy, groupbyAPIQueryRange, query)
+}
+
+// Query is query for an arbitrary instant vector expression
+func (c *OVSClient) Query(query string) ([]TSMetricObj, error) {
+	queryResult, _, err := c.QueryContext(context.Background(), query)
+	return queryResult, err
+}
 
-	// Call ovsAPIQueryRange() & return result
-	return groupbyAPIQueryRange(c.Host, c.Port, query)
+// QueryContext is Query with a context and the warnings of the upstreams.
+func (c *OVSClient) QueryContext(ctx context.Context, query string) ([]TSMetricObj, v1.Warnings, error) {
+	if len(c.Matchers) > 0 {
+		return nil, nil, ErrRestricted
+	}
+	return c.fanOut(ctx, QueryTypeInstant, topkAPIQuery, c.recordedQuery(ctx, query))
+}
+
+// QueryRange is query for an arbitrary range expression over the last hour
+func (c *OVSClient) QueryRange(query string) ([]TSMetricObj, error) {
+	queryResult, _, err := c.QueryRangeContext(context.Background(), query)
+	return queryResult, err
+}
+
+// QueryRangeContext is QueryRange with a context and the warnings of the
+// upstreams.
+func (c *OVSClient) QueryRangeContext(ctx context.Context, query string) ([]TSMetricObj, v1.Warnings, error) {
+	if len(c.Matchers) > 0 {
+		return nil, nil, ErrRestricted
+	}
+	return c.fanOut(ctx, QueryTypeQueryRange, groupbyAPIQueryRange, c.recordedQuery(ctx, query))
 }
@@ -11,272 +11,161 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package v1_test provides examples making requests to Prometheus using the
-// Golang client.
 package ovs_prom_client
 
 import (
-	"context"
-	"fmt"
-	"os"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
-	"time"
-
-	"github.com/prometheus/client_golang/api"
-	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"sync"
+	"testing"
 )
 
-type TSMetricObj struct {
-	Label      string
-	Vals       []string
-	TimeSeries []string
-}
-
-func parseCountMetric(res string) map[string][]string {
-	metricMap := make(map[string][]string)
-	res = strings.Split(res, "=>")[1]
-	repTimestamp := strings.NewReplacer(
-		"[", "",
-		"]", "",
-		"@", "")
-
-	res = repTimestamp.Replace(res)
+// answerFunc returns the status and body of a Prometheus API request
+type answerFunc func(path string, query string) (int, string)
 
-	metricMap["count"] = []string{""}
-	metricMap["count"] = append(metricMap["count"], res)
+// fakePrometheus answers the Prometheus API with answer and records the
+// queries it gets.
+type fakePrometheus struct {
+	*httptest.Server
+	host, port string
 
-	return metricMap
+	mu      sync.Mutex
+	queries []string
 }
 
-func parseTopkMetric(res string) map[string][]string {
-	var keyStr string
-
-	metricMap := make(map[string][]string)
-	repTimestamp := strings.NewReplacer(
-		"[", "",
-		"]", "",
-		"@", "",
-		"{", "",
-		"}", "",
-	)
-
-	testTmp1 := strings.Split(res, "\n")
-
-	for _, line := range testTmp1 {
-		metricStr := strings.Split(line, "=>")
-
-		keyStr = repTimestamp.Replace(metricStr[0])
-		valStr := repTimestamp.Replace(metricStr[1])
-
-		metricMap[keyStr] = []string{""}
-		metricMap[keyStr] = append(metricMap[keyStr], valStr)
-	}
+func newFakePrometheus(t *testing.T, answer answerFunc) *fakePrometheus {
+	p := &fakePrometheus{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		query := r.Form.Get("query")
+		p.mu.Lock()
+		p.queries = append(p.queries, query)
+		p.mu.Unlock()
+
+		status, body := answer(r.URL.Path, query)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(p.Close)
+
+	u, _ := url.Parse(p.URL)
+	p.host, p.port, _ = net.SplitHostPort(u.Host)
+	return p
+}
 
-	for key, val := range metricMap {
-		fmt.Printf("key: %s, val: %s\n", key, val)
+// client returns a client of p alone.
+func (p *fakePrometheus) client(t *testing.T) *OVSClient {
+	c, err := NewOVSPClilent(p.host, p.port, "v1")
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	return metricMap
+	return c
 }
 
-func parseGroupByMetric(res string) map[string][]string {
-	var keyStr string
-
-	metricMap := make(map[string][]string)
-	repTimestamp := strings.NewReplacer(
-		"[", "",
-		"]", "",
-		"@", "")
-
-	res = strings.Replace(res, "=>", "", -1)
-	testTmp1 := strings.Split(res, "\n")
-
-	for _, line := range testTmp1 {
-		if strings.Contains(line, "{") {
-			keyStr = line
-			metricMap[keyStr] = []string{""}
-		} else {
-			line = repTimestamp.Replace(line)
-			metricMap[keyStr] = append(metricMap[keyStr], line)
-		}
+// lastQuery returns the query of the latest request.
+func (p *fakePrometheus) lastQuery() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.queries) == 0 {
+		return ""
 	}
+	return p.queries[len(p.queries)-1]
+}
 
-	for key, val := range metricMap {
-		fmt.Printf("key: %s, val: %s\n", key, val)
-	}
+// sample is a series of a vector with labels like `"bridge":"br0"`.
+func sample(labels string, value string) string {
+	return `{"metric":{` + labels + `},"value":[1600000000,"` + value + `"]}`
+}
 
-	return metricMap
+// vector is a successful instant query of samples.
+func vector(samples ...string) (int, string) {
+	return http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[` + strings.Join(samples, ",") + `]}}`
 }
 
-func countAPIQuery(query string) {
-	var queryResult []TSMetricObj
+// apiError is a failed query, retryable with a 5xx status.
+func apiError(status int, msg string) (int, string) {
+	return status, `{"status":"error","errorType":"execution","error":"` + msg + `"}`
+}
 
-	client, err := api.NewClient(api.Config{
-		Address: "http://15.165.203.82:9090",
+func TestCountQuery(t *testing.T) {
+	p := newFakePrometheus(t, func(path string, query string) (int, string) {
+		return vector(sample("", "42"))
 	})
-	if err != nil {
-		fmt.Printf("Error creating client: %v\n", err)
-		os.Exit(1)
-	}
-
-	v1api := v1.NewAPI(client)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 
-	result, warnings, err := v1api.Query(ctx, query, time.Now())
+	metrics, err := p.client(t).CountQuery("ovs_interface_receive_bytes_total")
 	if err != nil {
-		fmt.Printf("Error querying Prometheus: %v\n", err)
-		os.Exit(1)
+		t.Fatal(err)
 	}
-	if len(warnings) > 0 {
-		fmt.Printf("Warnings: %v\n", warnings)
+	if q := p.lastQuery(); q != "count(count by (bridge, port)(ovs_interface_receive_bytes_total))" {
+		t.Errorf("unexpected query %s", q)
 	}
-
-	fmt.Printf("Result Strnings: %v\n", result)
-
-	resMetric := parseCountMetric(result.String())
-
-	for key, val := range resMetric {
-		var metricObj TSMetricObj
-		metricObj.Label = key
-		for i, v := range val {
-			if i > 0 {
-				metricList := strings.Fields(v)
-				metricObj.Vals = append(metricObj.Vals, metricList[0])
-				metricObj.TimeSeries = append(metricObj.TimeSeries, metricList[1])
-				fmt.Printf("Val: %s, Time : %s \n", metricList[0], metricList[1])
-			}
-		}
-		queryResult = append(queryResult, metricObj)
+	if len(metrics) != 1 || metrics[0].Label != "count" || metrics[0].Vals[0] != "42" || metrics[0].TimeSeries[0] != "1600000000" {
+		t.Errorf("unexpected count %+v", metrics)
 	}
 }
 
-func topkAPIQuery(query string) {
-	var queryResult []TSMetricObj
-
-	client, err := api.NewClient(api.Config{
-		Address: "http://15.165.203.82:9090",
+func TestNtopQueryWithRate(t *testing.T) {
+	p := newFakePrometheus(t, func(path string, query string) (int, string) {
+		return vector(
+			sample(`"bridge":"br-int","port":"vm1"`, "10"),
+			sample(`"bridge":"br-int","port":"vm \"2\""`, "30"),
+			sample(`"bridge":"br-ex","port":"eth0"`, "20"))
 	})
-	if err != nil {
-		fmt.Printf("Error creating client: %v\n", err)
-		os.Exit(1)
-	}
-
-	v1api := v1.NewAPI(client)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 
-	result, warnings, err := v1api.Query(ctx, query, time.Now())
+	metrics, err := p.client(t).NtopQueryWithRate(2, "ovs_interface_receive_bytes_total", "5m")
 	if err != nil {
-		fmt.Printf("Error querying Prometheus: %v\n", err)
-		os.Exit(1)
+		t.Fatal(err)
 	}
-	if len(warnings) > 0 {
-		fmt.Printf("Warnings: %v\n", warnings)
+	if q := p.lastQuery(); q != "topk(2, avg by (bridge, port)(rate(ovs_interface_receive_bytes_total[5m])*8))" {
+		t.Errorf("unexpected query %s", q)
 	}
-
-	fmt.Printf("Result Strnings: %v\n", result)
-
-	resMetric := parseTopkMetric(result.String())
-
-	for key, val := range resMetric {
-		var metricObj TSMetricObj
-		metricObj.Label = key
-		for i, v := range val {
-			if i > 0 {
-				metricList := strings.Fields(v)
-				metricObj.Vals = append(metricObj.Vals, metricList[0])
-				metricObj.TimeSeries = append(metricObj.TimeSeries, metricList[1])
-				fmt.Printf("Val: %s, Time : %s \n", metricList[0], metricList[1])
-			}
-		}
-		queryResult = append(queryResult, metricObj)
+	if len(metrics) != 2 || metrics[0].Labels["port"] != `vm "2"` || metrics[1].Labels["port"] != "eth0" {
+		t.Errorf("unexpected ranking %+v", metrics)
 	}
 }
 
-func groupbyAPIQueryRange(query string) {
-	var queryResult []TSMetricObj
-
-	client, err := api.NewClient(api.Config{
-		Address: "http://15.165.203.82:9090",
+func TestAvgbyQueryWithRate(t *testing.T) {
+	p := newFakePrometheus(t, func(path string, query string) (int, string) {
+		if path != "/api/v1/query_range" {
+			return apiError(http.StatusNotFound, "not a range query")
+		}
+		return http.StatusOK, `{"status":"success","data":{"resultType":"matrix","result":[` +
+			`{"metric":{"bridge":"br-int","port":"vm1"},"values":[[1600000000,"8"],[1600000060,"16"]]}]}}`
 	})
 
+	metrics, err := p.client(t).AvgbyQueryWithRate("ovs_interface_receive_bytes_total", "5m")
 	if err != nil {
-		fmt.Printf("Error creating client: %v\n", err)
-		os.Exit(1)
+		t.Fatal(err)
 	}
-
-	v1api := v1.NewAPI(client)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	r := v1.Range{
-		Start: time.Now().Add(-time.Hour),
-		End:   time.Now(),
-		Step:  time.Minute,
+	if q := p.lastQuery(); q != "avg by(bridge, port) (rate(ovs_interface_receive_bytes_total[5m])*8)" {
+		t.Errorf("unexpected query %s", q)
 	}
-
-	result, warnings, err := v1api.QueryRange(ctx, query, r)
-	if err != nil {
-		fmt.Printf("Error querying Prometheus: %v\n", err)
-		os.Exit(1)
+	if len(metrics) != 1 || metrics[0].Labels["bridge"] != "br-int" ||
+		strings.Join(metrics[0].Vals, ",") != "8,16" || strings.Join(metrics[0].TimeSeries, ",") != "1600000000,1600000060" {
+		t.Errorf("unexpected series %+v", metrics)
 	}
-	if len(warnings) > 0 {
-		fmt.Printf("Warnings: %v\n", warnings)
-	}
-
-	resMetric := parseGroupByMetric(result.String())
+}
 
-	for key, val := range resMetric {
-		var metricObj TSMetricObj
-		metricObj.Label = key
-		for i, v := range val {
-			if i > 0 {
-				metricList := strings.Fields(v)
-				metricObj.Vals = append(metricObj.Vals, metricList[0])
-				metricObj.TimeSeries = append(metricObj.TimeSeries, metricList[1])
-				fmt.Printf("Val: %s, Time : %s \n", metricList[0], metricList[1])
+func TestParseLabels(t *testing.T) {
+	for key, want := range map[string]string{
+		`{bridge="br0", port="eth0"}`: "bridge=br0 port=eth0",
+		`{port="a\"b", bridge="x,y"}`: `bridge=x,y port=a"b`,
+		"count":                       "",
+		`{}`:                          "",
+	} {
+		labels := parseLabels(key)
+		var pairs []string
+		for _, name := range []string{"bridge", "port"} {
+			if v, ok := labels[name]; ok {
+				pairs = append(pairs, name+"="+v)
 			}
 		}
-		queryResult = append(queryResult, metricObj)
-	}
-}
-
-func exampleAPISeries() {
-	client, err := api.NewClient(api.Config{
-		Address: "http://15.165.203.82:9090",
-	})
-	if err != nil {
-		fmt.Printf("Error creating client: %v\n", err)
-		os.Exit(1)
-	}
-
-	v1api := v1.NewAPI(client)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	lbls, warnings, err := v1api.Series(ctx, []string{
-		"{__name__=\"ovs_flow_flow_bytes_total\", job=\"prometheus\"}",
-	}, time.Now().Add(-time.Hour), time.Now())
-	if err != nil {
-		fmt.Printf("Error querying Prometheus: %v\n", err)
-		os.Exit(1)
-	}
-	if len(warnings) > 0 {
-		fmt.Printf("Warnings: %v\n", warnings)
-	}
-	fmt.Println("Result:")
-	for _, lbl := range lbls {
-		fmt.Println(lbl)
+		if got := strings.Join(pairs, " "); got != want {
+			t.Errorf("parseLabels(%s) = %q, want %q", key, got, want)
+		}
 	}
 }
-
-func main() {
-	//exampleAPIQueryRange("rate(ovs_interface_receive_bytes_total[5m])")
-	fmt.Println("Count Function ======================================")
-	countAPIQuery("count (count by (bridge, port) (ovs_interface_receive_bytes_total))")
-
-	fmt.Println("nTop Function ======================================")
-	topkAPIQuery("topk(5, avg by (bridge, port)(rate(ovs_interface_receive_bytes_total[5m])*8))")
-
-	fmt.Println("avg Function ======================================")
-	groupbyAPIQueryRange("avg by(bridge, port) (rate(ovs_interface_receive_bytes_total[5m])*8)")
-}
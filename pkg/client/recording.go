@@ -0,0 +1,206 @@
+package ovs_prom_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// DefaultRecordedRefresh is how often RecordedRules reloads the rules of the
+// upstreams
+const DefaultRecordedRefresh time.Duration = time.Minute
+
+// recordedSeries reads a recorded series like the expression it records:
+// one series per port, without the record name
+const recordedSeries string = "avg by (bridge, port) (%s)" // record
+const recordedTopkQuery string = "topk(%d, %s)"            // rankSize(int), recordedSeries
+
+// RecordedRules tells the clients sharing it which of the RecordingRules
+// their upstreams evaluate, so that the built-in queries and the signal
+// expressions read the recorded series instead of recomputing them. Only
+// healthy rules loaded on every upstream are used. The rules are reloaded
+// every refresh, none are used when refresh is not positive.
+type RecordedRules struct {
+	mu      sync.Mutex
+	refresh time.Duration
+	key     string // upstreams the rules were loaded from
+	loaded  time.Time
+	loading bool
+	byExpr  map[string]string // record by expression
+	now     func() time.Time
+}
+
+// NewRecordedRules returns recorded rules reloaded every refresh.
+func NewRecordedRules(refresh time.Duration) *RecordedRules {
+	return &RecordedRules{refresh: refresh, now: time.Now}
+}
+
+// SetRefresh changes the refresh interval and reloads the rules on their
+// next use.
+func (r *RecordedRules) SetRefresh(refresh time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refresh = refresh
+	r.loaded = time.Time{}
+}
+
+// Records returns the names of the recorded series in use, sorted.
+func (r *RecordedRules) Records() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records := make([]string, 0, len(r.byExpr))
+	for _, record := range r.byExpr {
+		records = append(records, record)
+	}
+	sort.Strings(records)
+	return records
+}
+
+// lookup returns the series recording expr on the upstreams of c. Stale
+// rules are reloaded by one caller while the others keep using them.
+func (r *RecordedRules) lookup(ctx context.Context, c *OVSClient, expr string) (string, bool) {
+	key := upstreamsKey(c.upstreams())
+
+	r.mu.Lock()
+	if r.refresh <= 0 {
+		r.mu.Unlock()
+		return "", false
+	}
+	if r.loading || (key == r.key && r.now().Sub(r.loaded) < r.refresh) {
+		var record string
+		if key == r.key {
+			record = r.byExpr[expr]
+		}
+		r.mu.Unlock()
+		return record, record != ""
+	}
+	r.loading = true
+	r.mu.Unlock()
+
+	// A canceled request must not leave the clients without rules
+	byExpr, err := c.loadRecordedRules(context.WithoutCancel(ctx))
+	if err != nil {
+		c.logger().WarnContext(ctx, "error to load the recording rules, querying without them", "err", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loading = false
+	r.key, r.loaded, r.byExpr = key, r.now(), byExpr
+	record := byExpr[expr]
+	return record, record != ""
+}
+
+func upstreamsKey(ups []Upstream) string {
+	keys := make([]string, len(ups))
+	for i, u := range ups {
+		keys[i] = u.Name + "=" + u.Host + ":" + u.Port
+	}
+	return strings.Join(keys, ",")
+}
+
+// recorded returns the query of the series recording rule, restricted by
+// the matchers of c, when the upstreams evaluate it. Recorded series only
+// keep the bridge and port labels, so a client matching others computes
+// the rule itself.
+func (c *OVSClient) recorded(ctx context.Context, rule RecordingRule) (string, bool) {
+	if c.Recorded == nil {
+		return "", false
+	}
+	for _, m := range c.Matchers {
+		if m.Name != "bridge" && m.Name != "port" {
+			return "", false
+		}
+	}
+	record, ok := c.Recorded.lookup(ctx, c, rule.Expr)
+	if !ok || record != rule.Record {
+		return "", false
+	}
+	c.logger().DebugContext(ctx, "querying recorded series", "record", record)
+	return fmt.Sprintf(recordedSeries, c.selector(record)), true
+}
+
+// recordedQuery returns the recorded series of an arbitrary query that is
+// exactly the expression of a recording rule, otherwise query itself.
+func (c *OVSClient) recordedQuery(ctx context.Context, query string) string {
+	if c.Recorded == nil {
+		return query
+	}
+	if record, ok := c.Recorded.lookup(ctx, c, query); ok {
+		c.logger().DebugContext(ctx, "querying recorded series", "record", record)
+		return fmt.Sprintf(recordedSeries, record)
+	}
+	return query
+}
+
+// loadRecordedRules returns the records of the RecordingRules evaluated by
+// every upstream, by their expression.
+func (c *OVSClient) loadRecordedRules(ctx context.Context) (map[string]string, error) {
+	ups := c.upstreams()
+	names := make([]map[string]bool, len(ups))
+	errs := make([]error, len(ups))
+
+	var wg sync.WaitGroup
+	for i, u := range ups {
+		wg.Add(1)
+		go func(i int, u Upstream) {
+			defer wg.Done()
+			names[i], errs[i] = rulesAPIQuery(ctx, u.Host, u.Port, c.timeout())
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", upstreamMessage(u, "rules"), errs[i])
+			}
+		}(i, u)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	byExpr := make(map[string]string)
+	for name := range names[0] {
+		everywhere := true
+		for _, n := range names[1:] {
+			everywhere = everywhere && n[name]
+		}
+		if rule, ok := parseRecord(name); ok && everywhere {
+			byExpr[rule.Expr] = rule.Record
+		}
+	}
+	return byExpr, nil
+}
+
+// rulesAPIQuery returns the names of the healthy recording rules of a
+// Prometheus server.
+func rulesAPIQuery(ctx context.Context, host string, port string, timeout time.Duration) (map[string]bool, error) {
+	client, err := newAPIClient(host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	v1api := v1.NewAPI(client)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	spanCtx, span := startSpan(ctx, "prometheus.rules", host, port, "")
+	result, err := v1api.Rules(spanCtx)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, group := range result.Groups {
+		for _, rule := range group.Rules {
+			if r, ok := rule.(v1.RecordingRule); ok && r.Health == v1.RuleHealthGood {
+				names[r.Name] = true
+			}
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,219 @@
+package ovs_prom_client
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const recordedRate5m string = "bridge_port:ovs_interface_receive_bytes_total:bits_rate5m"
+
+// rulesAnswer lists recording rules of the given health by name. Other
+// requests get an empty vector.
+func rulesAnswer(health map[string]string) answerFunc {
+	var rules []string
+	for name, h := range health {
+		rules = append(rules, `{"type":"recording","name":"`+name+`","query":"x","health":"`+h+`","labels":{}}`)
+	}
+	return func(path string, query string) (int, string) {
+		if path == "/api/v1/rules" {
+			return http.StatusOK, `{"status":"success","data":{"groups":[{"name":"ovs","file":"ovs.yml","interval":60,"rules":[` +
+				strings.Join(rules, ",") + `]}]}}`
+		}
+		return vector()
+	}
+}
+
+func TestRecordedQueries(t *testing.T) {
+	computed := "avg by(bridge, port) (rate(ovs_interface_receive_bytes_total[5m])*8)"
+	errorRatio, _ := SignalRule(SignalErrorRatio, "5m")
+
+	for _, tc := range []struct {
+		name     string
+		health   map[string]string
+		matchers []Matcher
+		window   string
+		want     string
+	}{
+		{
+			name:   "recorded",
+			health: map[string]string{recordedRate5m: "ok"},
+			window: "5m",
+			want:   "avg by (bridge, port) (" + recordedRate5m + ")",
+		},
+		{
+			name:     "recorded with bridge and port matchers",
+			health:   map[string]string{recordedRate5m: "ok"},
+			matchers: []Matcher{{Name: "port", Values: []string{"vm1"}}, {Name: "bridge", Values: []string{"br-int"}}},
+			window:   "5m",
+			want:     `avg by (bridge, port) (` + recordedRate5m + `{bridge=~"br-int",port=~"vm1"})`,
+		},
+		{
+			name:     "other labels are not recorded",
+			health:   map[string]string{recordedRate5m: "ok"},
+			matchers: []Matcher{{Name: "tenant", Values: []string{"acme"}}},
+			window:   "5m",
+			want:     `avg by(bridge, port) (rate(ovs_interface_receive_bytes_total{tenant=~"acme"}[5m])*8)`,
+		},
+		{
+			name:   "window not recorded",
+			health: map[string]string{recordedRate5m: "ok", errorRatio.Record: "ok"},
+			window: "1m",
+			want:   "avg by(bridge, port) (rate(ovs_interface_receive_bytes_total[1m])*8)",
+		},
+		{
+			name:   "unhealthy rule",
+			health: map[string]string{recordedRate5m: "err"},
+			window: "5m",
+			want:   computed,
+		},
+		{
+			name:   "unknown record",
+			health: map[string]string{"bridge_port:ovs_interface_receive_bytes_total:bits_rate": "ok", "job:up:sum": "ok"},
+			window: "5m",
+			want:   computed,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newFakePrometheus(t, rulesAnswer(tc.health))
+			c := p.client(t)
+			c.Recorded = NewRecordedRules(time.Minute)
+			c.Matchers = tc.matchers
+
+			if _, err := c.RateQuery("ovs_interface_receive_bytes_total", tc.window); err != nil {
+				t.Fatal(err)
+			}
+			if q := p.lastQuery(); q != tc.want {
+				t.Errorf("got %s, want %s", q, tc.want)
+			}
+		})
+	}
+}
+
+func TestRecordedTopK(t *testing.T) {
+	p := newFakePrometheus(t, rulesAnswer(map[string]string{recordedRate5m: "ok"}))
+	c := p.client(t)
+	c.Recorded = NewRecordedRules(time.Minute)
+
+	if _, err := c.NtopQueryWithRate(3, "ovs_interface_receive_bytes_total", "5m"); err != nil {
+		t.Fatal(err)
+	}
+	if q := p.lastQuery(); q != "topk(3, avg by (bridge, port) ("+recordedRate5m+"))" {
+		t.Errorf("unexpected query %s", q)
+	}
+	if records := c.Recorded.Records(); len(records) != 1 || records[0] != recordedRate5m {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+func TestRecordedSignals(t *testing.T) {
+	errorRatio, _ := SignalRule(SignalErrorRatio, "5m")
+	p := newFakePrometheus(t, rulesAnswer(map[string]string{errorRatio.Record: "ok"}))
+	c := p.client(t)
+	c.Recorded = NewRecordedRules(time.Minute)
+
+	if _, err := c.Query(errorRatio.Expr); err != nil {
+		t.Fatal(err)
+	}
+	if q := p.lastQuery(); q != "avg by (bridge, port) ("+errorRatio.Record+")" {
+		t.Errorf("expected the recorded signal, got %s", q)
+	}
+
+	other, _ := SignalExpr(SignalErrorRatio, "10m")
+	if _, err := c.Query(other); err != nil {
+		t.Fatal(err)
+	}
+	if q := p.lastQuery(); q != other {
+		t.Errorf("expected an unrecorded signal as it is, got %s", q)
+	}
+}
+
+func TestRecordedOnEveryUpstream(t *testing.T) {
+	p1 := newFakePrometheus(t, rulesAnswer(map[string]string{recordedRate5m: "ok"}))
+	p2 := newFakePrometheus(t, rulesAnswer(nil))
+	c := &OVSClient{Recorded: NewRecordedRules(time.Minute)}
+	c.AddUpstream("dc1", p1.host, p1.port)
+	c.AddUpstream("dc2", p2.host, p2.port)
+
+	if _, err := c.RateQuery("ovs_interface_receive_bytes_total", "5m"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*fakePrometheus{p1, p2} {
+		if q := p.lastQuery(); strings.Contains(q, "bridge_port:") {
+			t.Errorf("expected a computed rate while dc2 does not record it, got %s", q)
+		}
+	}
+}
+
+func TestRecordedRulesFallback(t *testing.T) {
+	var mu sync.Mutex
+	rulesFail := false
+	health := map[string]string{recordedRate5m: "ok"}
+	recorded := rulesAnswer(health)
+	p := newFakePrometheus(t, func(path string, query string) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if path == "/api/v1/rules" && rulesFail {
+			return apiError(http.StatusBadRequest, "rules unavailable")
+		}
+		return recorded(path, query)
+	})
+
+	now := time.Unix(1600000000, 0)
+	c := p.client(t)
+	c.Recorded = NewRecordedRules(time.Minute)
+	c.Recorded.now = func() time.Time { return now }
+	rate := func() string {
+		t.Helper()
+		if _, err := c.RateQuery("ovs_interface_receive_bytes_total", "5m"); err != nil {
+			t.Fatal(err)
+		}
+		return p.lastQuery()
+	}
+	rulesQueries := func() int {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		n := 0
+		for _, q := range p.queries {
+			if q == "" {
+				n++
+			}
+		}
+		return n
+	}
+
+	if q := rate(); !strings.Contains(q, recordedRate5m) {
+		t.Fatalf("expected the recorded rate, got %s", q)
+	}
+	rate()
+	if n := rulesQueries(); n != 1 {
+		t.Errorf("expected the rules to be cached, loaded %d times", n)
+	}
+
+	// Stale rules are reloaded; without them the rate is computed
+	mu.Lock()
+	rulesFail = true
+	mu.Unlock()
+	now = now.Add(time.Minute)
+	if q := rate(); strings.Contains(q, recordedRate5m) {
+		t.Errorf("expected a computed rate without rules, got %s", q)
+	}
+	if n := rulesQueries(); n != 2 {
+		t.Errorf("expected the stale rules to be reloaded, loaded %d times", n)
+	}
+
+	mu.Lock()
+	rulesFail = false
+	mu.Unlock()
+	now = now.Add(time.Minute)
+	if q := rate(); !strings.Contains(q, recordedRate5m) {
+		t.Errorf("expected the recorded rate again, got %s", q)
+	}
+
+	c.Recorded.SetRefresh(0)
+	if q := rate(); strings.Contains(q, recordedRate5m) {
+		t.Errorf("expected no recorded rate when disabled, got %s", q)
+	}
+}
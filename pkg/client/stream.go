@@ -0,0 +1,330 @@
+package ovs_prom_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// SeriesFunc receives the series of a streamed query one at a time
+type SeriesFunc func(metric TSMetricObj) error
+
+// LabelNamer is an Enricher telling the labels it may add, so that they are
+// known before the first series of a stream.
+type LabelNamer interface {
+	LabelNames() []string
+}
+
+// partialError is a failure after series were passed on, which must not be
+// retried
+type partialError struct {
+	err error
+}
+
+func (e *partialError) Error() string {
+	return e.err.Error()
+}
+
+// LabelNames returns the labels of the series of the rate queries of c:
+// bridge and port, SourceLabel with named upstreams and the labels of the
+// enrichers that are LabelNamers, sorted.
+func (c *OVSClient) LabelNames() []string {
+	seen := map[string]bool{"bridge": true, "port": true}
+	for _, u := range c.Upstreams {
+		if u.Name != "" {
+			seen[SourceLabel] = true
+		}
+	}
+	for _, e := range c.Enrichers {
+		if n, ok := e.(LabelNamer); ok {
+			for _, name := range n.LabelNames() {
+				seen[name] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AvgbyQueryWithRateStream is AvgbyQueryWithRateContext passing every series
+// to fn as soon as it is decoded instead of returning the result, so that a
+// large range is never held in memory. The upstreams are read concurrently
+// and their series may interleave, but fn is never called concurrently. A
+// failed upstream is a warning as long as another one answers, or once series
+// were passed to fn; an error of fn ends the query.
+func (c *OVSClient) AvgbyQueryWithRateStream(ctx context.Context, metric string, duration string, fn SeriesFunc) (v1.Warnings, error) {
+	duration, err := rateParams(metric, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make Query String
+	query := fmt.Sprintf(avgbyQueryWithRate, c.selector(metric), duration)
+	if record, ok := c.recorded(ctx, RateRule(metric, duration)); ok {
+		query = record
+	}
+
+	return c.fanOutStream(ctx, QueryTypeAvgBy, query, fn)
+}
+
+// fanOutStream runs the range query on every upstream concurrently and passes
+// their series to fn, labelled like those of fanOut.
+func (c *OVSClient) fanOutStream(ctx context.Context, queryType string, query string, fn SeriesFunc) (v1.Warnings, error) {
+	type upstreamResult struct {
+		warnings v1.Warnings
+		err      error
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ups := c.upstreams()
+	results := make([]upstreamResult, len(ups))
+
+	var mu sync.Mutex
+	var fnErr error
+	streamed := false
+	var wg sync.WaitGroup
+	for i, u := range ups {
+		wg.Add(1)
+		go func(i int, u Upstream) {
+			defer wg.Done()
+			r := &results[i]
+			r.warnings, r.err = c.streamUpstream(ctx, u, queryType, query, func(metric TSMetricObj) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if fnErr != nil {
+					return fnErr
+				}
+				if u.Name != "" {
+					metric.Labels[SourceLabel] = u.Name
+				}
+				one := []TSMetricObj{metric}
+				c.enrich(one)
+				streamed = true
+				if fnErr = fn(one[0]); fnErr != nil {
+					cancel()
+				}
+				return fnErr
+			})
+		}(i, u)
+	}
+	wg.Wait()
+
+	var warnings v1.Warnings
+	var errs []error
+	for i, u := range ups {
+		r := results[i]
+		for _, w := range r.warnings {
+			warnings = append(warnings, upstreamMessage(u, w))
+		}
+		if fnErr != nil || r.err == nil {
+			continue
+		}
+		if len(ups) > 1 || streamed {
+			c.logger().WarnContext(ctx, "upstream query failed", "upstream", u.Name, "type", queryType, "err", r.err)
+		}
+		errs = append(errs, r.err)
+		warnings = append(warnings, upstreamMessage(u, r.err.Error()))
+	}
+	if fnErr != nil {
+		return warnings, fnErr
+	}
+
+	if len(errs) == len(ups) && !streamed {
+		if len(errs) == 1 {
+			return upstreamWarnings(warnings, ups[0], errs[0]), errs[0]
+		}
+		return warnings, fmt.Errorf("all %d upstreams failed, first: %w", len(errs), errs[0])
+	}
+	return warnings, nil
+}
+
+// upstreamWarnings returns the warnings of the only upstream u without the
+// one reporting its error err, like fanOut.
+func upstreamWarnings(warnings v1.Warnings, u Upstream, err error) v1.Warnings {
+	last := upstreamMessage(u, err.Error())
+	if n := len(warnings); n > 0 && warnings[n-1] == last {
+		warnings = warnings[:n-1]
+	}
+	if len(warnings) == 0 {
+		return nil
+	}
+	return warnings
+}
+
+// streamUpstream runs the range query on u through query, so that it is
+// limited, observed and retried like the others until fn was called.
+func (c *OVSClient) streamUpstream(ctx context.Context, u Upstream, queryType string, query string, fn SeriesFunc) (v1.Warnings, error) {
+	sent := false
+	_, warnings, err := c.query(ctx, u, queryType, func(ctx context.Context, host string, port string, timeout time.Duration, query string) ([]TSMetricObj, v1.Warnings, error) {
+		warnings, err := groupbyAPIQueryRangeStream(ctx, host, port, timeout, query, func(metric TSMetricObj) error {
+			sent = true
+			return fn(metric)
+		})
+		if err != nil && sent {
+			err = &partialError{err: err}
+		}
+		return nil, warnings, err
+	}, query)
+	return warnings, err
+}
+
+// decodeRangeResponse decodes the matrix of a query_range response series
+// by series. Its errors are those of the Prometheus API client.
+func decodeRangeResponse(resp *http.Response, fn SeriesFunc) (v1.Warnings, error) {
+	switch code := resp.StatusCode; {
+	case code/100 == 2, code == http.StatusBadRequest, code == http.StatusUnprocessableEntity, code == http.StatusServiceUnavailable:
+	default:
+		return nil, &v1.Error{Type: v1.ErrServer, Msg: resp.Status}
+	}
+
+	var status, errorType, errorMsg string
+	var warnings v1.Warnings
+	dec := json.NewDecoder(resp.Body)
+	err := decodeObject(dec, func(key string) error {
+		switch key {
+		case "status":
+			return decodeError(dec.Decode(&status))
+		case "errorType":
+			return decodeError(dec.Decode(&errorType))
+		case "error":
+			return decodeError(dec.Decode(&errorMsg))
+		case "warnings":
+			return decodeError(dec.Decode(&warnings))
+		case "data":
+			if resp.StatusCode/100 != 2 {
+				return skipValue(dec)
+			}
+			return decodeMatrix(dec, fn)
+		default:
+			return skipValue(dec)
+		}
+	})
+	if err != nil {
+		return warnings, err
+	}
+
+	switch {
+	case status == "error":
+		return warnings, &v1.Error{Type: v1.ErrorType(errorType), Msg: errorMsg}
+	case status != "success" || resp.StatusCode/100 != 2:
+		return warnings, &v1.Error{Type: v1.ErrBadResponse, Msg: fmt.Sprintf("inconsistent body for response code %d", resp.StatusCode)}
+	}
+	return warnings, nil
+}
+
+// decodeMatrix decodes the data of a range query and passes every series to
+// fn.
+func decodeMatrix(dec *json.Decoder, fn SeriesFunc) error {
+	return decodeObject(dec, func(key string) error {
+		switch key {
+		case "resultType":
+			var resultType string
+			if err := dec.Decode(&resultType); err != nil {
+				return decodeError(err)
+			}
+			if resultType != model.ValMatrix.String() {
+				return &v1.Error{Type: v1.ErrBadResponse, Msg: fmt.Sprintf("unexpected result type %q", resultType)}
+			}
+			return nil
+		case "result":
+			if err := expectDelim(dec, '['); err != nil {
+				return err
+			}
+			for dec.More() {
+				var s model.SampleStream
+				if err := dec.Decode(&s); err != nil {
+					return decodeError(err)
+				}
+				if err := fn(sampleStreamMetric(s)); err != nil {
+					return err
+				}
+			}
+			return expectDelim(dec, ']')
+		default:
+			return skipValue(dec)
+		}
+	})
+}
+
+// sampleStreamMetric returns the series s.
+func sampleStreamMetric(s model.SampleStream) TSMetricObj {
+	metric := TSMetricObj{
+		Label:      s.Metric.String(),
+		Labels:     make(map[string]string, len(s.Metric)),
+		Vals:       make([]string, len(s.Values)),
+		TimeSeries: make([]string, len(s.Values)),
+	}
+	for name, value := range s.Metric {
+		metric.Labels[string(name)] = string(value)
+	}
+	for i, pair := range s.Values {
+		metric.Vals[i] = pair.Value.String()
+		metric.TimeSeries[i] = pair.Timestamp.String()
+	}
+	return metric
+}
+
+// decodeObject decodes a JSON object, calling field to decode the value of
+// every key.
+func decodeObject(dec *json.Decoder, field func(key string) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return decodeError(err)
+		}
+		key, ok := token.(string)
+		if !ok {
+			return decodeError(fmt.Errorf("unexpected %v", token))
+		}
+		if err := field(key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return decodeError(err)
+	}
+	if token != delim {
+		return decodeError(fmt.Errorf("expected %v, got %v", delim, token))
+	}
+	return nil
+}
+
+func skipValue(dec *json.Decoder) error {
+	var skip json.RawMessage
+	return decodeError(dec.Decode(&skip))
+}
+
+// decodeError reports an invalid response like the Prometheus API client,
+// keeping failed connections, which may be retried, as they are.
+func decodeError(err error) error {
+	var promErr *v1.Error
+	var netErr net.Error
+	if err == nil || errors.As(err, &promErr) || errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	return &v1.Error{Type: v1.ErrBadResponse, Msg: err.Error()}
+}
@@ -0,0 +1,235 @@
+package ovs_prom_client
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// series is a series of a matrix with labels like `"bridge":"br0"`.
+func series(labels string, values ...string) string {
+	pairs := make([]string, len(values))
+	for i, v := range values {
+		pairs[i] = `[` + []string{"1600000000", "1600000060", "1600000120"}[i] + `,"` + v + `"]`
+	}
+	return `{"metric":{` + labels + `},"values":[` + strings.Join(pairs, ",") + `]}`
+}
+
+// matrix is a successful range query of series.
+func matrix(series ...string) (int, string) {
+	return http.StatusOK, `{"status":"success","data":{"resultType":"matrix","result":[` + strings.Join(series, ",") + `]}}`
+}
+
+// collect streams the range rate of c and returns the series.
+func collect(t *testing.T, c *OVSClient) ([]TSMetricObj, v1.Warnings, error) {
+	t.Helper()
+	var metrics []TSMetricObj
+	warnings, err := c.AvgbyQueryWithRateStream(t.Context(), "ovs_interface_receive_bytes_total", "5m", func(metric TSMetricObj) error {
+		metrics = append(metrics, metric)
+		return nil
+	})
+	return metrics, warnings, err
+}
+
+func TestAvgbyQueryWithRateStream(t *testing.T) {
+	p := newFakePrometheus(t, answers(matrix(
+		series(`"bridge":"br-int","port":"a"`, "1", "2"),
+		series(`"bridge":"br-int","port":"b"`, "3", "NaN"))))
+	c := p.client(t)
+
+	metrics, warnings, err := collect(t, c)
+	if err != nil || warnings != nil {
+		t.Fatal(err, warnings)
+	}
+	if q := p.lastQuery(); q != "avg by(bridge, port) (rate(ovs_interface_receive_bytes_total[5m])*8)" {
+		t.Errorf("unexpected query %s", q)
+	}
+	want := []TSMetricObj{
+		{
+			Label:      `{bridge="br-int", port="a"}`,
+			Labels:     map[string]string{"bridge": "br-int", "port": "a"},
+			Vals:       []string{"1", "2"},
+			TimeSeries: []string{"1600000000", "1600000060"},
+		},
+		{
+			Label:      `{bridge="br-int", port="b"}`,
+			Labels:     map[string]string{"bridge": "br-int", "port": "b"},
+			Vals:       []string{"3", "NaN"},
+			TimeSeries: []string{"1600000000", "1600000060"},
+		},
+	}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Errorf("got %+v, want %+v", metrics, want)
+	}
+
+	// Same values as the buffered query
+	buffered, _, err := c.AvgbyQueryWithRateContext(t.Context(), "ovs_interface_receive_bytes_total", "5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range buffered {
+		i := 0
+		if b.Labels["port"] == "b" {
+			i = 1
+		}
+		if !reflect.DeepEqual(b.Vals, want[i].Vals) || !reflect.DeepEqual(b.TimeSeries, want[i].TimeSeries) {
+			t.Errorf("buffered %+v differs from streamed %+v", b, want[i])
+		}
+	}
+}
+
+func TestFanOutStream(t *testing.T) {
+	dc1 := answers(matrix(series(`"port":"a"`, "1")))
+	dc2 := answers(matrix(series(`"port":"b"`, "2"), series(`"port":"c"`, "3")))
+	down := answers(apiError(http.StatusUnprocessableEntity, "query timed out"))
+	vec := answers(vector(sample(`"port":"a"`, "1")))
+
+	for _, tc := range []struct {
+		name      string
+		upstreams map[string]answerFunc
+		want      []string // source/port, sorted
+		warnings  []string
+		err       string
+	}{
+		{
+			name:      "all upstreams",
+			upstreams: map[string]answerFunc{"dc1": dc1, "dc2": dc2},
+			want:      []string{"dc1/a", "dc2/b", "dc2/c"},
+		},
+		{
+			name:      "partial failure",
+			upstreams: map[string]answerFunc{"dc1": down, "dc2": dc2},
+			want:      []string{"dc2/b", "dc2/c"},
+			warnings:  []string{"upstream dc1: execution: query timed out"},
+		},
+		{
+			name:      "total failure",
+			upstreams: map[string]answerFunc{"dc1": down, "dc2": down},
+			err:       "all 2 upstreams failed",
+		},
+		{
+			name:      "not a matrix",
+			upstreams: map[string]answerFunc{"dc1": vec},
+			err:       `unexpected result type "vector"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &OVSClient{}
+			for _, name := range []string{"dc1", "dc2"} {
+				if answer, ok := tc.upstreams[name]; ok {
+					p := newFakePrometheus(t, answer)
+					c.AddUpstream(name, p.host, p.port)
+				}
+			}
+
+			metrics, warnings, err := collect(t, c)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, len(metrics))
+			for i, m := range metrics {
+				got[i] = m.Labels[SourceLabel] + "/" + m.Labels["port"]
+			}
+			// Series of several upstreams interleave.
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if len(warnings)+len(tc.warnings) > 0 && !reflect.DeepEqual([]string(warnings), tc.warnings) {
+				t.Errorf("got warnings %q, want %q", warnings, tc.warnings)
+			}
+		})
+	}
+}
+
+func TestStreamStopsOnSeriesError(t *testing.T) {
+	p1 := newFakePrometheus(t, answers(matrix(series(`"port":"a"`, "1"), series(`"port":"b"`, "2"))))
+	p2 := newFakePrometheus(t, answers(matrix(series(`"port":"c"`, "3"))))
+	c := &OVSClient{}
+	c.AddUpstream("dc1", p1.host, p1.port)
+	c.AddUpstream("dc2", p2.host, p2.port)
+
+	failed := errors.New("client gone")
+	n := 0
+	_, err := c.AvgbyQueryWithRateStream(t.Context(), "ovs_interface_receive_bytes_total", "5m", func(metric TSMetricObj) error {
+		n++
+		return failed
+	})
+	if !errors.Is(err, failed) || n != 1 {
+		t.Errorf("expected to stop at the first series, got %v after %d", err, n)
+	}
+}
+
+func TestStreamRetries(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	answer := func(first func() (int, string)) answerFunc {
+		return func(path string, query string) (int, string) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			if calls == 1 {
+				return first()
+			}
+			return matrix(series(`"port":"a"`, "1"))
+		}
+	}
+
+	// Retried before the first series
+	calls = 0
+	p := newFakePrometheus(t, answer(func() (int, string) { return http.StatusInternalServerError, "" }))
+	c := p.client(t)
+	c.Retries = 1
+	metrics, _, err := collect(t, c)
+	if err != nil || len(metrics) != 1 || calls != 2 {
+		t.Errorf("expected a retried query, got %d series after %d calls: %v", len(metrics), calls, err)
+	}
+
+	// Not retried once a series was passed on, which would repeat it
+	calls = 0
+	p = newFakePrometheus(t, answer(func() (int, string) {
+		status, body := matrix(series(`"port":"a"`, "1"), series(`"port":"b"`, "2"))
+		return status, body[:strings.Index(body, `{"metric":{"port":"b"}`)+5]
+	}))
+	c = p.client(t)
+	c.Retries = 1
+	metrics, warnings, err := collect(t, c)
+	if err != nil || len(metrics) != 1 || calls != 1 {
+		t.Errorf("expected one series of a single call, got %d series after %d calls: %v", len(metrics), calls, err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("expected the truncated response as a warning, got %q", warnings)
+	}
+}
+
+func TestLabelNames(t *testing.T) {
+	c := &OVSClient{Enrichers: []Enricher{labelNamer{"tenant", "port"}}}
+	if got := c.LabelNames(); !reflect.DeepEqual(got, []string{"bridge", "port", "tenant"}) {
+		t.Errorf("unexpected labels %v", got)
+	}
+	c.AddUpstream("dc1", "localhost", "9090")
+	if got := c.LabelNames(); !reflect.DeepEqual(got, []string{"bridge", "port", SourceLabel, "tenant"}) {
+		t.Errorf("unexpected labels with upstreams %v", got)
+	}
+}
+
+type labelNamer []string
+
+func (n labelNamer) Enrich(metrics []TSMetricObj) {}
+
+func (n labelNamer) LabelNames() []string {
+	return n
+}
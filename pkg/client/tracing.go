@@ -0,0 +1,73 @@
+package ovs_prom_client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the client spans
+const tracerName string = "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+
+// Span attributes of upstream queries
+const (
+	AttrPromQL   = attribute.Key("promql.query")
+	AttrUpstream = attribute.Key("prometheus.address")
+	AttrSeries   = attribute.Key("promql.series")
+	AttrLabel    = attribute.Key("promql.label")
+)
+
+// newAPIClient returns a client of the Prometheus server at host and port.
+// Its requests carry the trace context of their context, using the global
+// propagator.
+func newAPIClient(host string, port string) (api.Client, error) {
+	return api.NewClient(apiConfig(host, port))
+}
+
+// apiConfig is the configuration of the clients of newAPIClient.
+func apiConfig(host string, port string) api.Config {
+	return api.Config{
+		Address:      fmt.Sprintf("http://%s:%s", host, port),
+		RoundTripper: propagatingRoundTripper{next: api.DefaultRoundTripper},
+	}
+}
+
+// propagatingRoundTripper injects the trace context into outgoing requests
+type propagatingRoundTripper struct {
+	next http.RoundTripper
+}
+
+func (rt propagatingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+	return rt.next.RoundTrip(req)
+}
+
+// startSpan starts a client span of the query to host and port.
+func startSpan(ctx context.Context, name string, host string, port string, query string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(AttrPromQL.String(query), AttrUpstream.String(host+":"+port)))
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startDecodeSpan starts the span of decoding a query result.
+func startDecodeSpan(ctx context.Context) trace.Span {
+	_, span := otel.Tracer(tracerName).Start(ctx, "prometheus.decode")
+	return span
+}
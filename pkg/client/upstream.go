@@ -0,0 +1,138 @@
+package ovs_prom_client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// SourceLabel is the label naming the upstream a series came from
+const SourceLabel string = "source"
+
+// Upstream is one of several named Prometheus servers, e.g. one per
+// datacenter
+type Upstream struct {
+	Name string
+	Host string
+	Port string
+}
+
+// queryFunc runs a query against a single Prometheus server
+type queryFunc func(ctx context.Context, host string, port string, timeout time.Duration, query string) ([]TSMetricObj, v1.Warnings, error)
+
+// AddUpstream adds a named Prometheus server to query.
+func (c *OVSClient) AddUpstream(name string, host string, port string) {
+	c.Upstreams = append(c.Upstreams, Upstream{Name: name, Host: host, Port: port})
+}
+
+func (c *OVSClient) upstreams() []Upstream {
+	if len(c.Upstreams) > 0 {
+		return c.Upstreams
+	}
+	return []Upstream{{Host: c.Host, Port: c.Port}}
+}
+
+func (c *OVSClient) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultTimeout
+}
+
+// fanOut runs query on every upstream concurrently and merges the results.
+// Series of named upstreams get a SourceLabel. Failed upstreams are reported
+// as warnings as long as one upstream answers.
+func (c *OVSClient) fanOut(ctx context.Context, queryType string, fn queryFunc, query string) ([]TSMetricObj, v1.Warnings, error) {
+	type upstreamResult struct {
+		metrics  []TSMetricObj
+		warnings v1.Warnings
+		err      error
+	}
+
+	ups := c.upstreams()
+	results := make([]upstreamResult, len(ups))
+
+	var wg sync.WaitGroup
+	for i, u := range ups {
+		wg.Add(1)
+		go func(i int, u Upstream) {
+			defer wg.Done()
+			r := &results[i]
+			r.metrics, r.warnings, r.err = c.query(ctx, u, queryType, fn, query)
+		}(i, u)
+	}
+	wg.Wait()
+
+	var queryResult []TSMetricObj
+	var warnings v1.Warnings
+	var errs []error
+
+	for i, u := range ups {
+		r := results[i]
+		for _, w := range r.warnings {
+			warnings = append(warnings, upstreamMessage(u, w))
+		}
+		if r.err != nil {
+			if len(ups) > 1 {
+				c.logger().WarnContext(ctx, "upstream query failed", "upstream", u.Name, "type", queryType, "err", r.err)
+			}
+			errs = append(errs, r.err)
+			warnings = append(warnings, upstreamMessage(u, r.err.Error()))
+			continue
+		}
+		if u.Name != "" {
+			for j := range r.metrics {
+				if r.metrics[j].Labels == nil {
+					r.metrics[j].Labels = map[string]string{}
+				}
+				r.metrics[j].Labels[SourceLabel] = u.Name
+			}
+		}
+		queryResult = append(queryResult, r.metrics...)
+	}
+
+	if len(errs) == len(ups) {
+		if len(errs) == 1 {
+			return nil, results[0].warnings, errs[0]
+		}
+		return nil, warnings, fmt.Errorf("all %d upstreams failed, first: %w", len(errs), errs[0])
+	}
+
+	c.enrich(queryResult)
+	return queryResult, warnings, nil
+}
+
+func upstreamMessage(u Upstream, msg string) string {
+	if u.Name == "" {
+		return msg
+	}
+	return fmt.Sprintf("upstream %s: %s", u.Name, msg)
+}
+
+// topK orders metrics by their latest value, highest first, and keeps the
+// first rankSize.
+func topK(metrics []TSMetricObj, rankSize int) []TSMetricObj {
+	latest := func(m TSMetricObj) float64 {
+		if len(m.Vals) == 0 {
+			return 0
+		}
+		v, err := strconv.ParseFloat(m.Vals[len(m.Vals)-1], 64)
+		if err != nil {
+			return 0
+		}
+		return v
+	}
+
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return latest(metrics[i]) > latest(metrics[j])
+	})
+	if rankSize >= 0 && rankSize < len(metrics) {
+		metrics = metrics[:rankSize]
+	}
+	return metrics
+}
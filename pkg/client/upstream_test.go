@@ -0,0 +1,146 @@
+package ovs_prom_client
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// answers returns the fixed answer of an upstream.
+func answers(status int, body string) answerFunc {
+	return func(path string, query string) (int, string) {
+		return status, body
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	dc1 := answers(vector(sample(`"bridge":"br-int","port":"a"`, "10"), sample(`"bridge":"br-int","port":"b"`, "40")))
+	dc2 := answers(vector(sample(`"bridge":"br-int","port":"a"`, "30"), sample(`"bridge":"br-ex","port":"c"`, "20")))
+	down := answers(apiError(http.StatusUnprocessableEntity, "query timed out"))
+
+	for _, tc := range []struct {
+		name      string
+		upstreams map[string]answerFunc // by name, "" for Host and Port
+		rank      int
+		want      []string // source/port, ranked
+		warnings  []string
+		err       string
+	}{
+		{
+			name:      "single server",
+			upstreams: map[string]answerFunc{"": dc1},
+			rank:      10,
+			want:      []string{"/b", "/a"},
+		},
+		{
+			name:      "merged and re-ranked",
+			upstreams: map[string]answerFunc{"dc1": dc1, "dc2": dc2},
+			rank:      10,
+			want:      []string{"dc1/b", "dc2/a", "dc2/c", "dc1/a"},
+		},
+		{
+			name:      "global top-N",
+			upstreams: map[string]answerFunc{"dc1": dc1, "dc2": dc2},
+			rank:      2,
+			want:      []string{"dc1/b", "dc2/a"},
+		},
+		{
+			name:      "partial failure",
+			upstreams: map[string]answerFunc{"dc1": dc1, "dc2": down},
+			rank:      10,
+			want:      []string{"dc1/b", "dc1/a"},
+			warnings:  []string{"upstream dc2: execution: query timed out"},
+		},
+		{
+			name:      "total failure",
+			upstreams: map[string]answerFunc{"dc1": down, "dc2": down},
+			rank:      10,
+			err:       "all 2 upstreams failed",
+		},
+		{
+			name:      "single server failure",
+			upstreams: map[string]answerFunc{"": down},
+			rank:      10,
+			err:       "query timed out",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var c *OVSClient
+			for _, name := range []string{"", "dc1", "dc2"} {
+				answer, ok := tc.upstreams[name]
+				if !ok {
+					continue
+				}
+				p := newFakePrometheus(t, answer)
+				if name == "" {
+					c = p.client(t)
+					continue
+				}
+				if c == nil {
+					c = &OVSClient{}
+				}
+				c.AddUpstream(name, p.host, p.port)
+			}
+
+			metrics, warnings, err := c.NtopQueryWithRateContext(t.Context(), tc.rank, "ovs_interface_receive_bytes_total", "5m")
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, len(metrics))
+			for i, m := range metrics {
+				got[i] = m.Labels[SourceLabel] + "/" + m.Labels["port"]
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if len(warnings)+len(tc.warnings) > 0 && !reflect.DeepEqual([]string(warnings), tc.warnings) {
+				t.Errorf("got warnings %q, want %q", warnings, tc.warnings)
+			}
+		})
+	}
+}
+
+func TestFanOutKeepsUpstreamWarnings(t *testing.T) {
+	warned := answers(http.StatusOK, `{"status":"success","warnings":["partial data"],`+
+		`"data":{"resultType":"vector","result":[`+sample(`"port":"a"`, "1")+`]}}`)
+	p1 := newFakePrometheus(t, warned)
+	p2 := newFakePrometheus(t, answers(vector()))
+
+	c := &OVSClient{}
+	c.AddUpstream("dc1", p1.host, p1.port)
+	c.AddUpstream("dc2", p2.host, p2.port)
+	metrics, warnings, err := c.RateQueryContext(t.Context(), "ovs_interface_receive_bytes_total", "5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Labels[SourceLabel] != "dc1" {
+		t.Errorf("unexpected series %+v", metrics)
+	}
+	if len(warnings) != 1 || warnings[0] != "upstream dc1: partial data" {
+		t.Errorf("unexpected warnings %q", warnings)
+	}
+}
+
+func TestTopK(t *testing.T) {
+	metrics := []TSMetricObj{
+		{Labels: map[string]string{"port": "a"}, Vals: []string{"5", "1"}},
+		{Labels: map[string]string{"port": "b"}, Vals: []string{"2"}},
+		{Labels: map[string]string{"port": "none"}},
+		{Labels: map[string]string{"port": "nan"}, Vals: []string{"x"}},
+		{Labels: map[string]string{"port": "c"}, Vals: []string{"3"}},
+	}
+	if got := ports(topK(metrics, 2)); !reflect.DeepEqual(got, []string{"c", "b"}) {
+		t.Errorf("expected the latest values to rank, got %v", got)
+	}
+	if got := ports(topK(metrics, -1)); len(got) != 5 || got[2] != "a" {
+		t.Errorf("expected every series without a rank size, got %v", got)
+	}
+}
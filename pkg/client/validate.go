@@ -0,0 +1,113 @@
+package ovs_prom_client
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// MinScrapeIntervals is how many scrape intervals a rate() window must span.
+// Shorter windows often hold fewer than two samples and rate() returns
+// nothing or jumps around.
+const MinScrapeIntervals int = 4
+
+// Query parameters checked by the validators
+const (
+	ParamMetric   string = "metric"
+	ParamDuration string = "duration"
+	ParamRank     string = "rank"
+)
+
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// ValidationError reports an invalid query parameter, with a corrected value
+// when one can be suggested. Query methods return it before querying.
+type ValidationError struct {
+	Param      string
+	Value      string
+	Reason     string
+	Suggestion string
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("invalid %s %q: %s", e.Param, e.Value, e.Reason)
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(", try %s", e.Suggestion)
+	}
+	return msg
+}
+
+// ValidateMetric checks that metric is a plain metric name.
+func ValidateMetric(metric string) error {
+	if !metricNamePattern.MatchString(metric) {
+		return &ValidationError{Param: ParamMetric, Value: metric, Reason: "not a metric name"}
+	}
+	return nil
+}
+
+// ParseDuration parses a range duration in Prometheus syntax, e.g. "5m" or
+// "1h30m". Anything else, such as "5m])or vector(1", is rejected.
+func ParseDuration(duration string) (time.Duration, error) {
+	d, err := model.ParseDuration(duration)
+	if err != nil {
+		return 0, &ValidationError{Param: ParamDuration, Value: duration, Reason: "not a duration like 30s, 5m or 1h"}
+	}
+	if d <= 0 {
+		return 0, &ValidationError{Param: ParamDuration, Value: duration, Reason: "must be positive"}
+	}
+	return time.Duration(d), nil
+}
+
+// CheckRateWindow checks that a rate() window spans at least
+// MinScrapeIntervals scrapes and, when max is positive, at most max.
+func CheckRateWindow(window time.Duration, scrapeInterval time.Duration, max time.Duration) error {
+	value := model.Duration(window).String()
+
+	if min := time.Duration(MinScrapeIntervals) * scrapeInterval; window < min {
+		return &ValidationError{
+			Param:      ParamDuration,
+			Value:      value,
+			Reason:     fmt.Sprintf("rate() needs at least %d scrape intervals of %s", MinScrapeIntervals, model.Duration(scrapeInterval)),
+			Suggestion: model.Duration(min).String(),
+		}
+	}
+	if max > 0 && window > max {
+		return &ValidationError{
+			Param:      ParamDuration,
+			Value:      value,
+			Reason:     fmt.Sprintf("exceeds the limit of %s", model.Duration(max)),
+			Suggestion: model.Duration(max).String(),
+		}
+	}
+	return nil
+}
+
+// ValidateRank checks that rank is at least 1 and, when max is positive, at
+// most max.
+func ValidateRank(rank int, max int) error {
+	value := strconv.Itoa(rank)
+
+	if rank < 1 {
+		return &ValidationError{Param: ParamRank, Value: value, Reason: "must be at least 1", Suggestion: "1"}
+	}
+	if max > 0 && rank > max {
+		return &ValidationError{Param: ParamRank, Value: value, Reason: fmt.Sprintf("exceeds the limit of %d", max), Suggestion: strconv.Itoa(max)}
+	}
+	return nil
+}
+
+// rateParams validates the metric and duration of a rate query and returns
+// the duration in canonical form.
+func rateParams(metric string, duration string) (string, error) {
+	if err := ValidateMetric(metric); err != nil {
+		return "", err
+	}
+	d, err := ParseDuration(duration)
+	if err != nil {
+		return "", err
+	}
+	return model.Duration(d).String(), nil
+}
@@ -0,0 +1,37 @@
+package ovs_prom_client
+
+import "runtime"
+
+// Build information, set by the Makefile with -ldflags "-X ..."
+var (
+	appName    = "prom-ovs-apiserver"
+	appVersion = "unknown"
+	gitCommit  = "unknown"
+	gitBranch  = "unknown"
+	buildUser  = "unknown"
+	buildDate  = "unknown"
+)
+
+// VersionInfo is the build information of the binary
+type VersionInfo struct {
+	AppName    string `json:"app_name"`
+	AppVersion string `json:"app_version"`
+	GitCommit  string `json:"git_commit"`
+	GitBranch  string `json:"git_branch"`
+	BuildUser  string `json:"build_user"`
+	BuildDate  string `json:"build_date"`
+	GoVersion  string `json:"go_version"`
+}
+
+// Version returns the build information injected at link time.
+func Version() VersionInfo {
+	return VersionInfo{
+		AppName:    appName,
+		AppVersion: appVersion,
+		GitCommit:  gitCommit,
+		GitBranch:  gitBranch,
+		BuildUser:  buildUser,
+		BuildDate:  buildDate,
+		GoVersion:  runtime.Version(),
+	}
+}
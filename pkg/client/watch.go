@@ -0,0 +1,163 @@
+package ovs_prom_client
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// MaxWatchBackoff caps the wait between failed queries of a watch
+const MaxWatchBackoff time.Duration = 5 * time.Minute
+
+// Snapshot is one result of a watched query, ranked by value with the
+// highest first, and its difference to the previous successful result.
+type Snapshot struct {
+	Time     time.Time
+	Metrics  []TSMetricObj
+	Warnings v1.Warnings
+	// Err is set when the query failed. Metrics and the diff are then empty
+	// and the next snapshot is compared to the last successful one.
+	Err error
+
+	Added       []TSMetricObj
+	Removed     []TSMetricObj
+	RankChanges []RankChange
+}
+
+// RankChange is a series that moved in the ranking. Ranks start at 1.
+type RankChange struct {
+	Metric TSMetricObj
+	From   int
+	To     int
+}
+
+// Changed reports whether the ranking differs from the previous snapshot.
+func (s *Snapshot) Changed() bool {
+	return len(s.Added) > 0 || len(s.Removed) > 0 || len(s.RankChanges) > 0
+}
+
+// WatchQueryFunc fetches the result of a watch, e.g. with one of the
+// XContext methods of OVSClient.
+type WatchQueryFunc func(ctx context.Context) ([]TSMetricObj, v1.Warnings, error)
+
+// Watch runs the instant query every interval and sends a snapshot of each
+// result. In the first snapshot every series is added. After a failure the
+// wait doubles, up to MaxWatchBackoff. The channel is closed once ctx is
+// done.
+func (c *OVSClient) Watch(ctx context.Context, query string, interval time.Duration) <-chan Snapshot {
+	return c.WatchFunc(ctx, func(ctx context.Context) ([]TSMetricObj, v1.Warnings, error) {
+		return c.QueryContext(ctx, query)
+	}, interval)
+}
+
+// WatchTopK is Watch of NtopQueryWithRate.
+func (c *OVSClient) WatchTopK(ctx context.Context, rankSize int, metric string, duration string, interval time.Duration) <-chan Snapshot {
+	return c.WatchFunc(ctx, func(ctx context.Context) ([]TSMetricObj, v1.Warnings, error) {
+		return c.NtopQueryWithRateContext(ctx, rankSize, metric, duration)
+	}, interval)
+}
+
+// WatchFunc is Watch of any query function.
+func (c *OVSClient) WatchFunc(ctx context.Context, fn WatchQueryFunc, interval time.Duration) <-chan Snapshot {
+	ch := make(chan Snapshot)
+	go func() {
+		defer close(ch)
+
+		var prev []TSMetricObj
+		failures := 0
+		for {
+			snap := Snapshot{Time: time.Now()}
+			metrics, warnings, err := fn(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+
+			wait := interval
+			if err != nil {
+				snap.Err = err
+				snap.Warnings = warnings
+				failures++
+				wait = watchBackoff(interval, failures)
+				c.logger().WarnContext(ctx, "watched query failed", "retry_in", wait, "err", err)
+			} else {
+				failures = 0
+				snap.Metrics = topK(metrics, -1)
+				snap.Warnings = warnings
+				snap.Added, snap.Removed, snap.RankChanges = diffRanking(prev, snap.Metrics)
+				prev = snap.Metrics
+			}
+
+			select {
+			case ch <- snap:
+			case <-ctx.Done():
+				return
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// watchBackoff is the wait after the given number of consecutive failures.
+func watchBackoff(interval time.Duration, failures int) time.Duration {
+	wait := interval
+	for i := 0; i < failures && wait < MaxWatchBackoff; i++ {
+		wait *= 2
+	}
+	if wait > MaxWatchBackoff {
+		wait = MaxWatchBackoff
+	}
+	return wait
+}
+
+// diffRanking compares two rankings by label set.
+func diffRanking(prev, next []TSMetricObj) ([]TSMetricObj, []TSMetricObj, []RankChange) {
+	prevRanks := make(map[string]int, len(prev))
+	for i, metric := range prev {
+		prevRanks[labelsKey(metric.Labels)] = i + 1
+	}
+
+	var added []TSMetricObj
+	var changes []RankChange
+	seen := make(map[string]bool, len(next))
+	for i, metric := range next {
+		key := labelsKey(metric.Labels)
+		seen[key] = true
+		from, ok := prevRanks[key]
+		switch {
+		case !ok:
+			added = append(added, metric)
+		case from != i+1:
+			changes = append(changes, RankChange{Metric: metric, From: from, To: i + 1})
+		}
+	}
+
+	var removed []TSMetricObj
+	for _, metric := range prev {
+		if !seen[labelsKey(metric.Labels)] {
+			removed = append(removed, metric)
+		}
+	}
+	return added, removed, changes
+}
+
+// labelsKey identifies a series by its sorted label pairs.
+func labelsKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+strconv.Quote(value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
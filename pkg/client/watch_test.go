@@ -0,0 +1,237 @@
+package ovs_prom_client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func ports(metrics []TSMetricObj) []string {
+	names := make([]string, len(metrics))
+	for i, m := range metrics {
+		names[i] = m.Labels["port"]
+	}
+	return names
+}
+
+func ranked(names ...string) []TSMetricObj {
+	metrics := make([]TSMetricObj, len(names))
+	for i, name := range names {
+		metrics[i] = TSMetricObj{Labels: map[string]string{"port": name}}
+	}
+	return metrics
+}
+
+func TestDiffRanking(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		prev, next     []string
+		added, removed []string
+		changes        []RankChange
+	}{
+		{name: "first", next: []string{"a", "b"}, added: []string{"a", "b"}},
+		{name: "same", prev: []string{"a", "b"}, next: []string{"a", "b"}},
+		{name: "entered and left", prev: []string{"a", "b"}, next: []string{"a", "c"}, added: []string{"c"}, removed: []string{"b"}},
+		{name: "moved", prev: []string{"a", "b", "c"}, next: []string{"c", "a", "b"},
+			changes: []RankChange{{From: 3, To: 1}, {From: 1, To: 2}, {From: 2, To: 3}}},
+		{name: "emptied", prev: []string{"a"}, removed: []string{"a"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			added, removed, changes := diffRanking(ranked(tc.prev...), ranked(tc.next...))
+			if got := ports(added); len(got)+len(tc.added) > 0 && !reflect.DeepEqual(got, tc.added) {
+				t.Errorf("added %v, want %v", got, tc.added)
+			}
+			if got := ports(removed); len(got)+len(tc.removed) > 0 && !reflect.DeepEqual(got, tc.removed) {
+				t.Errorf("removed %v, want %v", got, tc.removed)
+			}
+			if len(changes) != len(tc.changes) {
+				t.Fatalf("changes %+v, want %+v", changes, tc.changes)
+			}
+			for i, c := range changes {
+				if c.From != tc.changes[i].From || c.To != tc.changes[i].To {
+					t.Errorf("change %d is %s %d->%d, want %d->%d", i, c.Metric.Labels["port"], c.From, c.To, tc.changes[i].From, tc.changes[i].To)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		interval time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{time.Second, 0, time.Second},
+		{time.Second, 1, 2 * time.Second},
+		{time.Second, 3, 8 * time.Second},
+		{time.Minute, 10, MaxWatchBackoff},
+		{10 * time.Minute, 0, MaxWatchBackoff},
+	} {
+		if got := watchBackoff(tc.interval, tc.failures); got != tc.want {
+			t.Errorf("watchBackoff(%s, %d) = %s, want %s", tc.interval, tc.failures, got, tc.want)
+		}
+	}
+}
+
+// receive returns the next snapshot of ch, failing after a while.
+func receive(t *testing.T, ch <-chan Snapshot) Snapshot {
+	t.Helper()
+	select {
+	case snap, ok := <-ch:
+		if !ok {
+			t.Fatal("watch closed")
+		}
+		return snap
+	case <-time.After(5 * time.Second):
+		t.Fatal("no snapshot")
+	}
+	return Snapshot{}
+}
+
+func TestWatch(t *testing.T) {
+	answers := [][]string{
+		{sample(`"port":"a"`, "3"), sample(`"port":"b"`, "2"), sample(`"port":"c"`, "1")},
+		{sample(`"port":"c"`, "9"), sample(`"port":"a"`, "3"), sample(`"port":"d"`, "1")},
+		{sample(`"port":"c"`, "9"), sample(`"port":"a"`, "3"), sample(`"port":"d"`, "1")},
+	}
+	var mu sync.Mutex
+	calls := 0
+	p := newFakePrometheus(t, func(path string, query string) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		answer := answers[min(calls, len(answers)-1)]
+		calls++
+		return vector(answer...)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := p.client(t).Watch(ctx, "rate(x[5m])", time.Millisecond)
+
+	snap := receive(t, ch)
+	if snap.Err != nil || !reflect.DeepEqual(ports(snap.Metrics), []string{"a", "b", "c"}) || len(snap.Added) != 3 {
+		t.Fatalf("unexpected first snapshot %+v", snap)
+	}
+	if q := p.lastQuery(); q != "rate(x[5m])" {
+		t.Errorf("unexpected query %s", q)
+	}
+
+	snap = receive(t, ch)
+	if !reflect.DeepEqual(ports(snap.Added), []string{"d"}) || !reflect.DeepEqual(ports(snap.Removed), []string{"b"}) {
+		t.Errorf("expected d to enter and b to leave, got %+v", snap)
+	}
+	if len(snap.RankChanges) != 2 || snap.RankChanges[0].Metric.Labels["port"] != "c" || snap.RankChanges[0].From != 3 || snap.RankChanges[0].To != 1 {
+		t.Errorf("expected c to move to the top, got %+v", snap.RankChanges)
+	}
+
+	if snap = receive(t, ch); snap.Changed() {
+		t.Errorf("expected an unchanged ranking, got %+v", snap)
+	}
+}
+
+func TestWatchTopK(t *testing.T) {
+	p := newFakePrometheus(t, func(path string, query string) (int, string) {
+		return vector(sample(`"bridge":"br0","port":"a"`, "1"), sample(`"bridge":"br0","port":"b"`, "2"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	snap := receive(t, p.client(t).WatchTopK(ctx, 5, "ovs_interface_receive_bytes_total", "1m", time.Hour))
+	if q := p.lastQuery(); q != "topk(5, avg by (bridge, port)(rate(ovs_interface_receive_bytes_total[1m])*8))" {
+		t.Errorf("unexpected query %s", q)
+	}
+	if !reflect.DeepEqual(ports(snap.Metrics), []string{"b", "a"}) {
+		t.Errorf("expected b first, got %+v", snap.Metrics)
+	}
+}
+
+func TestWatchBacksOffAfterErrors(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	var mu sync.Mutex
+	var calls []time.Time
+	p := newFakePrometheus(t, func(path string, query string) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, time.Now())
+		switch len(calls) {
+		case 1, 4:
+			return vector(sample(`"port":"a"`, "1"))
+		default:
+			return apiError(http.StatusBadRequest, "bad query")
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := p.client(t).Watch(ctx, "x", interval)
+
+	if snap := receive(t, ch); snap.Err != nil {
+		t.Fatal(snap.Err)
+	}
+	for i := 0; i < 2; i++ {
+		if snap := receive(t, ch); snap.Err == nil || snap.Metrics != nil || snap.Changed() {
+			t.Fatalf("expected a failed snapshot, got %+v", snap)
+		}
+	}
+	// Compared to the last successful snapshot, not to the failures
+	if snap := receive(t, ch); snap.Err != nil || snap.Changed() {
+		t.Fatalf("expected an unchanged snapshot, got %+v", snap)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// interval after the success, then twice and four times as long
+	for i, want := range []time.Duration{interval, 2 * interval, 4 * interval} {
+		if got := calls[i+1].Sub(calls[i]); got < want {
+			t.Errorf("wait %d was %s, want at least %s", i, got, want)
+		}
+	}
+}
+
+func TestWatchClosesOnCancel(t *testing.T) {
+	p := newFakePrometheus(t, func(path string, query string) (int, string) {
+		return vector(sample(`"port":"a"`, "1"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := p.client(t).Watch(ctx, "x", time.Hour)
+	receive(t, ch)
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("expected no snapshot after the cancellation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch not closed")
+	}
+}
+
+func TestWatchFuncClosesWhileQuerying(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	ch := (&OVSClient{}).WatchFunc(ctx, func(ctx context.Context) ([]TSMetricObj, v1.Warnings, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, nil, errors.New("canceled")
+	}, time.Hour)
+
+	<-started
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("expected no snapshot of a canceled query")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch not closed")
+	}
+}
@@ -0,0 +1,664 @@
+// Package config holds the configuration of the OVS API server.
+//
+// Every setting can be given, from highest to lowest precedence, as
+//
+//  1. a command-line flag, e.g. -prometheus.host=10.0.0.1
+//  2. an environment variable, e.g. HELIOS_PROMETHEUS_HOST=10.0.0.1
+//  3. a key in the YAML file named by -config.file (or HELIOS_CONFIG_FILE),
+//     e.g. prometheus: {host: 10.0.0.1}
+//  4. the built-in default
+//
+// Environment variable names are the flag name upper-cased, with "." and "-"
+// replaced by "_" and prefixed with HELIOS_. YAML keys are the flag name split
+// at "." into sections, with "-" replaced by "_", e.g. web.listen-address is
+//
+//	web:
+//	  listen_address: ":8081"
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix prefixes the environment variable of every setting
+const EnvPrefix string = "HELIOS_"
+
+// FlagConfigFile is the flag naming the YAML configuration file
+const FlagConfigFile string = "config.file"
+
+// Log levels
+const (
+	LogLevelDebug string = "debug"
+	LogLevelInfo  string = "info"
+	LogLevelWarn  string = "warn"
+	LogLevelError string = "error"
+)
+
+// Log formats
+const (
+	LogFormatText string = "text"
+	LogFormatJSON string = "json"
+)
+
+// Trace exporters
+const (
+	TracingExporterNone   string = "none"
+	TracingExporterOTLP   string = "otlp"
+	TracingExporterStdout string = "stdout"
+)
+
+// Storage backends
+const (
+	StorageBackendBolt   string = "bolt"
+	StorageBackendMemory string = "memory"
+)
+
+var upstreamNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Duration is a time.Duration read in Prometheus duration syntax, e.g. "90s"
+// or "1d"
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// Set implements flag.Value.
+func (d *Duration) Set(s string) error {
+	v, err := model.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) String() string {
+	return model.Duration(d).String()
+}
+
+// UpstreamConfig is one of several named Prometheus servers
+type UpstreamConfig struct {
+	Name string `yaml:"name"`
+	Host string `yaml:"host"`
+	Port string `yaml:"port"`
+}
+
+// Upstreams are named Prometheus servers, given on the command line as
+// "name=host:port,name=host:port"
+type Upstreams []UpstreamConfig
+
+// Set implements flag.Value.
+func (u *Upstreams) Set(s string) error {
+	var ups Upstreams
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		eq := strings.Index(entry, "=")
+		if eq < 0 {
+			return fmt.Errorf("%q is not name=host:port", entry)
+		}
+		host, port, err := net.SplitHostPort(entry[eq+1:])
+		if err != nil {
+			return fmt.Errorf("%q is not name=host:port", entry)
+		}
+		ups = append(ups, UpstreamConfig{Name: entry[:eq], Host: host, Port: port})
+	}
+	*u = ups
+	return nil
+}
+
+func (u *Upstreams) String() string {
+	entries := make([]string, 0, len(*u))
+	for _, up := range *u {
+		entries = append(entries, up.Name+"="+net.JoinHostPort(up.Host, up.Port))
+	}
+	return strings.Join(entries, ",")
+}
+
+// Strings is a list given on the command line as "a,b"
+type Strings []string
+
+// Set implements flag.Value.
+func (l *Strings) Set(s string) error {
+	var list Strings
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	*l = list
+	return nil
+}
+
+func (l *Strings) String() string {
+	return strings.Join(*l, ",")
+}
+
+// PrometheusConfig is the upstream Prometheus server. When Upstreams are
+// given, queries fan out to all of them and Host and Port are not used.
+// ScrapeInterval is that of the OVS exporters; rate() windows shorter than
+// four scrape intervals are rejected. The recording rules of the upstreams
+// are looked up every RecordingRulesRefresh, never when it is zero.
+type PrometheusConfig struct {
+	Host           string    `yaml:"host"`
+	Port           string    `yaml:"port"`
+	Version        string    `yaml:"version"`
+	Timeout        Duration  `yaml:"timeout"`
+	Retries        int       `yaml:"retries"`
+	ScrapeInterval Duration  `yaml:"scrape_interval"`
+	Upstreams      Upstreams `yaml:"upstreams,omitempty"`
+
+	RecordingRulesRefresh Duration `yaml:"recording_rules_refresh"`
+}
+
+// WebConfig is the HTTP API server. WriteTimeout bounds a whole request,
+// queries included, and ShutdownTimeout how long in-flight requests may
+// drain on SIGTERM.
+type WebConfig struct {
+	ListenAddress   string   `yaml:"listen_address"`
+	ReadTimeout     Duration `yaml:"read_timeout"`
+	WriteTimeout    Duration `yaml:"write_timeout"`
+	IdleTimeout     Duration `yaml:"idle_timeout"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+}
+
+// GRPCConfig is the gRPC API server, disabled when ListenAddress is empty
+type GRPCConfig struct {
+	ListenAddress string `yaml:"listen_address"`
+}
+
+// RateLimit is a token bucket of Rate requests per second and bursts of up
+// to Burst requests. A zero Rate disables it.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// LimitsConfig bounds what a single request may ask for and the load
+// clients may cause. Every API key, or client IP without authentication,
+// gets a token bucket per route: Routes by route template or gRPC method,
+// Rate and Burst otherwise. MaxConcurrentQueries caps the upstream queries
+// of the whole server, waiting up to QueueTimeout for a slot.
+type LimitsConfig struct {
+	MaxRank              int                  `yaml:"max_rank"`
+	MaxDuration          Duration             `yaml:"max_duration"`
+	Rate                 float64              `yaml:"rate"`
+	Burst                int                  `yaml:"burst"`
+	Routes               map[string]RateLimit `yaml:"routes,omitempty"`
+	MaxConcurrentQueries int                  `yaml:"max_concurrent_queries"`
+	QueueTimeout         Duration             `yaml:"queue_timeout"`
+}
+
+// RouteLimit returns the rate limit of a route template or gRPC method.
+func (c LimitsConfig) RouteLimit(route string) RateLimit {
+	if limit, ok := c.Routes[route]; ok {
+		return limit
+	}
+	return RateLimit{Rate: c.Rate, Burst: c.Burst}
+}
+
+// LogConfig is the server logging
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// EnrichConfig are the sources of extra port labels
+type EnrichConfig struct {
+	OVSDBAddress    string   `yaml:"ovsdb_address"`
+	OVNNBAddress    string   `yaml:"ovn_nb_address"`
+	MappingFile     string   `yaml:"mapping_file"`
+	RefreshInterval Duration `yaml:"refresh_interval"`
+}
+
+// TracingConfig is the OpenTelemetry trace export. Endpoint is the OTLP/HTTP
+// collector URL.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// AuthConfig is the authentication of API clients, enabled when an API keys
+// or JWKS file is set. PolicyFile limits what principals may see. Its
+// tenants are matched on TenantLabel, and refused when the series carry no
+// tenant label.
+type AuthConfig struct {
+	APIKeysFile string `yaml:"api_keys_file"`
+	JWKSFile    string `yaml:"jwks_file"`
+	JWTIssuer   string `yaml:"jwt_issuer"`
+	JWTAudience string `yaml:"jwt_audience"`
+	PolicyFile  string `yaml:"policy_file"`
+	TenantLabel string `yaml:"tenant_label"`
+}
+
+// Enabled reports whether clients must authenticate.
+func (c AuthConfig) Enabled() bool {
+	return c.APIKeysFile != "" || c.JWKSFile != ""
+}
+
+// StorageConfig is where saved queries and dashboards are kept: a BoltDB
+// file at Path, or memory only
+type StorageConfig struct {
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
+}
+
+// AlertingConfig is the evaluation of alert rules and their notification.
+// Each webhook and Alertmanager is tried once and up to WebhookRetries more
+// times, each attempt within WebhookTimeout. Alertmanagers are reminded of
+// firing alerts every ResendInterval; ExternalURL is the URL of this server
+// linked from their alerts.
+type AlertingConfig struct {
+	EvaluationInterval Duration `yaml:"evaluation_interval"`
+	WebhookURLs        Strings  `yaml:"webhook_urls,omitempty"`
+	WebhookRetries     int      `yaml:"webhook_retries"`
+	WebhookTimeout     Duration `yaml:"webhook_timeout"`
+	AlertmanagerURLs   Strings  `yaml:"alertmanager_urls,omitempty"`
+	ResendInterval     Duration `yaml:"resend_interval"`
+	ExternalURL        string   `yaml:"external_url"`
+}
+
+// QueriesConfig are the named queries
+type QueriesConfig struct {
+	File string `yaml:"file"`
+}
+
+// Config is the configuration of the API server
+type Config struct {
+	Prometheus PrometheusConfig `yaml:"prometheus"`
+	Web        WebConfig        `yaml:"web"`
+	GRPC       GRPCConfig       `yaml:"grpc"`
+	Limits     LimitsConfig     `yaml:"limits"`
+	Log        LogConfig        `yaml:"log"`
+	Enrich     EnrichConfig     `yaml:"enrich"`
+	Queries    QueriesConfig    `yaml:"queries"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Alerting   AlertingConfig   `yaml:"alerting"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Auth       AuthConfig       `yaml:"auth"`
+
+	// File is the YAML file the configuration was read from, if any
+	File string `yaml:"-"`
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		Prometheus: PrometheusConfig{
+			Host:           "localhost",
+			Port:           "9090",
+			Version:        "v1",
+			Timeout:        Duration(10 * time.Second),
+			Retries:        1,
+			ScrapeInterval: Duration(15 * time.Second),
+
+			RecordingRulesRefresh: Duration(time.Minute),
+		},
+		Web: WebConfig{
+			ListenAddress:   ":8081",
+			ReadTimeout:     Duration(10 * time.Second),
+			WriteTimeout:    Duration(2 * time.Minute),
+			IdleTimeout:     Duration(2 * time.Minute),
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+		GRPC: GRPCConfig{
+			ListenAddress: ":8082",
+		},
+		Limits: LimitsConfig{
+			MaxRank:      100,
+			MaxDuration:  Duration(24 * time.Hour),
+			Burst:        20,
+			QueueTimeout: Duration(time.Second),
+		},
+		Log: LogConfig{
+			Level:  LogLevelInfo,
+			Format: LogFormatText,
+		},
+		Enrich: EnrichConfig{
+			RefreshInterval: Duration(30 * time.Second),
+		},
+		Queries: QueriesConfig{
+			File: "queries.yaml",
+		},
+		Storage: StorageConfig{
+			Backend: StorageBackendBolt,
+			Path:    "helios.db",
+		},
+		Alerting: AlertingConfig{
+			EvaluationInterval: Duration(time.Minute),
+			WebhookRetries:     3,
+			WebhookTimeout:     Duration(10 * time.Second),
+			ResendInterval:     Duration(time.Minute),
+		},
+		Tracing: TracingConfig{
+			Exporter:    TracingExporterNone,
+			Endpoint:    "http://localhost:4318",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// stringValue is a flag.Value setting a string field
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+func (v stringValue) String() string     { return *v.p }
+
+// intValue is a flag.Value setting an int field
+type intValue struct{ p *int }
+
+func (v intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("not an integer: %q", s)
+	}
+	*v.p = i
+	return nil
+}
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+
+// floatValue is a flag.Value setting a float64 field
+type floatValue struct{ p *float64 }
+
+func (v floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("not a number: %q", s)
+	}
+	*v.p = f
+	return nil
+}
+func (v floatValue) String() string { return strconv.FormatFloat(*v.p, 'g', -1, 64) }
+
+// setting binds a flag and an environment variable to a field of Config
+type setting struct {
+	name  string
+	usage string
+	value flag.Value
+}
+
+// env returns the environment variable of a setting.
+func (s setting) env() string {
+	return EnvName(s.name)
+}
+
+// EnvName returns the environment variable of the flag name.
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"prometheus.host", "Prometheus server host", stringValue{&c.Prometheus.Host}},
+		{"prometheus.port", "Prometheus server port", stringValue{&c.Prometheus.Port}},
+		{"prometheus.version", "OVS client API version", stringValue{&c.Prometheus.Version}},
+		{"prometheus.timeout", "Timeout of a single Prometheus query", &c.Prometheus.Timeout},
+		{"prometheus.retries", "How often a query failing with a server or connection error is retried within the timeout", intValue{&c.Prometheus.Retries}},
+		{"prometheus.scrape-interval", "Scrape interval of the OVS exporters; rate() windows must be 4 times as long", &c.Prometheus.ScrapeInterval},
+		{"prometheus.upstreams", "Named Prometheus servers as name=host:port,... (replaces host and port)", &c.Prometheus.Upstreams},
+		{"prometheus.recording-rules-refresh", "How often the recording rules of the upstreams are looked up, 0 to never read recorded series", &c.Prometheus.RecordingRulesRefresh},
+		{"web.listen-address", "Address to listen on for the API", stringValue{&c.Web.ListenAddress}},
+		{"web.read-timeout", "Timeout to read a whole request, headers included", &c.Web.ReadTimeout},
+		{"web.write-timeout", "Timeout to handle a request and write its response", &c.Web.WriteTimeout},
+		{"web.idle-timeout", "How long idle keep-alive connections are kept open", &c.Web.IdleTimeout},
+		{"web.shutdown-timeout", "How long in-flight requests may drain on SIGTERM", &c.Web.ShutdownTimeout},
+		{"grpc.listen-address", "Address to listen on for the gRPC API, empty to disable it", stringValue{&c.GRPC.ListenAddress}},
+		{"limits.max-rank", "Largest rank a topk request may ask for", intValue{&c.Limits.MaxRank}},
+		{"limits.max-duration", "Longest rate() window a request may ask for", &c.Limits.MaxDuration},
+		{"limits.rate", "Requests per second per API key or client IP and route, 0 for no limit", floatValue{&c.Limits.Rate}},
+		{"limits.burst", "Requests per API key or client IP and route allowed at once", intValue{&c.Limits.Burst}},
+		{"limits.max-concurrent-queries", "Concurrent Prometheus queries of the server, 0 for no limit", intValue{&c.Limits.MaxConcurrentQueries}},
+		{"limits.queue-timeout", "How long a query waits for one of limits.max-concurrent-queries", &c.Limits.QueueTimeout},
+		{"log.level", "Log level: debug, info, warn or error", stringValue{&c.Log.Level}},
+		{"log.format", "Log format: text or json", stringValue{&c.Log.Format}},
+		{"enrich.ovsdb-address", "OVSDB address for port enrichment, e.g. unix:/var/run/openvswitch/db.sock", stringValue{&c.Enrich.OVSDBAddress}},
+		{"enrich.ovn-nb-address", "OVN Northbound DB address for logical topology, e.g. tcp:127.0.0.1:6641", stringValue{&c.Enrich.OVNNBAddress}},
+		{"enrich.mapping-file", "Static bridge/port to tenant mapping file (YAML or CSV)", stringValue{&c.Enrich.MappingFile}},
+		{"enrich.refresh-interval", "How often OVSDB and OVN sources are re-read", &c.Enrich.RefreshInterval},
+		{"queries.file", "Named queries file", stringValue{&c.Queries.File}},
+		{"storage.backend", "Store of saved queries and dashboards: bolt or memory", stringValue{&c.Storage.Backend}},
+		{"storage.path", "BoltDB file of the bolt storage backend", stringValue{&c.Storage.Path}},
+		{"alerting.evaluation-interval", "How often alert rules are evaluated", &c.Alerting.EvaluationInterval},
+		{"alerting.webhook-urls", "Webhooks notified of firing and resolved alerts as url,...", &c.Alerting.WebhookURLs},
+		{"alerting.webhook-retries", "How often a webhook or Alertmanager failing with a server or connection error is retried", intValue{&c.Alerting.WebhookRetries}},
+		{"alerting.webhook-timeout", "Timeout of a single webhook or Alertmanager request", &c.Alerting.WebhookTimeout},
+		{"alerting.alertmanager-urls", "Alertmanagers posted firing and resolved alerts as url,..., e.g. http://localhost:9093", &c.Alerting.AlertmanagerURLs},
+		{"alerting.resend-interval", "How often Alertmanagers are reminded of firing alerts", &c.Alerting.ResendInterval},
+		{"alerting.external-url", "URL of this server linked from Alertmanager alerts", stringValue{&c.Alerting.ExternalURL}},
+		{"tracing.exporter", "Trace exporter: none, otlp or stdout", stringValue{&c.Tracing.Exporter}},
+		{"tracing.endpoint", "OTLP/HTTP collector URL of the otlp exporter", stringValue{&c.Tracing.Endpoint}},
+		{"tracing.sample-ratio", "Fraction of new traces sampled, 0 to 1", floatValue{&c.Tracing.SampleRatio}},
+		{"auth.api-keys-file", "API keys file; enables authentication", stringValue{&c.Auth.APIKeysFile}},
+		{"auth.jwks-file", "JWKS file verifying bearer tokens; enables authentication", stringValue{&c.Auth.JWKSFile}},
+		{"auth.jwt-issuer", "Required issuer of bearer tokens, if any", stringValue{&c.Auth.JWTIssuer}},
+		{"auth.jwt-audience", "Required audience of bearer tokens, if any", stringValue{&c.Auth.JWTAudience}},
+		{"auth.policy-file", "Bridges, ports and tenants each principal may see; unrestricted when empty", stringValue{&c.Auth.PolicyFile}},
+		{"auth.tenant-label", "Prometheus label holding the tenant of a series; policy tenants are refused when empty", stringValue{&c.Auth.TenantLabel}},
+	}
+}
+
+// Load builds the configuration from command-line arguments, environment
+// variables and the configuration file, in that order of precedence. An
+// environment variable set to an empty value overrides the file too, e.g.
+// HELIOS_GRPC_LISTEN_ADDRESS= disables gRPC. lookupEnv is usually
+// os.LookupEnv. Usage is written to output on -h.
+func Load(args []string, lookupEnv func(string) (string, bool), output io.Writer) (*Config, error) {
+	defaults := Default()
+
+	fs := flag.NewFlagSet("prom-ovs-apiserver", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.String(FlagConfigFile, "", fmt.Sprintf("YAML configuration file (env %s)", EnvName(FlagConfigFile)))
+	for _, s := range defaults.settings() {
+		fs.String(s.name, s.value.String(), fmt.Sprintf("%s (env %s)", s.usage, s.env()))
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(output, "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(output, "\nPrecedence: flags, then %s* environment variables, then -%s, then defaults.\n", EnvPrefix, FlagConfigFile)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	file, _ := lookupEnv(EnvName(FlagConfigFile))
+	if v, ok := set[FlagConfigFile]; ok {
+		file = v
+	}
+
+	cfg := defaults
+	if file != "" {
+		var err error
+		if cfg, err = LoadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []string
+	for _, s := range cfg.settings() {
+		if v, ok := lookupEnv(s.env()); ok {
+			if err := s.value.Set(v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", s.env(), err))
+			}
+		}
+		if v, ok := set[s.name]; ok {
+			if err := s.value.Set(v); err != nil {
+				errs = append(errs, fmt.Sprintf("-%s: %v", s.name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, configError(errs)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile reads a YAML configuration file on top of the defaults.
+func LoadFile(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	cfg.File = path
+	return cfg, nil
+}
+
+// Validate checks every setting and reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, a ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, a...))
+		}
+	}
+
+	check(c.Prometheus.Host != "", "prometheus.host: must not be empty")
+	port, err := strconv.Atoi(c.Prometheus.Port)
+	check(err == nil && port > 0 && port < 65536, "prometheus.port: %q is not a port number", c.Prometheus.Port)
+	check(c.Prometheus.Version != "", "prometheus.version: must not be empty")
+	check(c.Prometheus.Timeout > 0, "prometheus.timeout: must be positive")
+	check(c.Prometheus.Retries >= 0, "prometheus.retries: must not be negative")
+	names := make(map[string]bool)
+	for i, up := range c.Prometheus.Upstreams {
+		check(upstreamNamePattern.MatchString(up.Name), "prometheus.upstreams[%d]: name %q must match %s", i, up.Name, upstreamNamePattern)
+		check(!names[up.Name], "prometheus.upstreams[%d]: name %q is used more than once", i, up.Name)
+		names[up.Name] = true
+		check(up.Host != "", "prometheus.upstreams[%d]: host must not be empty", i)
+		port, err := strconv.Atoi(up.Port)
+		check(err == nil && port > 0 && port < 65536, "prometheus.upstreams[%d]: %q is not a port number", i, up.Port)
+	}
+
+	_, _, err = net.SplitHostPort(c.Web.ListenAddress)
+	check(err == nil, "web.listen-address: %q is not host:port", c.Web.ListenAddress)
+	check(c.Web.ReadTimeout > 0, "web.read-timeout: must be positive")
+	check(c.Web.WriteTimeout > c.Prometheus.Timeout, "web.write-timeout: must be longer than prometheus.timeout")
+	check(c.Web.IdleTimeout > 0, "web.idle-timeout: must be positive")
+	check(c.Web.ShutdownTimeout > 0, "web.shutdown-timeout: must be positive")
+	if c.GRPC.ListenAddress != "" {
+		_, _, err = net.SplitHostPort(c.GRPC.ListenAddress)
+		check(err == nil, "grpc.listen-address: %q is not host:port", c.GRPC.ListenAddress)
+		check(c.GRPC.ListenAddress != c.Web.ListenAddress, "grpc.listen-address: must differ from web.listen-address")
+	}
+
+	check(c.Prometheus.ScrapeInterval > 0, "prometheus.scrape-interval: must be positive")
+	check(c.Prometheus.RecordingRulesRefresh >= 0, "prometheus.recording-rules-refresh: must not be negative")
+
+	check(c.Limits.MaxRank > 0, "limits.max-rank: must be positive")
+	check(c.Limits.MaxDuration >= 4*c.Prometheus.ScrapeInterval,
+		"limits.max-duration: must be at least 4 times prometheus.scrape-interval")
+	check(c.Limits.Rate >= 0, "limits.rate: must not be negative")
+	check(c.Limits.Rate == 0 || c.Limits.Burst > 0, "limits.burst: must be positive with limits.rate")
+	for route, limit := range c.Limits.Routes {
+		check(strings.HasPrefix(route, "/"), "limits.routes: %q is not a route template or gRPC method", route)
+		check(limit.Rate >= 0, "limits.routes[%s]: rate must not be negative", route)
+		check(limit.Rate == 0 || limit.Burst > 0, "limits.routes[%s]: burst must be positive with a rate", route)
+	}
+	check(c.Limits.MaxConcurrentQueries >= 0, "limits.max-concurrent-queries: must not be negative")
+	check(c.Limits.QueueTimeout >= 0 && c.Limits.QueueTimeout < c.Prometheus.Timeout,
+		"limits.queue-timeout: must be shorter than prometheus.timeout")
+
+	switch c.Log.Level {
+	case LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
+	default:
+		check(false, "log.level: %q is not one of debug, info, warn, error", c.Log.Level)
+	}
+	switch c.Log.Format {
+	case LogFormatText, LogFormatJSON:
+	default:
+		check(false, "log.format: %q is not one of text, json", c.Log.Format)
+	}
+
+	check(c.Enrich.RefreshInterval > 0, "enrich.refresh-interval: must be positive")
+	check(c.Enrich.OVNNBAddress == "" || c.Enrich.OVSDBAddress != "",
+		"enrich.ovn-nb-address: needs enrich.ovsdb-address to resolve iface-ids")
+
+	switch c.Storage.Backend {
+	case StorageBackendBolt:
+		check(c.Storage.Path != "", "storage.path: must not be empty with the bolt backend")
+	case StorageBackendMemory:
+	default:
+		check(false, "storage.backend: %q is not one of bolt, memory", c.Storage.Backend)
+	}
+
+	check(c.Alerting.EvaluationInterval > 0, "alerting.evaluation-interval: must be positive")
+	isURL := func(raw string) bool {
+		u, err := url.Parse(raw)
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	}
+	for i, raw := range c.Alerting.WebhookURLs {
+		check(isURL(raw), "alerting.webhook-urls[%d]: %q is not an http or https URL", i, raw)
+	}
+	for i, raw := range c.Alerting.AlertmanagerURLs {
+		check(isURL(raw), "alerting.alertmanager-urls[%d]: %q is not an http or https URL", i, raw)
+	}
+	check(c.Alerting.ResendInterval > 0, "alerting.resend-interval: must be positive")
+	check(c.Alerting.ExternalURL == "" || isURL(c.Alerting.ExternalURL), "alerting.external-url: %q is not an http or https URL", c.Alerting.ExternalURL)
+	check(c.Alerting.WebhookRetries >= 0, "alerting.webhook-retries: must not be negative")
+	check(c.Alerting.WebhookTimeout > 0, "alerting.webhook-timeout: must be positive")
+
+	switch c.Tracing.Exporter {
+	case TracingExporterNone, TracingExporterOTLP, TracingExporterStdout:
+	default:
+		check(false, "tracing.exporter: %q is not one of none, otlp, stdout", c.Tracing.Exporter)
+	}
+	check(c.Tracing.Exporter != TracingExporterOTLP || c.Tracing.Endpoint != "", "tracing.endpoint: must not be empty with the otlp exporter")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample-ratio: must be between 0 and 1")
+
+	check(c.Auth.JWKSFile != "" || (c.Auth.JWTIssuer == "" && c.Auth.JWTAudience == ""), "auth.jwt-issuer, auth.jwt-audience: need auth.jwks-file")
+	check(c.Auth.PolicyFile == "" || c.Auth.Enabled(), "auth.policy-file: needs auth.api-keys-file or auth.jwks-file")
+	check(c.Auth.TenantLabel == "" || labelNamePattern.MatchString(c.Auth.TenantLabel), "auth.tenant-label: %q is not a label name", c.Auth.TenantLabel)
+
+	if len(errs) > 0 {
+		return configError(errs)
+	}
+	return nil
+}
+
+func configError(errs []string) error {
+	return errors.New("invalid configuration:\n  " + strings.Join(errs, "\n  "))
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lookupEnv looks names up in env like os.LookupEnv.
+func lookupEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	content := "prometheus:\n  host: file-host\n  port: \"9091\"\n  timeout: 1m\nweb:\n  listen_address: \":9000\"\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"HELIOS_CONFIG_FILE":           file,
+		"HELIOS_PROMETHEUS_HOST":       "env-host",
+		"HELIOS_PROMETHEUS_PORT":       "9092",
+		"HELIOS_ALERTING_WEBHOOK_URLS": "http://hooks.example.com/a, http://hooks.example.com/b",
+	}
+	cfg, err := Load([]string{"-prometheus.host=flag-host"}, lookupEnv(env), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Prometheus.Host != "flag-host" {
+		t.Errorf("flag should win, got %q", cfg.Prometheus.Host)
+	}
+	if cfg.Prometheus.Port != "9092" {
+		t.Errorf("env should win over file, got %q", cfg.Prometheus.Port)
+	}
+	if cfg.Prometheus.Timeout != Duration(time.Minute) || cfg.Web.ListenAddress != ":9000" {
+		t.Errorf("file should win over defaults, got %v %q", cfg.Prometheus.Timeout, cfg.Web.ListenAddress)
+	}
+	if cfg.Limits.MaxRank != Default().Limits.MaxRank {
+		t.Errorf("default expected, got %d", cfg.Limits.MaxRank)
+	}
+	if urls := cfg.Alerting.WebhookURLs; len(urls) != 2 || urls[1] != "http://hooks.example.com/b" {
+		t.Errorf("expected two webhooks, got %v", urls)
+	}
+	if cfg.File != file {
+		t.Errorf("unexpected file %q", cfg.File)
+	}
+}
+
+func TestLoadEmptyEnv(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("grpc:\n  listen_address: \":9001\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{"HELIOS_GRPC_LISTEN_ADDRESS": ""}
+	cfg, err := Load([]string{"-config.file=" + file}, lookupEnv(env), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GRPC.ListenAddress != "" {
+		t.Errorf("expected an empty variable to disable gRPC, got %q", cfg.GRPC.ListenAddress)
+	}
+
+	// Unset variables leave the file alone
+	if cfg, err = Load([]string{"-config.file=" + file}, lookupEnv(nil), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GRPC.ListenAddress != ":9001" {
+		t.Errorf("expected the file address, got %q", cfg.GRPC.ListenAddress)
+	}
+
+	// An empty value is still validated
+	env = map[string]string{"HELIOS_PROMETHEUS_HOST": ""}
+	if _, err := Load(nil, lookupEnv(env), io.Discard); err == nil || !strings.Contains(err.Error(), "prometheus.host") {
+		t.Errorf("expected an empty host to be refused, got %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	noenv := lookupEnv(nil)
+
+	for _, args := range [][]string{
+		{"-prometheus.port=http"},
+		{"-prometheus.timeout=10"},
+		{"-prometheus.recording-rules-refresh=-1m"},
+		{"-web.listen-address=8081"},
+		{"-web.write-timeout=5s"},
+		{"-limits.max-rank=ten"},
+		{"-limits.rate=10", "-limits.burst=0"},
+		{"-limits.queue-timeout=1m"},
+		{"-log.level=trace"},
+		{"-tracing.exporter=jaeger"},
+		{"-storage.backend=sqlite"},
+		{"-storage.path="},
+		{"-alerting.evaluation-interval=0s"},
+		{"-alerting.webhook-urls=hooks.example.com/alerts"},
+		{"-alerting.webhook-retries=-1"},
+		{"-alerting.alertmanager-urls=localhost:9093"},
+		{"-alerting.external-url=/helios"},
+		{"-tracing.sample-ratio=2"},
+		{"-auth.policy-file=policy.yaml"},
+		{"-auth.tenant-label=tenant-id"},
+		{"-enrich.ovn-nb-address=tcp:127.0.0.1:6641"},
+		{"-config.file=/nonexistent/config.yaml"},
+		{"-unknown.flag=1"},
+	} {
+		if _, err := Load(args, noenv, io.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	content := "limits:\n  routes:\n    api/v1/groupby:\n      rate: 1\n      burst: 2\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load([]string{"-config.file=" + file}, noenv, io.Discard); err == nil || !strings.Contains(err.Error(), "limits.routes") {
+		t.Errorf("expected a route error, got %v", err)
+	}
+
+	// All problems are reported at once.
+	_, err := Load([]string{"-prometheus.host=", "-log.format=xml"}, noenv, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "prometheus.host") || !strings.Contains(err.Error(), "log.format") {
+		t.Errorf("expected both errors, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package enrich
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+)
+
+// Aggregate sums the latest sample of each series by the value of label, e.g.
+// port rates up to logical switch level. Series without the label are left
+// out. The result is sorted by value, highest first.
+func Aggregate(metrics []ovs_prom_client.TSMetricObj, label string) []ovs_prom_client.TSMetricObj {
+	sums := make(map[string]float64)
+	times := make(map[string]string)
+	latest := make(map[string]float64)
+
+	for _, metric := range metrics {
+		group, ok := metric.Labels[label]
+		if !ok || len(metric.Vals) == 0 {
+			continue
+		}
+		last := len(metric.Vals) - 1
+		val, err := strconv.ParseFloat(metric.Vals[last], 64)
+		if err != nil {
+			continue
+		}
+		sums[group] += val
+		if last >= len(metric.TimeSeries) {
+			continue
+		}
+		// Timestamps are compared as numbers: "999" is before "1000".
+		ts, err := strconv.ParseFloat(metric.TimeSeries[last], 64)
+		if err != nil {
+			continue
+		}
+		if prev, ok := latest[group]; !ok || ts > prev {
+			latest[group] = ts
+			times[group] = metric.TimeSeries[last]
+		}
+	}
+
+	groups := make([]string, 0, len(sums))
+	for group := range sums {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if sums[groups[i]] != sums[groups[j]] {
+			return sums[groups[i]] > sums[groups[j]]
+		}
+		return groups[i] < groups[j]
+	})
+
+	result := make([]ovs_prom_client.TSMetricObj, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, ovs_prom_client.TSMetricObj{
+			Label:      fmt.Sprintf("{%s=%q}", label, group),
+			Labels:     map[string]string{label: group},
+			Vals:       []string{strconv.FormatFloat(sums[group], 'f', -1, 64)},
+			TimeSeries: []string{times[group]},
+		})
+	}
+	return result
+}
+
+// TopK returns the first k metrics, or all of them when k is not positive.
+func TopK(metrics []ovs_prom_client.TSMetricObj, k int) []ovs_prom_client.TSMetricObj {
+	if k <= 0 || k >= len(metrics) {
+		return metrics
+	}
+	return metrics[:k]
+}
+
+// Filter returns the metrics whose labels have every value of matchers,
+// enriched labels included.
+func Filter(metrics []ovs_prom_client.TSMetricObj, matchers map[string]string) []ovs_prom_client.TSMetricObj {
+	if len(matchers) == 0 {
+		return metrics
+	}
+
+	result := make([]ovs_prom_client.TSMetricObj, 0, len(metrics))
+	for _, metric := range metrics {
+		ok := true
+		for name, value := range matchers {
+			if metric.Labels[name] != value {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			result = append(result, metric)
+		}
+	}
+	return result
+}
+
+// SortByValue sorts metrics by their latest sample, highest first. Samples
+// that are not numbers sort last.
+func SortByValue(metrics []ovs_prom_client.TSMetricObj) []ovs_prom_client.TSMetricObj {
+	latest := func(metric ovs_prom_client.TSMetricObj) float64 {
+		if len(metric.Vals) == 0 {
+			return math.Inf(-1)
+		}
+		val, err := strconv.ParseFloat(metric.Vals[len(metric.Vals)-1], 64)
+		if err != nil || math.IsNaN(val) {
+			return math.Inf(-1)
+		}
+		return val
+	}
+
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return latest(metrics[i]) > latest(metrics[j])
+	})
+	return metrics
+}
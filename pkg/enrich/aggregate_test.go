@@ -0,0 +1,33 @@
+package enrich
+
+import (
+	"testing"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+)
+
+func TestAggregate(t *testing.T) {
+	metrics := []ovs_prom_client.TSMetricObj{
+		{Labels: map[string]string{LabelTenant: "acme"}, Vals: []string{"1", "10"}, TimeSeries: []string{"990", "1000"}},
+		{Labels: map[string]string{LabelTenant: "acme"}, Vals: []string{"5"}, TimeSeries: []string{"999.5"}},
+		{Labels: map[string]string{LabelTenant: "globex"}, Vals: []string{"20"}, TimeSeries: []string{"1000"}},
+		{Labels: map[string]string{LabelTenant: "globex"}, Vals: []string{"x"}, TimeSeries: []string{"1100"}},
+		{Labels: map[string]string{"port": "none"}, Vals: []string{"100"}, TimeSeries: []string{"1000"}},
+	}
+
+	groups := Aggregate(metrics, LabelTenant)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 tenants, got %v", groups)
+	}
+	acme := groups[1]
+	if acme.Label != `{tenant="acme"}` || acme.Labels[LabelTenant] != "acme" || acme.Vals[0] != "15" {
+		t.Errorf("unexpected sum %+v", acme)
+	}
+	// 1000 is later than 999.5 although it sorts first as a string
+	if acme.TimeSeries[0] != "1000" {
+		t.Errorf("expected the latest timestamp, got %s", acme.TimeSeries[0])
+	}
+	if groups[0].Labels[LabelTenant] != "globex" || groups[0].TimeSeries[0] != "1000" {
+		t.Errorf("expected samples that are not numbers to be left out, got %+v", groups[0])
+	}
+}
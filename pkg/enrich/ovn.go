@@ -0,0 +1,148 @@
+package enrich
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/ovsdb"
+)
+
+// Labels attached from the OVN Northbound database
+const (
+	LabelLogicalSwitch string = "logical_switch"
+	LabelLogicalPort   string = "logical_port"
+	LabelLogicalRouter string = "logical_router"
+)
+
+// DefaultOVNNBDatabase is the OVN Northbound database
+const DefaultOVNNBDatabase string = "OVN_Northbound"
+
+// OVNMapper labels ports with their OVN logical switch, logical switch port
+// and logical router. Ports are matched by the iface_id label, so it must run
+// after an OVSDBEnricher. Ports of a switch attached to several routers get
+// no logical router, as their traffic cannot be credited to one of them.
+type OVNMapper struct {
+	Addr     string
+	Database string
+
+	mu    sync.RWMutex
+	ports map[string]map[string]string
+}
+
+// NewOVNMapper returns a mapper reading the OVN Northbound DB at addr, e.g.
+// "tcp:127.0.0.1:6641" or "unix:/var/run/ovn/ovnnb_db.sock".
+func NewOVNMapper(addr string) *OVNMapper {
+	return &OVNMapper{
+		Addr:     addr,
+		Database: DefaultOVNNBDatabase,
+		ports:    map[string]map[string]string{},
+	}
+}
+
+// Refresh re-reads the logical topology.
+func (m *OVNMapper) Refresh() error {
+	c, err := ovsdb.Dial(m.Addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	switches, err := c.Select(m.Database, "Logical_Switch", "_uuid", "name", "ports")
+	if err != nil {
+		return err
+	}
+	switchPorts, err := c.Select(m.Database, "Logical_Switch_Port", "_uuid", "name", "type", "options")
+	if err != nil {
+		return err
+	}
+	routers, err := c.Select(m.Database, "Logical_Router", "name", "ports")
+	if err != nil {
+		return err
+	}
+	routerPorts, err := c.Select(m.Database, "Logical_Router_Port", "_uuid", "name")
+	if err != nil {
+		return err
+	}
+
+	// Logical_Router_Port name -> Logical_Router name
+	lrpUUIDs := make(map[string]string, len(routerPorts))
+	for _, lrp := range routerPorts {
+		lrpUUIDs[lrp.UUID()] = lrp.String("name")
+	}
+	lrpRouter := make(map[string]string)
+	for _, lr := range routers {
+		for _, uuid := range lr.UUIDs("ports") {
+			lrpRouter[lrpUUIDs[uuid]] = lr.String("name")
+		}
+	}
+
+	lsps := make(map[string]ovsdb.Row, len(switchPorts))
+	for _, lsp := range switchPorts {
+		lsps[lsp.UUID()] = lsp
+	}
+
+	ports := make(map[string]map[string]string)
+	for _, ls := range switches {
+		// A switch is attached to a router through a "router" type port
+		// whose options:router-port names the Logical_Router_Port.
+		lrs := make(map[string]bool)
+		for _, uuid := range ls.UUIDs("ports") {
+			lsp, ok := lsps[uuid]
+			if !ok || lsp.String("type") != "router" {
+				continue
+			}
+			if lr, ok := lrpRouter[lsp.Map("options")["router-port"]]; ok {
+				lrs[lr] = true
+			}
+		}
+
+		for _, uuid := range ls.UUIDs("ports") {
+			lsp, ok := lsps[uuid]
+			if !ok || lsp.String("type") != "" {
+				continue
+			}
+			labels := map[string]string{
+				LabelLogicalSwitch: ls.String("name"),
+				LabelLogicalPort:   lsp.String("name"),
+			}
+			if len(lrs) == 1 {
+				for lr := range lrs {
+					labels[LabelLogicalRouter] = lr
+				}
+			}
+			ports[lsp.String("name")] = labels
+		}
+	}
+
+	m.mu.Lock()
+	m.ports = ports
+	m.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the topology every interval until ctx is cancelled. Refresh
+// errors are passed to onError and the previous topology is kept.
+func (m *OVNMapper) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	refreshLoop(ctx, interval, m.Refresh, onError)
+}
+
+// LabelNames returns the labels Enrich may add.
+func (m *OVNMapper) LabelNames() []string {
+	return []string{LabelLogicalSwitch, LabelLogicalPort, LabelLogicalRouter}
+}
+
+// Enrich adds the logical topology labels of each port with an iface_id.
+func (m *OVNMapper) Enrich(metrics []ovs_prom_client.TSMetricObj) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := range metrics {
+		labels, ok := m.ports[metrics[i].Labels[LabelIfaceID]]
+		if !ok {
+			continue
+		}
+		addLabels(&metrics[i], labels)
+	}
+}
@@ -0,0 +1,137 @@
+package enrich
+
+import (
+	"testing"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/ovsdb/ovsdbtest"
+)
+
+func newFakeNB(t *testing.T) *ovsdbtest.Server {
+	srv, err := ovsdbtest.NewServer(map[string]ovsdbtest.Tables{
+		DefaultOVNNBDatabase: {
+			"Logical_Switch": {
+				{"_uuid": ovsdbtest.UUID("ls1"), "name": "ls-web", "ports": ovsdbtest.UUIDSet("lsp1", "lsp2", "lsp-r1")},
+				{"_uuid": ovsdbtest.UUID("ls2"), "name": "ls-db", "ports": ovsdbtest.UUIDSet("lsp3")},
+			},
+			"Logical_Switch_Port": {
+				{"_uuid": ovsdbtest.UUID("lsp1"), "name": "web-1", "type": "", "options": ovsdbtest.Map(nil)},
+				{"_uuid": ovsdbtest.UUID("lsp2"), "name": "web-2", "type": "", "options": ovsdbtest.Map(nil)},
+				{"_uuid": ovsdbtest.UUID("lsp3"), "name": "db-1", "type": "", "options": ovsdbtest.Map(nil)},
+				{"_uuid": ovsdbtest.UUID("lsp-r1"), "name": "web-to-lr", "type": "router",
+					"options": ovsdbtest.Map(map[string]string{"router-port": "lrp-web"})},
+			},
+			"Logical_Router": {
+				{"_uuid": ovsdbtest.UUID("lr1"), "name": "lr-edge", "ports": ovsdbtest.UUIDSet("lrp1")},
+			},
+			"Logical_Router_Port": {
+				{"_uuid": ovsdbtest.UUID("lrp1"), "name": "lrp-web"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestOVNMapper(t *testing.T) {
+	srv := newFakeNB(t)
+	defer srv.Close()
+
+	m := NewOVNMapper(srv.Addr)
+	if err := m.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := []ovs_prom_client.TSMetricObj{
+		{Labels: map[string]string{"port": "tap1", "iface_id": "web-1"}, Vals: []string{"100"}, TimeSeries: []string{"1600000000"}},
+		{Labels: map[string]string{"port": "tap2", "iface_id": "web-2"}, Vals: []string{"50.5"}, TimeSeries: []string{"1600000000"}},
+		{Labels: map[string]string{"port": "tap3", "iface_id": "db-1"}, Vals: []string{"200"}, TimeSeries: []string{"1600000000"}},
+		{Labels: map[string]string{"port": "tap4"}, Vals: []string{"1000"}, TimeSeries: []string{"1600000000"}},
+	}
+	m.Enrich(metrics)
+
+	if got := metrics[0].Labels; got[LabelLogicalSwitch] != "ls-web" || got[LabelLogicalPort] != "web-1" || got[LabelLogicalRouter] != "lr-edge" {
+		t.Errorf("unexpected labels %v", got)
+	}
+	if got := metrics[2].Labels; got[LabelLogicalSwitch] != "ls-db" || got[LabelLogicalRouter] != "" {
+		t.Errorf("unexpected labels %v", got)
+	}
+	if _, ok := metrics[3].Labels[LabelLogicalSwitch]; ok {
+		t.Errorf("port without iface_id mapped: %v", metrics[3].Labels)
+	}
+
+	switches := Aggregate(metrics, LabelLogicalSwitch)
+	if len(switches) != 2 {
+		t.Fatalf("expected 2 logical switches, got %v", switches)
+	}
+	if switches[0].Labels[LabelLogicalSwitch] != "ls-db" || switches[0].Vals[0] != "200" {
+		t.Errorf("unexpected first switch %v", switches[0])
+	}
+	if switches[1].Labels[LabelLogicalSwitch] != "ls-web" || switches[1].Vals[0] != "150.5" {
+		t.Errorf("unexpected second switch %v", switches[1])
+	}
+
+	routers := TopK(Aggregate(metrics, LabelLogicalRouter), 1)
+	if len(routers) != 1 || routers[0].Labels[LabelLogicalRouter] != "lr-edge" || routers[0].Vals[0] != "150.5" {
+		t.Errorf("unexpected routers %v", routers)
+	}
+}
+
+func TestOVNMapperSeveralRouters(t *testing.T) {
+	srv, err := ovsdbtest.NewServer(map[string]ovsdbtest.Tables{
+		DefaultOVNNBDatabase: {
+			"Logical_Switch": {
+				{"_uuid": ovsdbtest.UUID("ls1"), "name": "ls-transit", "ports": ovsdbtest.UUIDSet("lsp1", "lsp-r1", "lsp-r2")},
+				{"_uuid": ovsdbtest.UUID("ls2"), "name": "ls-west", "ports": ovsdbtest.UUIDSet("lsp2", "lsp-r3", "lsp-r4")},
+			},
+			"Logical_Switch_Port": {
+				{"_uuid": ovsdbtest.UUID("lsp1"), "name": "vm-1", "type": "", "options": ovsdbtest.Map(nil)},
+				{"_uuid": ovsdbtest.UUID("lsp-r1"), "name": "to-lr-west", "type": "router",
+					"options": ovsdbtest.Map(map[string]string{"router-port": "lrp-west"})},
+				{"_uuid": ovsdbtest.UUID("lsp-r2"), "name": "to-lr-east", "type": "router",
+					"options": ovsdbtest.Map(map[string]string{"router-port": "lrp-east"})},
+				{"_uuid": ovsdbtest.UUID("lsp2"), "name": "vm-2", "type": "", "options": ovsdbtest.Map(nil)},
+				{"_uuid": ovsdbtest.UUID("lsp-r3"), "name": "to-lr-west-a", "type": "router",
+					"options": ovsdbtest.Map(map[string]string{"router-port": "lrp-west-a"})},
+				{"_uuid": ovsdbtest.UUID("lsp-r4"), "name": "to-lr-west-b", "type": "router",
+					"options": ovsdbtest.Map(map[string]string{"router-port": "lrp-west-b"})},
+			},
+			"Logical_Router": {
+				{"_uuid": ovsdbtest.UUID("lr1"), "name": "lr-west", "ports": ovsdbtest.UUIDSet("lrp1", "lrp3", "lrp4")},
+				{"_uuid": ovsdbtest.UUID("lr2"), "name": "lr-east", "ports": ovsdbtest.UUIDSet("lrp2")},
+			},
+			"Logical_Router_Port": {
+				{"_uuid": ovsdbtest.UUID("lrp1"), "name": "lrp-west"},
+				{"_uuid": ovsdbtest.UUID("lrp2"), "name": "lrp-east"},
+				{"_uuid": ovsdbtest.UUID("lrp3"), "name": "lrp-west-a"},
+				{"_uuid": ovsdbtest.UUID("lrp4"), "name": "lrp-west-b"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	m := NewOVNMapper(srv.Addr)
+	if err := m.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	metrics := []ovs_prom_client.TSMetricObj{
+		{Labels: map[string]string{"port": "tap1", "iface_id": "vm-1"}, Vals: []string{"100"}, TimeSeries: []string{"1600000000"}},
+		{Labels: map[string]string{"port": "tap2", "iface_id": "vm-2"}, Vals: []string{"10"}, TimeSeries: []string{"1600000000"}},
+	}
+	m.Enrich(metrics)
+
+	// Neither router is credited with the transit switch
+	if lr, ok := metrics[0].Labels[LabelLogicalRouter]; ok || metrics[0].Labels[LabelLogicalSwitch] != "ls-transit" {
+		t.Errorf("expected no router on the transit switch, got %q in %v", lr, metrics[0].Labels)
+	}
+	// Two ports to the same router are one router
+	routers := Aggregate(metrics, LabelLogicalRouter)
+	if len(routers) != 1 || routers[0].Labels[LabelLogicalRouter] != "lr-west" || routers[0].Vals[0] != "10" {
+		t.Errorf("unexpected routers %v", routers)
+	}
+}
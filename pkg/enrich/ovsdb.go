@@ -0,0 +1,147 @@
+// Package enrich attaches workload labels to OVS query results so that ports
+// can be told apart by more than their tap device names.
+package enrich
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/ovsdb"
+)
+
+// LabelPort is the label holding the OVS port (interface) name
+const LabelPort string = "port"
+
+// Labels attached from Interface.external_ids
+const (
+	LabelIfaceID     string = "iface_id"
+	LabelVMUUID      string = "vm_uuid"
+	LabelAttachedMAC string = "attached_mac"
+)
+
+// DefaultOVSDBDatabase is the database of the local OVS configuration
+const DefaultOVSDBDatabase string = "Open_vSwitch"
+
+// DefaultRefreshInterval is how often enrichers re-read their source
+const DefaultRefreshInterval time.Duration = 30 * time.Second
+
+// externalIDLabels maps Interface.external_ids keys to label names
+var externalIDLabels = map[string]string{
+	"iface-id":     LabelIfaceID,
+	"vm-uuid":      LabelVMUUID,
+	"attached-mac": LabelAttachedMAC,
+}
+
+// OVSDBEnricher labels ports with the external_ids of their OVSDB Interface
+// row. The mapping is cached and refreshed by Run, so Enrich never talks to
+// OVSDB itself.
+type OVSDBEnricher struct {
+	Addr     string
+	Database string
+
+	mu    sync.RWMutex
+	ports map[string]map[string]string
+}
+
+// NewOVSDBEnricher returns an enricher reading the OVSDB server at addr, e.g.
+// "unix:/var/run/openvswitch/db.sock" or "tcp:127.0.0.1:6640".
+func NewOVSDBEnricher(addr string) *OVSDBEnricher {
+	return &OVSDBEnricher{
+		Addr:     addr,
+		Database: DefaultOVSDBDatabase,
+		ports:    map[string]map[string]string{},
+	}
+}
+
+// Refresh re-reads the Interface table.
+func (e *OVSDBEnricher) Refresh() error {
+	c, err := ovsdb.Dial(e.Addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	rows, err := c.Select(e.Database, "Interface", "name", "external_ids")
+	if err != nil {
+		return err
+	}
+
+	ports := make(map[string]map[string]string, len(rows))
+	for _, row := range rows {
+		labels := make(map[string]string)
+		for key, val := range row.Map("external_ids") {
+			if name, ok := externalIDLabels[key]; ok && val != "" {
+				labels[name] = val
+			}
+		}
+		if len(labels) > 0 {
+			ports[row.String("name")] = labels
+		}
+	}
+
+	e.mu.Lock()
+	e.ports = ports
+	e.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the mapping every interval until ctx is cancelled. Refresh
+// errors are passed to onError and the previous mapping is kept.
+func (e *OVSDBEnricher) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	refreshLoop(ctx, interval, e.Refresh, onError)
+}
+
+// LabelNames returns the labels Enrich may add.
+func (e *OVSDBEnricher) LabelNames() []string {
+	return []string{LabelIfaceID, LabelVMUUID, LabelAttachedMAC}
+}
+
+// Enrich adds the external_ids labels of each port. Existing labels are not
+// overwritten.
+func (e *OVSDBEnricher) Enrich(metrics []ovs_prom_client.TSMetricObj) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for i := range metrics {
+		labels, ok := e.ports[metrics[i].Labels[LabelPort]]
+		if !ok {
+			continue
+		}
+		addLabels(&metrics[i], labels)
+	}
+}
+
+// addLabels sets the labels missing from metric.
+func addLabels(metric *ovs_prom_client.TSMetricObj, labels map[string]string) {
+	if metric.Labels == nil {
+		metric.Labels = make(map[string]string, len(labels))
+	}
+	for name, val := range labels {
+		if _, ok := metric.Labels[name]; !ok {
+			metric.Labels[name] = val
+		}
+	}
+}
+
+// refreshLoop calls refresh every interval until ctx is cancelled.
+func refreshLoop(ctx context.Context, interval time.Duration, refresh func() error, onError func(error)) {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := refresh(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
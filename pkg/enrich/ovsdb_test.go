@@ -0,0 +1,75 @@
+package enrich
+
+import (
+	"testing"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/ovsdb/ovsdbtest"
+)
+
+func TestOVSDBEnricher(t *testing.T) {
+	srv, err := ovsdbtest.NewServer(map[string]ovsdbtest.Tables{
+		DefaultOVSDBDatabase: {
+			"Interface": {
+				{
+					"name": "tap3f2a",
+					"external_ids": ovsdbtest.Map(map[string]string{
+						"iface-id":     "5f0c1a6e",
+						"vm-uuid":      "0d1c9b4a",
+						"attached-mac": "fa:16:3e:00:00:01",
+						"other":        "ignored",
+					}),
+				},
+				{
+					"name":         "br-int",
+					"external_ids": ovsdbtest.Map(map[string]string{}),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	e := NewOVSDBEnricher(srv.Addr)
+	if err := e.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := []ovs_prom_client.TSMetricObj{
+		{Labels: map[string]string{"bridge": "br-int", "port": "tap3f2a", "vm_uuid": "kept"}},
+		{Labels: map[string]string{"bridge": "br-int", "port": "br-int"}},
+	}
+	e.Enrich(metrics)
+
+	want := map[string]string{
+		"bridge":       "br-int",
+		"port":         "tap3f2a",
+		"iface_id":     "5f0c1a6e",
+		"vm_uuid":      "kept",
+		"attached_mac": "fa:16:3e:00:00:01",
+	}
+	if len(metrics[0].Labels) != len(want) {
+		t.Fatalf("unexpected labels %v", metrics[0].Labels)
+	}
+	for name, val := range want {
+		if metrics[0].Labels[name] != val {
+			t.Errorf("label %s: expected %q, got %q", name, val, metrics[0].Labels[name])
+		}
+	}
+	if len(metrics[1].Labels) != 2 {
+		t.Errorf("unexpected labels %v", metrics[1].Labels)
+	}
+
+	// A failed refresh keeps the previous mapping.
+	srv.Close()
+	if err := e.Refresh(); err == nil {
+		t.Fatal("expected refresh error")
+	}
+	metrics = []ovs_prom_client.TSMetricObj{{Labels: map[string]string{"port": "tap3f2a"}}}
+	e.Enrich(metrics)
+	if metrics[0].Labels[LabelIfaceID] != "5f0c1a6e" {
+		t.Errorf("mapping lost after failed refresh: %v", metrics[0].Labels)
+	}
+}
@@ -0,0 +1,240 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/filewatch"
+	"gopkg.in/yaml.v2"
+)
+
+// LabelBridge is the label holding the OVS bridge name
+const LabelBridge string = "bridge"
+
+// Labels attached from a static mapping file
+const (
+	LabelTenant      string = "tenant"
+	LabelOwner       string = "owner"
+	LabelEnvironment string = "environment"
+	LabelVMName      string = "vm_name"
+)
+
+// StaticRule maps bridges and ports matching regular expressions to owner
+// labels. Empty expressions match anything.
+type StaticRule struct {
+	Bridge      string `yaml:"bridge"`
+	Port        string `yaml:"port"`
+	Tenant      string `yaml:"tenant"`
+	Owner       string `yaml:"owner"`
+	Environment string `yaml:"environment"`
+	VMName      string `yaml:"vm_name"`
+
+	bridge *regexp.Regexp
+	port   *regexp.Regexp
+	labels map[string]string
+}
+
+type staticFile struct {
+	Mappings []StaticRule `yaml:"mappings"`
+}
+
+// staticColumns are the CSV header names, in StaticRule field order
+var staticColumns = []string{LabelBridge, LabelPort, LabelTenant, LabelOwner, LabelEnvironment, LabelVMName}
+
+// compileMatcher anchors expr; an empty expr matches anything.
+func compileMatcher(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		expr = ".*"
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func (r *StaticRule) init() error {
+	var err error
+	if r.bridge, err = compileMatcher(r.Bridge); err != nil {
+		return fmt.Errorf("bridge %q: %v", r.Bridge, err)
+	}
+	if r.port, err = compileMatcher(r.Port); err != nil {
+		return fmt.Errorf("port %q: %v", r.Port, err)
+	}
+
+	r.labels = make(map[string]string)
+	for name, val := range map[string]string{
+		LabelTenant:      r.Tenant,
+		LabelOwner:       r.Owner,
+		LabelEnvironment: r.Environment,
+		LabelVMName:      r.VMName,
+	} {
+		if val != "" {
+			r.labels[name] = val
+		}
+	}
+	return nil
+}
+
+// ParseStaticYAML parses mapping rules of the form
+//
+//	mappings:
+//	  - bridge: br-int
+//	    port: tap.*
+//	    tenant: acme
+//	    owner: network-team
+//	    environment: prod
+func ParseStaticYAML(content []byte) ([]StaticRule, error) {
+	var f staticFile
+	if err := yaml.UnmarshalStrict(content, &f); err != nil {
+		return nil, err
+	}
+	return initStaticRules(f.Mappings)
+}
+
+// ParseStaticCSV parses mapping rules from CSV with a header row naming the
+// columns bridge, port, tenant, owner, environment and vm_name.
+func ParseStaticCSV(content []byte) ([]StaticRule, error) {
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	index := make(map[string]int)
+	for i, name := range records[0] {
+		name = strings.TrimSpace(name)
+		known := false
+		for _, col := range staticColumns {
+			if col == name {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		index[name] = i
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := index[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rules := make([]StaticRule, 0, len(records)-1)
+	for _, record := range records[1:] {
+		rules = append(rules, StaticRule{
+			Bridge:      field(record, LabelBridge),
+			Port:        field(record, LabelPort),
+			Tenant:      field(record, LabelTenant),
+			Owner:       field(record, LabelOwner),
+			Environment: field(record, LabelEnvironment),
+			VMName:      field(record, LabelVMName),
+		})
+	}
+	return initStaticRules(rules)
+}
+
+func initStaticRules(rules []StaticRule) ([]StaticRule, error) {
+	for i := range rules {
+		if err := rules[i].init(); err != nil {
+			return nil, fmt.Errorf("mapping %d: %v", i+1, err)
+		}
+	}
+	return rules, nil
+}
+
+// LoadStaticFile reads mapping rules from a .csv file, or from YAML otherwise.
+func LoadStaticFile(path string) ([]StaticRule, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []StaticRule
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		rules, err = ParseStaticCSV(content)
+	} else {
+		rules, err = ParseStaticYAML(content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// StaticEnricher labels ports from a mapping file for sites without OVSDB
+// access. The first matching rule wins.
+type StaticEnricher struct {
+	path string
+
+	mu    sync.RWMutex
+	rules []StaticRule
+}
+
+// NewStaticEnricher loads the mapping file at path.
+func NewStaticEnricher(path string) (*StaticEnricher, error) {
+	e := &StaticEnricher{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload re-reads the mapping file. On error the current rules are kept.
+func (e *StaticEnricher) Reload() error {
+	rules, err := LoadStaticFile(e.path)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the mapping file whenever it changes, until ctx is
+// cancelled. Reload errors are passed to onError.
+func (e *StaticEnricher) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	filewatch.Watch(ctx, e.path, interval, func() {
+		if err := e.Reload(); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+// LabelNames returns the labels Enrich may add.
+func (e *StaticEnricher) LabelNames() []string {
+	return []string{LabelTenant, LabelOwner, LabelEnvironment, LabelVMName}
+}
+
+// Enrich adds the labels of the first rule matching each bridge and port.
+func (e *StaticEnricher) Enrich(metrics []ovs_prom_client.TSMetricObj) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for i := range metrics {
+		bridge := metrics[i].Labels[LabelBridge]
+		port := metrics[i].Labels[LabelPort]
+		if bridge == "" && port == "" {
+			// Not a port series, e.g. a count
+			continue
+		}
+		for _, rule := range e.rules {
+			if rule.bridge.MatchString(bridge) && rule.port.MatchString(port) {
+				addLabels(&metrics[i], rule.labels)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package enrich
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+)
+
+const testStaticYAML = `
+mappings:
+  - bridge: br-int
+    port: tap3f.*
+    tenant: acme
+    owner: alice
+    environment: prod
+    vm_name: web-1
+  - port: tap.*
+    tenant: shared
+`
+
+const testStaticCSV = `bridge,port,tenant,environment
+br-int,tap3f.*,acme,prod
+,tap.*,shared,
+`
+
+func TestStaticEnricher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enrich")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{"map.yaml": testStaticYAML, "map.csv": testStaticCSV} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		e, err := NewStaticEnricher(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		metrics := []ovs_prom_client.TSMetricObj{
+			{Labels: map[string]string{"bridge": "br-int", "port": "tap3f2a"}, Vals: []string{"10"}},
+			{Labels: map[string]string{"bridge": "br-ex", "port": "tap99"}, Vals: []string{"5"}},
+			{Labels: map[string]string{"bridge": "br-ex", "port": "eth0"}, Vals: []string{"1"}},
+			{Labels: map[string]string{}, Vals: []string{"3"}},
+		}
+		e.Enrich(metrics)
+
+		if got := metrics[0].Labels; got[LabelTenant] != "acme" || got[LabelEnvironment] != "prod" {
+			t.Errorf("%s: unexpected labels %v", name, got)
+		}
+		if got := metrics[1].Labels; got[LabelTenant] != "shared" || got[LabelEnvironment] != "" {
+			t.Errorf("%s: unexpected labels %v", name, got)
+		}
+		if _, ok := metrics[2].Labels[LabelTenant]; ok {
+			t.Errorf("%s: unmatched port labelled %v", name, metrics[2].Labels)
+		}
+		if len(metrics[3].Labels) != 0 {
+			t.Errorf("%s: count labelled %v", name, metrics[3].Labels)
+		}
+
+		tenants := Aggregate(metrics, LabelTenant)
+		if len(tenants) != 2 || tenants[0].Labels[LabelTenant] != "acme" || tenants[1].Vals[0] != "5" {
+			t.Errorf("%s: unexpected tenants %v", name, tenants)
+		}
+
+		// A broken file keeps the previous rules.
+		if err := ioutil.WriteFile(path, []byte("mappings: [{port: '('}]"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Reload(); err == nil {
+			t.Errorf("%s: expected reload error", name)
+		}
+		metrics = []ovs_prom_client.TSMetricObj{{Labels: map[string]string{"bridge": "br-int", "port": "tap3f2a"}}}
+		e.Enrich(metrics)
+		if metrics[0].Labels[LabelTenant] != "acme" {
+			t.Errorf("%s: rules lost after failed reload", name)
+		}
+	}
+}
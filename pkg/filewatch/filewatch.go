@@ -0,0 +1,53 @@
+// Package filewatch notifies callers when a file on disk changes.
+//
+// It polls the file instead of relying on inotify, so it keeps working when
+// editors or config management tools replace the file by renaming over it.
+package filewatch
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// DefaultInterval is the polling interval used when none is given
+const DefaultInterval time.Duration = 5 * time.Second
+
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func stat(path string) fileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, modTime: fi.ModTime(), size: fi.Size()}
+}
+
+// Watch calls onChange every time path is created, removed or modified, until
+// ctx is cancelled. It blocks, so callers usually run it in a goroutine.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	last := stat(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cur := stat(path)
+			if cur != last {
+				last = cur
+				onChange()
+			}
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Typed API of prom-ovs-apiserver, served next to the HTTP API on
+// -grpc.listen-address. Regenerate with `make proto`.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: helios.proto
+
+package heliospb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	OVSMetrics_Count_FullMethodName           = "/helios.v1.OVSMetrics/Count"
+	OVSMetrics_TopK_FullMethodName            = "/helios.v1.OVSMetrics/TopK"
+	OVSMetrics_GroupByRange_FullMethodName    = "/helios.v1.OVSMetrics/GroupByRange"
+	OVSMetrics_ListSeries_FullMethodName      = "/helios.v1.OVSMetrics/ListSeries"
+	OVSMetrics_ListLabelValues_FullMethodName = "/helios.v1.OVSMetrics/ListLabelValues"
+)
+
+// OVSMetricsClient is the client API for OVSMetrics service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// OVSMetrics queries Open vSwitch interface metrics stored in Prometheus.
+type OVSMetricsClient interface {
+	// Count counts the bridge and port series of a metric.
+	Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*MetricsResponse, error)
+	// TopK ranks ports, or groups of ports, by bit rate.
+	TopK(ctx context.Context, in *TopKRequest, opts ...grpc.CallOption) (*MetricsResponse, error)
+	// GroupByRange streams the bit rate of every bridge and port over the last
+	// hour, one series per message.
+	GroupByRange(ctx context.Context, in *GroupByRangeRequest, opts ...grpc.CallOption) (OVSMetrics_GroupByRangeClient, error)
+	// ListSeries returns the label sets of the series matching a selector.
+	ListSeries(ctx context.Context, in *ListSeriesRequest, opts ...grpc.CallOption) (*ListSeriesResponse, error)
+	// ListLabelValues returns the values of a label, e.g. every bridge.
+	ListLabelValues(ctx context.Context, in *ListLabelValuesRequest, opts ...grpc.CallOption) (*ListLabelValuesResponse, error)
+}
+
+type oVSMetricsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOVSMetricsClient(cc grpc.ClientConnInterface) OVSMetricsClient {
+	return &oVSMetricsClient{cc}
+}
+
+func (c *oVSMetricsClient) Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*MetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MetricsResponse)
+	err := c.cc.Invoke(ctx, OVSMetrics_Count_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *oVSMetricsClient) TopK(ctx context.Context, in *TopKRequest, opts ...grpc.CallOption) (*MetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MetricsResponse)
+	err := c.cc.Invoke(ctx, OVSMetrics_TopK_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *oVSMetricsClient) GroupByRange(ctx context.Context, in *GroupByRangeRequest, opts ...grpc.CallOption) (OVSMetrics_GroupByRangeClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &OVSMetrics_ServiceDesc.Streams[0], OVSMetrics_GroupByRange_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &oVSMetricsGroupByRangeClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type OVSMetrics_GroupByRangeClient interface {
+	Recv() (*SeriesChunk, error)
+	grpc.ClientStream
+}
+
+type oVSMetricsGroupByRangeClient struct {
+	grpc.ClientStream
+}
+
+func (x *oVSMetricsGroupByRangeClient) Recv() (*SeriesChunk, error) {
+	m := new(SeriesChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *oVSMetricsClient) ListSeries(ctx context.Context, in *ListSeriesRequest, opts ...grpc.CallOption) (*ListSeriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSeriesResponse)
+	err := c.cc.Invoke(ctx, OVSMetrics_ListSeries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *oVSMetricsClient) ListLabelValues(ctx context.Context, in *ListLabelValuesRequest, opts ...grpc.CallOption) (*ListLabelValuesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListLabelValuesResponse)
+	err := c.cc.Invoke(ctx, OVSMetrics_ListLabelValues_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// OVSMetricsServer is the server API for OVSMetrics service.
+// All implementations must embed UnimplementedOVSMetricsServer
+// for forward compatibility
+//
+// OVSMetrics queries Open vSwitch interface metrics stored in Prometheus.
+type OVSMetricsServer interface {
+	// Count counts the bridge and port series of a metric.
+	Count(context.Context, *CountRequest) (*MetricsResponse, error)
+	// TopK ranks ports, or groups of ports, by bit rate.
+	TopK(context.Context, *TopKRequest) (*MetricsResponse, error)
+	// GroupByRange streams the bit rate of every bridge and port over the last
+	// hour, one series per message.
+	GroupByRange(*GroupByRangeRequest, OVSMetrics_GroupByRangeServer) error
+	// ListSeries returns the label sets of the series matching a selector.
+	ListSeries(context.Context, *ListSeriesRequest) (*ListSeriesResponse, error)
+	// ListLabelValues returns the values of a label, e.g. every bridge.
+	ListLabelValues(context.Context, *ListLabelValuesRequest) (*ListLabelValuesResponse, error)
+	mustEmbedUnimplementedOVSMetricsServer()
+}
+
+// UnimplementedOVSMetricsServer must be embedded to have forward compatible implementations.
+type UnimplementedOVSMetricsServer struct {
+}
+
+func (UnimplementedOVSMetricsServer) Count(context.Context, *CountRequest) (*MetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Count not implemented")
+}
+func (UnimplementedOVSMetricsServer) TopK(context.Context, *TopKRequest) (*MetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TopK not implemented")
+}
+func (UnimplementedOVSMetricsServer) GroupByRange(*GroupByRangeRequest, OVSMetrics_GroupByRangeServer) error {
+	return status.Errorf(codes.Unimplemented, "method GroupByRange not implemented")
+}
+func (UnimplementedOVSMetricsServer) ListSeries(context.Context, *ListSeriesRequest) (*ListSeriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSeries not implemented")
+}
+func (UnimplementedOVSMetricsServer) ListLabelValues(context.Context, *ListLabelValuesRequest) (*ListLabelValuesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLabelValues not implemented")
+}
+func (UnimplementedOVSMetricsServer) mustEmbedUnimplementedOVSMetricsServer() {}
+
+// UnsafeOVSMetricsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OVSMetricsServer will
+// result in compilation errors.
+type UnsafeOVSMetricsServer interface {
+	mustEmbedUnimplementedOVSMetricsServer()
+}
+
+func RegisterOVSMetricsServer(s grpc.ServiceRegistrar, srv OVSMetricsServer) {
+	s.RegisterService(&OVSMetrics_ServiceDesc, srv)
+}
+
+func _OVSMetrics_Count_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OVSMetricsServer).Count(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OVSMetrics_Count_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OVSMetricsServer).Count(ctx, req.(*CountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OVSMetrics_TopK_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TopKRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OVSMetricsServer).TopK(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OVSMetrics_TopK_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OVSMetricsServer).TopK(ctx, req.(*TopKRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OVSMetrics_GroupByRange_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GroupByRangeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OVSMetricsServer).GroupByRange(m, &oVSMetricsGroupByRangeServer{ServerStream: stream})
+}
+
+type OVSMetrics_GroupByRangeServer interface {
+	Send(*SeriesChunk) error
+	grpc.ServerStream
+}
+
+type oVSMetricsGroupByRangeServer struct {
+	grpc.ServerStream
+}
+
+func (x *oVSMetricsGroupByRangeServer) Send(m *SeriesChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _OVSMetrics_ListSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OVSMetricsServer).ListSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OVSMetrics_ListSeries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OVSMetricsServer).ListSeries(ctx, req.(*ListSeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OVSMetrics_ListLabelValues_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLabelValuesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OVSMetricsServer).ListLabelValues(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OVSMetrics_ListLabelValues_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OVSMetricsServer).ListLabelValues(ctx, req.(*ListLabelValuesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// OVSMetrics_ServiceDesc is the grpc.ServiceDesc for OVSMetrics service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var OVSMetrics_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "helios.v1.OVSMetrics",
+	HandlerType: (*OVSMetricsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Count",
+			Handler:    _OVSMetrics_Count_Handler,
+		},
+		{
+			MethodName: "TopK",
+			Handler:    _OVSMetrics_TopK_Handler,
+		},
+		{
+			MethodName: "ListSeries",
+			Handler:    _OVSMetrics_ListSeries_Handler,
+		},
+		{
+			MethodName: "ListLabelValues",
+			Handler:    _OVSMetrics_ListLabelValues_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GroupByRange",
+			Handler:       _OVSMetrics_GroupByRange_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "helios.proto",
+}
@@ -0,0 +1,243 @@
+// Package ovsdb is a minimal OVSDB management protocol (RFC 7047) client.
+//
+// It only implements what the API server needs to read tables: list_dbs,
+// select transactions and replies to the server's echo keepalives.
+package ovsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds a single request when none is configured
+const DefaultTimeout time.Duration = 10 * time.Second
+
+// Row is a table row in OVSDB wire format, keyed by column name
+type Row map[string]interface{}
+
+// Client is a connection to an OVSDB server
+type Client struct {
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+	id   uint64
+}
+
+type request struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	ID     interface{}   `json:"id"`
+}
+
+type response struct {
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  interface{}     `json:"error"`
+	ID     interface{}     `json:"id"`
+}
+
+// operationResult is the result of a single operation of a transaction
+type operationResult struct {
+	Rows    []Row  `json:"rows,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+// ParseAddress splits an OVS style address such as
+// "unix:/var/run/openvswitch/db.sock" or "tcp:127.0.0.1:6640" into a network
+// and an address for net.Dial.
+func ParseAddress(addr string) (string, string, error) {
+	i := strings.Index(addr, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("ovsdb: invalid address %q", addr)
+	}
+
+	network, address := addr[:i], addr[i+1:]
+	switch network {
+	case "unix", "tcp":
+		return network, address, nil
+	}
+	return "", "", fmt.Errorf("ovsdb: unsupported address %q, need unix: or tcp:", addr)
+}
+
+// Dial connects to the OVSDB server at addr.
+func Dial(addr string) (*Client, error) {
+	network, address, err := ParseAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout(network, address, DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client speaking OVSDB over conn.
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		Timeout: DefaultTimeout,
+		conn:    conn,
+		enc:     json.NewEncoder(conn),
+		dec:     json.NewDecoder(conn),
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// call sends a request and waits for its response, answering echo requests
+// from the server in the meantime.
+func (c *Client) call(method string, params []interface{}, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.id++
+	id := c.id
+
+	if c.Timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.Timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+
+	if err := c.enc.Encode(&request{Method: method, Params: params, ID: id}); err != nil {
+		return err
+	}
+
+	for {
+		var resp response
+		if err := c.dec.Decode(&resp); err != nil {
+			return err
+		}
+
+		if resp.Method == "echo" {
+			echo := response{Result: resp.Params, ID: resp.ID}
+			if err := c.enc.Encode(&echo); err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.Method != "" {
+			// Notifications such as "update" are not used.
+			continue
+		}
+
+		if respID, ok := resp.ID.(float64); !ok || uint64(respID) != id {
+			continue
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("ovsdb: %s: %v", method, resp.Error)
+		}
+		return json.Unmarshal(resp.Result, result)
+	}
+}
+
+// ListDbs returns the names of the databases of the server.
+func (c *Client) ListDbs() ([]string, error) {
+	var dbs []string
+	if err := c.call("list_dbs", []interface{}{}, &dbs); err != nil {
+		return nil, err
+	}
+	return dbs, nil
+}
+
+// Select returns the given columns of all rows of table in db. All columns
+// are returned when none are given.
+func (c *Client) Select(db string, table string, columns ...string) ([]Row, error) {
+	op := map[string]interface{}{
+		"op":    "select",
+		"table": table,
+		"where": []interface{}{},
+	}
+	if len(columns) > 0 {
+		op["columns"] = columns
+	}
+
+	var results []operationResult
+	if err := c.call("transact", []interface{}{db, op}, &results); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errors.New("ovsdb: empty transact result")
+	}
+	if results[0].Error != "" {
+		return nil, fmt.Errorf("ovsdb: select %s: %s: %s", table, results[0].Error, results[0].Details)
+	}
+	return results[0].Rows, nil
+}
+
+// String returns an atomic string column.
+func (r Row) String(column string) string {
+	s, _ := r[column].(string)
+	return s
+}
+
+// UUID returns the _uuid of the row.
+func (r Row) UUID() string {
+	return uuidOf(r["_uuid"])
+}
+
+// Map returns a map<string, string> column.
+func (r Row) Map(column string) map[string]string {
+	m := make(map[string]string)
+
+	pair, ok := r[column].([]interface{})
+	if !ok || len(pair) != 2 || pair[0] != "map" {
+		return m
+	}
+	entries, _ := pair[1].([]interface{})
+	for _, e := range entries {
+		kv, ok := e.([]interface{})
+		if !ok || len(kv) != 2 {
+			continue
+		}
+		k, _ := kv[0].(string)
+		v, _ := kv[1].(string)
+		m[k] = v
+	}
+	return m
+}
+
+// UUIDs returns a set<uuid> column, or a single uuid column as a set.
+func (r Row) UUIDs(column string) []string {
+	var uuids []string
+
+	pair, ok := r[column].([]interface{})
+	if !ok || len(pair) != 2 {
+		return uuids
+	}
+	if pair[0] == "uuid" {
+		return append(uuids, uuidOf(pair))
+	}
+	if pair[0] != "set" {
+		return uuids
+	}
+	elems, _ := pair[1].([]interface{})
+	for _, e := range elems {
+		if uuid := uuidOf(e); uuid != "" {
+			uuids = append(uuids, uuid)
+		}
+	}
+	return uuids
+}
+
+func uuidOf(v interface{}) string {
+	pair, ok := v.([]interface{})
+	if !ok || len(pair) != 2 || pair[0] != "uuid" {
+		return ""
+	}
+	s, _ := pair[1].(string)
+	return s
+}
@@ -0,0 +1,83 @@
+package ovsdb_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/ovsdb"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/ovsdb/ovsdbtest"
+)
+
+func TestSelect(t *testing.T) {
+	srv, err := ovsdbtest.NewServer(map[string]ovsdbtest.Tables{
+		"Open_vSwitch": {
+			"Interface": {
+				{
+					"_uuid":        ovsdbtest.UUID("1b6a6a3e-0000-0000-0000-000000000001"),
+					"name":         "tap3f2a",
+					"external_ids": ovsdbtest.Map(map[string]string{"iface-id": "port-1"}),
+					"ofport":       1,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	c, err := ovsdb.Dial(srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	dbs, err := c.ListDbs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dbs, []string{"Open_vSwitch"}) {
+		t.Fatalf("unexpected databases %v", dbs)
+	}
+
+	rows, err := c.Select("Open_vSwitch", "Interface", "_uuid", "name", "external_ids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(rows))
+	}
+	if got := rows[0].String("name"); got != "tap3f2a" {
+		t.Errorf("unexpected name %q", got)
+	}
+	if got := rows[0].UUID(); got != "1b6a6a3e-0000-0000-0000-000000000001" {
+		t.Errorf("unexpected uuid %q", got)
+	}
+	if got := rows[0].Map("external_ids"); !reflect.DeepEqual(got, map[string]string{"iface-id": "port-1"}) {
+		t.Errorf("unexpected external_ids %v", got)
+	}
+	if _, ok := rows[0]["ofport"]; ok {
+		t.Errorf("unselected column returned")
+	}
+
+	if _, err := c.Select("Open_vSwitch", "Bridge"); err == nil {
+		t.Errorf("expected error for unknown table")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	for addr, want := range map[string][2]string{
+		"unix:/var/run/openvswitch/db.sock": {"unix", "/var/run/openvswitch/db.sock"},
+		"tcp:127.0.0.1:6640":                {"tcp", "127.0.0.1:6640"},
+	} {
+		network, address, err := ovsdb.ParseAddress(addr)
+		if err != nil || network != want[0] || address != want[1] {
+			t.Errorf("%s: got %s %s %v", addr, network, address, err)
+		}
+	}
+	for _, addr := range []string{"/var/run/db.sock", "ssl:127.0.0.1:6640"} {
+		if _, _, err := ovsdb.ParseAddress(addr); err == nil {
+			t.Errorf("%s: expected error", addr)
+		}
+	}
+}
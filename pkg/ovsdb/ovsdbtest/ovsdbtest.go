@@ -0,0 +1,210 @@
+// Package ovsdbtest provides a small in-process OVSDB server for tests.
+//
+// It serves list_dbs, echo and select transactions over a unix socket from
+// tables given in OVSDB wire format.
+package ovsdbtest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Tables maps table names to rows in OVSDB wire format
+type Tables map[string][]map[string]interface{}
+
+// Server is a fake OVSDB server
+type Server struct {
+	// Addr is the OVS style address of the server, e.g. "unix:/tmp/x/db.sock"
+	Addr string
+
+	dir string
+	ln  net.Listener
+
+	mu  sync.Mutex
+	dbs map[string]Tables
+	wg  sync.WaitGroup
+}
+
+// NewServer starts a server with the given databases.
+func NewServer(dbs map[string]Tables) (*Server, error) {
+	dir, err := ioutil.TempDir("", "ovsdbtest")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "db.sock")
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	s := &Server{Addr: "unix:" + path, dir: dir, ln: ln, dbs: dbs}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// SetTable replaces the rows of a table.
+func (s *Server) SetTable(db string, table string, rows []map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dbs[db] == nil {
+		s.dbs[db] = Tables{}
+	}
+	s.dbs[db][table] = rows
+}
+
+// Close stops the server and removes its socket.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+	os.RemoveAll(s.dir)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+type message struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     interface{}       `json:"id"`
+}
+
+type selectOp struct {
+	Op      string   `json:"op"`
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+
+	// Ping the client first, as ovsdb-server does on idle connections.
+	if err := enc.Encode(map[string]interface{}{"method": "echo", "params": []string{}, "id": "echo"}); err != nil {
+		return
+	}
+
+	for {
+		var msg message
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		if msg.Method == "" {
+			// Reply to our echo
+			continue
+		}
+
+		result, errMsg := s.dispatch(msg)
+		resp := map[string]interface{}{"result": result, "error": nil, "id": msg.ID}
+		if errMsg != "" {
+			resp["result"] = nil
+			resp["error"] = errMsg
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(msg message) (interface{}, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg.Method {
+	case "echo":
+		return msg.Params, ""
+	case "list_dbs":
+		dbs := []string{}
+		for name := range s.dbs {
+			dbs = append(dbs, name)
+		}
+		return dbs, ""
+	case "transact":
+		if len(msg.Params) == 0 {
+			return nil, "syntax error"
+		}
+		var db string
+		if err := json.Unmarshal(msg.Params[0], &db); err != nil {
+			return nil, "syntax error"
+		}
+		tables, ok := s.dbs[db]
+		if !ok {
+			return nil, "unknown database"
+		}
+
+		var results []interface{}
+		for _, raw := range msg.Params[1:] {
+			var op selectOp
+			if err := json.Unmarshal(raw, &op); err != nil || op.Op != "select" {
+				results = append(results, map[string]string{"error": "not supported"})
+				continue
+			}
+			rows, ok := tables[op.Table]
+			if !ok {
+				results = append(results, map[string]string{"error": "unknown table", "details": op.Table})
+				continue
+			}
+			results = append(results, map[string]interface{}{"rows": project(rows, op.Columns)})
+		}
+		return results, ""
+	}
+	return nil, "unknown method"
+}
+
+func project(rows []map[string]interface{}, columns []string) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if len(columns) == 0 {
+			out = append(out, row)
+			continue
+		}
+		r := make(map[string]interface{}, len(columns))
+		for _, col := range columns {
+			if v, ok := row[col]; ok {
+				r[col] = v
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Map encodes m as an OVSDB map<string, string>.
+func Map(m map[string]string) []interface{} {
+	pairs := []interface{}{}
+	for k, v := range m {
+		pairs = append(pairs, []interface{}{k, v})
+	}
+	return []interface{}{"map", pairs}
+}
+
+// UUID encodes an OVSDB uuid.
+func UUID(uuid string) []interface{} {
+	return []interface{}{"uuid", uuid}
+}
+
+// UUIDSet encodes an OVSDB set<uuid>.
+func UUIDSet(uuids ...string) []interface{} {
+	elems := []interface{}{}
+	for _, u := range uuids {
+		elems = append(elems, UUID(u))
+	}
+	return []interface{}{"set", elems}
+}
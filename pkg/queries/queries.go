@@ -0,0 +1,372 @@
+// Package queries loads named, parameterized PromQL templates from a YAML
+// file so that operators can add questions about OVS without a Go change.
+//
+// A queries file looks like:
+//
+//	queries:
+//	  - name: port_errors
+//	    description: Receive error rate per bridge and port
+//	    type: instant
+//	    query: 'topk({{.rank}}, sum by (bridge, port)(rate(ovs_interface_receive_errors_total[{{.duration}}])))'
+//	    params:
+//	      - name: duration
+//	        type: duration
+//	        default: 5m
+//	        min: 1m
+//	        max: 1h
+//	      - name: rank
+//	        type: int
+//	        default: "10"
+//	        min: "1"
+//	        max: "100"
+package queries
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/kongseokhwan/Helios-prom-client/pkg/filewatch"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Query types
+const (
+	TypeInstant string = "instant"
+	TypeRange   string = "range"
+)
+
+// Parameter types
+const (
+	ParamString   string = "string"
+	ParamInt      string = "int"
+	ParamFloat    string = "float"
+	ParamDuration string = "duration"
+	ParamMetric   string = "metric"
+)
+
+// defaultStringPattern keeps string parameters from breaking out of the
+// PromQL expression they are rendered into.
+var defaultStringPattern = regexp.MustCompile(`^[a-zA-Z0-9_.:/-]*$`)
+
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+var queryNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Param is a typed parameter of a named query
+type Param struct {
+	Name        string   `yaml:"name" json:"name"`
+	Type        string   `yaml:"type" json:"type"`
+	Description string   `yaml:"description,omitempty" json:"description,omitempty"`
+	Default     string   `yaml:"default,omitempty" json:"default,omitempty"`
+	Min         string   `yaml:"min,omitempty" json:"min,omitempty"`
+	Max         string   `yaml:"max,omitempty" json:"max,omitempty"`
+	Enum        []string `yaml:"enum,omitempty" json:"enum,omitempty"`
+	Pattern     string   `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Query is a named PromQL template
+type Query struct {
+	Name        string  `yaml:"name" json:"name"`
+	Description string  `yaml:"description,omitempty" json:"description,omitempty"`
+	Type        string  `yaml:"type,omitempty" json:"type"`
+	Query       string  `yaml:"query" json:"query"`
+	Params      []Param `yaml:"params,omitempty" json:"params,omitempty"`
+
+	tmpl *template.Template
+}
+
+// File is the layout of a queries file
+type File struct {
+	Queries []*Query `yaml:"queries"`
+}
+
+// ParamError reports an invalid or missing parameter value
+type ParamError struct {
+	Param  string
+	Reason string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("parameter %q: %s", e.Param, e.Reason)
+}
+
+// Parse parses and validates the content of a queries file.
+func Parse(content []byte) (map[string]*Query, error) {
+	var f File
+	if err := yaml.UnmarshalStrict(content, &f); err != nil {
+		return nil, err
+	}
+
+	queries := make(map[string]*Query, len(f.Queries))
+	for _, q := range f.Queries {
+		if err := q.init(); err != nil {
+			return nil, err
+		}
+		if _, ok := queries[q.Name]; ok {
+			return nil, fmt.Errorf("query %q: defined more than once", q.Name)
+		}
+		queries[q.Name] = q
+	}
+	return queries, nil
+}
+
+// LoadFile reads and parses a queries file.
+func LoadFile(path string) (map[string]*Query, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	queries, err := Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return queries, nil
+}
+
+func (q *Query) init() error {
+	if !queryNamePattern.MatchString(q.Name) {
+		return fmt.Errorf("query %q: name must match %s", q.Name, queryNamePattern)
+	}
+	switch q.Type {
+	case "":
+		q.Type = TypeInstant
+	case TypeInstant, TypeRange:
+	default:
+		return fmt.Errorf("query %q: unknown type %q", q.Name, q.Type)
+	}
+
+	tmpl, err := template.New(q.Name).Option("missingkey=error").Parse(q.Query)
+	if err != nil {
+		return fmt.Errorf("query %q: %v", q.Name, err)
+	}
+	q.tmpl = tmpl
+
+	seen := make(map[string]bool)
+	for i := range q.Params {
+		p := &q.Params[i]
+		if seen[p.Name] {
+			return fmt.Errorf("query %q: parameter %q defined more than once", q.Name, p.Name)
+		}
+		seen[p.Name] = true
+		if err := p.init(); err != nil {
+			return fmt.Errorf("query %q: %v", q.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *Param) init() error {
+	switch p.Type {
+	case "":
+		p.Type = ParamString
+	case ParamString, ParamInt, ParamFloat, ParamDuration, ParamMetric:
+	default:
+		return fmt.Errorf("parameter %q: unknown type %q", p.Name, p.Type)
+	}
+
+	p.pattern = defaultStringPattern
+	if p.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("parameter %q: %v", p.Name, err)
+		}
+		p.pattern = re
+	}
+
+	for _, bound := range []string{p.Min, p.Max} {
+		if bound == "" {
+			continue
+		}
+		if _, err := p.number(bound); err != nil {
+			return fmt.Errorf("parameter %q: invalid bound %q: %v", p.Name, bound, err)
+		}
+	}
+	if p.Default != "" {
+		if _, err := p.validate(p.Default); err != nil {
+			return fmt.Errorf("invalid default: %v", err)
+		}
+	}
+	return nil
+}
+
+// number converts numeric and duration values to a comparable float.
+func (p *Param) number(value string) (float64, error) {
+	switch p.Type {
+	case ParamInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		return float64(i), err
+	case ParamFloat:
+		return strconv.ParseFloat(value, 64)
+	case ParamDuration:
+		d, err := model.ParseDuration(value)
+		return float64(d), err
+	}
+	return 0, fmt.Errorf("type %s has no bounds", p.Type)
+}
+
+// validate checks a single value and returns it in the form rendered into
+// the template.
+func (p *Param) validate(value string) (string, error) {
+	if len(p.Enum) > 0 {
+		found := false
+		for _, e := range p.Enum {
+			if e == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", &ParamError{Param: p.Name, Reason: fmt.Sprintf("must be one of %v", p.Enum)}
+		}
+	}
+
+	switch p.Type {
+	case ParamString:
+		if !p.pattern.MatchString(value) {
+			return "", &ParamError{Param: p.Name, Reason: fmt.Sprintf("must match %s", p.pattern)}
+		}
+		return value, nil
+	case ParamMetric:
+		if !metricNamePattern.MatchString(value) {
+			return "", &ParamError{Param: p.Name, Reason: "not a valid metric name"}
+		}
+		return value, nil
+	}
+
+	n, err := p.number(value)
+	if err != nil {
+		return "", &ParamError{Param: p.Name, Reason: fmt.Sprintf("not a valid %s", p.Type)}
+	}
+	if p.Min != "" {
+		if min, _ := p.number(p.Min); n < min {
+			return "", &ParamError{Param: p.Name, Reason: fmt.Sprintf("must be at least %s", p.Min)}
+		}
+	}
+	if p.Max != "" {
+		if max, _ := p.number(p.Max); n > max {
+			return "", &ParamError{Param: p.Name, Reason: fmt.Sprintf("must be at most %s", p.Max)}
+		}
+	}
+	if p.Type == ParamDuration {
+		// Render in canonical form, e.g. "90s" becomes "1m30s".
+		return model.Duration(n).String(), nil
+	}
+	return value, nil
+}
+
+// Render validates values against the query parameters, applies defaults and
+// returns the resulting PromQL expression. Parameters without a default are
+// required, and unknown values are rejected.
+func (q *Query) Render(values map[string]string) (string, error) {
+	known := make(map[string]bool, len(q.Params))
+	data := make(map[string]string, len(q.Params))
+
+	for i := range q.Params {
+		p := &q.Params[i]
+		known[p.Name] = true
+
+		value := values[p.Name]
+		if value == "" {
+			value = p.Default
+		}
+		if value == "" {
+			return "", &ParamError{Param: p.Name, Reason: "is required"}
+		}
+		v, err := p.validate(value)
+		if err != nil {
+			return "", err
+		}
+		data[p.Name] = v
+	}
+
+	for name := range values {
+		if !known[name] {
+			return "", &ParamError{Param: name, Reason: "unknown parameter"}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := q.tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Registry holds the queries of a file and reloads them when it changes
+type Registry struct {
+	path string
+
+	mu      sync.RWMutex
+	queries map[string]*Query
+}
+
+// NewRegistry loads the queries at path. A missing file yields an empty
+// registry, which is filled once the file is created.
+func NewRegistry(path string) (*Registry, error) {
+	r := &Registry{path: path, queries: map[string]*Query{}}
+	if err := r.Reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the queries file. On error the current queries are kept.
+func (r *Registry) Reload() error {
+	queries, err := LoadFile(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.mu.Lock()
+			r.queries = map[string]*Query{}
+			r.mu.Unlock()
+		}
+		return err
+	}
+
+	r.mu.Lock()
+	r.queries = queries
+	r.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the registry whenever the queries file changes, until ctx is
+// cancelled. Reload errors are passed to onError.
+func (r *Registry) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	filewatch.Watch(ctx, r.path, interval, func() {
+		if err := r.Reload(); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+// Get returns the named query.
+func (r *Registry) Get(name string) (*Query, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	q, ok := r.queries[name]
+	return q, ok
+}
+
+// List returns all queries sorted by name.
+func (r *Registry) List() []*Query {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*Query, 0, len(r.queries))
+	for _, q := range r.queries {
+		list = append(list, q)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
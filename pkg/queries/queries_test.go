@@ -0,0 +1,77 @@
+package queries
+
+import (
+	"testing"
+)
+
+const testQueries = `
+queries:
+  - name: topk_rate
+    query: 'topk({{.rank}}, rate({{.metric}}{bridge="{{.bridge}}"}[{{.duration}}]))'
+    params:
+      - name: metric
+        type: metric
+        default: ovs_interface_receive_bytes_total
+      - name: bridge
+        type: string
+      - name: duration
+        type: duration
+        default: 5m
+        min: 1m
+        max: 1h
+      - name: rank
+        type: int
+        default: "10"
+        min: "1"
+        max: "100"
+`
+
+func TestRender(t *testing.T) {
+	qs, err := Parse([]byte(testQueries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := qs["topk_rate"]
+	if q.Type != TypeInstant {
+		t.Fatalf("expected default type %q, got %q", TypeInstant, q.Type)
+	}
+
+	got, err := q.Render(map[string]string{"bridge": "br-int", "duration": "90s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `topk(10, rate(ovs_interface_receive_bytes_total{bridge="br-int"}[1m30s]))`
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	for _, values := range []map[string]string{
+		{},
+		{"bridge": `br0"}) or vector(1`},
+		{"bridge": "br0", "duration": "5m])or vector(1"},
+		{"bridge": "br0", "duration": "2h"},
+		{"bridge": "br0", "rank": "0"},
+		{"bridge": "br0", "metric": "rate(x)"},
+		{"bridge": "br0", "unknown": "1"},
+	} {
+		if _, err := q.Render(values); err == nil {
+			t.Errorf("expected error for %v", values)
+		} else if _, ok := err.(*ParamError); !ok {
+			t.Errorf("expected *ParamError for %v, got %T", values, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, content := range []string{
+		"queries:\n  - name: a b\n    query: up\n",
+		"queries:\n  - name: a\n    query: up\n  - name: a\n    query: up\n",
+		"queries:\n  - name: a\n    type: matrix\n    query: up\n",
+		"queries:\n  - name: a\n    query: up\n    params:\n      - name: d\n        type: duration\n        default: 5x\n",
+		"queries:\n  - name: a\n    query: up\n    unknown: field\n",
+	} {
+		if _, err := Parse([]byte(content)); err == nil {
+			t.Errorf("expected error for %q", content)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package rules generates a Prometheus rule file from the metric catalog of
+// the client: recording rules for the per-port bit rates the server ranks
+// and graphs and for the signals of the alert rules, plus standard alerting
+// rules over the recorded series. Load it into Prometheus next to the OVS
+// exporter scrape config:
+//
+//	rule_files:
+//	  - ovs-rules.yml
+//
+// An OVSClient with Recorded rules then reads the recorded series instead of
+// computing the rates on every request.
+package rules
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"gopkg.in/yaml.v2"
+)
+
+// Group names of the generated file
+const (
+	GroupRecording string = "ovs_port_recording_rules"
+	GroupAlerting  string = "ovs_port_alerting_rules"
+)
+
+// DefaultWindows are the rate() windows recorded without Options.Windows
+var DefaultWindows = []string{"1m", "5m"}
+
+// File is a Prometheus rule file
+type File struct {
+	Groups []Group `yaml:"groups"`
+}
+
+// Group is a rule group, evaluated every Interval or at the global
+// evaluation interval of Prometheus when empty
+type Group struct {
+	Name     string `yaml:"name"`
+	Interval string `yaml:"interval,omitempty"`
+	Rules    []Rule `yaml:"rules"`
+}
+
+// Rule is a recording rule with Record or an alerting rule with Alert
+type Rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Options shape the generated rules. The alerting rules read the signals
+// recorded over AlertWindow, which is recorded in any case, and fire after
+// For. A threshold that is not positive leaves its alert out.
+type Options struct {
+	Windows     []string
+	Interval    string
+	AlertWindow string
+	For         string
+	Severity    string
+	ErrorRatio  float64
+	DropRatio   float64
+	Utilization float64
+}
+
+// DefaultOptions returns the options of the standard rules: alerts on 1%
+// errors or drops and 90% utilization for 10 minutes.
+func DefaultOptions() Options {
+	return Options{
+		Windows:     DefaultWindows,
+		AlertWindow: "5m",
+		For:         "10m",
+		Severity:    "warning",
+		ErrorRatio:  0.01,
+		DropRatio:   0.01,
+		Utilization: 0.9,
+	}
+}
+
+// Validate checks the durations and thresholds of o.
+func (o Options) Validate() error {
+	durations := map[string]string{"interval": o.Interval, "alert window": o.AlertWindow, "for": o.For}
+	for i, w := range o.Windows {
+		durations[fmt.Sprintf("windows[%d]", i)] = w
+	}
+	for name, d := range durations {
+		if d == "" && name != "alert window" {
+			continue
+		}
+		if _, err := ovs_prom_client.ParseDuration(d); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	for name, v := range map[string]float64{"error ratio": o.ErrorRatio, "drop ratio": o.DropRatio, "utilization": o.Utilization} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%s: must be a finite number", name)
+		}
+	}
+	return nil
+}
+
+// Generate returns the rule file of o.
+func Generate(o Options) (*File, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	windows := o.Windows
+	if len(windows) == 0 {
+		windows = DefaultWindows
+	}
+	if !contains(windows, o.AlertWindow) {
+		windows = append(append([]string(nil), windows...), o.AlertWindow)
+	}
+
+	recording := Group{Name: GroupRecording, Interval: o.Interval}
+	for _, r := range ovs_prom_client.RecordingRules(windows) {
+		recording.Rules = append(recording.Rules, Rule{Record: r.Record, Expr: r.Expr})
+	}
+	alerting := Group{Name: GroupAlerting, Interval: o.Interval, Rules: o.alerts()}
+	return &File{Groups: []Group{recording, alerting}}, nil
+}
+
+// Marshal returns f in YAML.
+func (f *File) Marshal() ([]byte, error) {
+	return yaml.Marshal(f)
+}
+
+// alerts returns the standard alerting rules of o.
+func (o Options) alerts() []Rule {
+	var alerts []Rule
+	threshold := func(name string, signal string, value float64, summary string) {
+		if value <= 0 {
+			return
+		}
+		rule, _ := ovs_prom_client.SignalRule(signal, o.AlertWindow)
+		alerts = append(alerts, Rule{
+			Alert:  name,
+			Expr:   rule.Record + " > " + strconv.FormatFloat(value, 'g', -1, 64),
+			For:    o.For,
+			Labels: o.labels(),
+			Annotations: map[string]string{
+				"summary":     "{{ $labels.bridge }}/{{ $labels.port }} " + summary,
+				"description": fmt.Sprintf("%s of port {{ $labels.port }} on bridge {{ $labels.bridge }} is above %g over %s.", signal, value, o.AlertWindow),
+			},
+		})
+	}
+	threshold("OVSPortErrorRatioHigh", ovs_prom_client.SignalErrorRatio, o.ErrorRatio, "fails {{ $value | humanizePercentage }} of its packets")
+	threshold("OVSPortDropRatioHigh", ovs_prom_client.SignalDropRatio, o.DropRatio, "drops {{ $value | humanizePercentage }} of its packets")
+	threshold("OVSPortUtilizationHigh", ovs_prom_client.SignalUtilization, o.Utilization, "runs at {{ $value | humanizePercentage }} of its link speed")
+
+	metric := ovs_prom_client.Catalog[0].Name
+	alerts = append(alerts, Rule{
+		Alert:  "OVSInterfaceMetricsAbsent",
+		Expr:   "absent(" + metric + ")",
+		For:    o.For,
+		Labels: o.labels(),
+		Annotations: map[string]string{
+			"summary":     "No OVS interface metrics are scraped",
+			"description": metric + " is absent; the OVS exporters are down or not scraped.",
+		},
+	})
+	return alerts
+}
+
+func (o Options) labels() map[string]string {
+	if o.Severity == "" {
+		return nil
+	}
+	return map[string]string{"severity": o.Severity}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
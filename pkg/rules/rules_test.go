@@ -0,0 +1,99 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"gopkg.in/yaml.v2"
+)
+
+func TestGenerate(t *testing.T) {
+	o := DefaultOptions()
+	o.Windows = []string{"1m"}
+	o.DropRatio = 0
+	f, err := Generate(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Groups) != 2 || f.Groups[0].Name != GroupRecording || f.Groups[1].Name != GroupAlerting {
+		t.Fatalf("unexpected groups %+v", f.Groups)
+	}
+
+	// The alert window is recorded next to the requested ones.
+	records := make(map[string]string)
+	for _, r := range f.Groups[0].Rules {
+		records[r.Record] = r.Expr
+	}
+	if want := 2 * (len(ovs_prom_client.Catalog) + len(ovs_prom_client.Signals())); len(records) != want {
+		t.Errorf("expected %d recording rules, got %d", want, len(records))
+	}
+	rate := ovs_prom_client.RateRule("ovs_interface_receive_bytes_total", "1m")
+	if records[rate.Record] != "avg by(bridge, port) (rate(ovs_interface_receive_bytes_total[1m])*8)" {
+		t.Errorf("unexpected rate rule %q: %q", rate.Record, records[rate.Record])
+	}
+	errorRatio, _ := ovs_prom_client.SignalRule(ovs_prom_client.SignalErrorRatio, "5m")
+	if records[errorRatio.Record] != errorRatio.Expr {
+		t.Errorf("expected %s to be recorded", errorRatio.Record)
+	}
+
+	alerts := make(map[string]Rule)
+	for _, r := range f.Groups[1].Rules {
+		alerts[r.Alert] = r
+	}
+	if _, ok := alerts["OVSPortDropRatioHigh"]; ok {
+		t.Error("expected no drop alert without a threshold")
+	}
+	a, ok := alerts["OVSPortErrorRatioHigh"]
+	if !ok || a.Expr != errorRatio.Record+" > 0.01" || a.For != "10m" || a.Labels["severity"] != "warning" {
+		t.Errorf("unexpected error alert %+v", a)
+	}
+	for name, a := range alerts {
+		if name == "OVSInterfaceMetricsAbsent" {
+			continue
+		}
+		if record := strings.Fields(a.Expr)[0]; records[record] == "" {
+			t.Errorf("alert %s reads %s, which is not recorded", name, record)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	f, err := Generate(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := f.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Groups []struct {
+			Name  string
+			Rules []map[string]interface{}
+		}
+	}
+	if err := yaml.UnmarshalStrict(out, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Groups) != 2 || len(parsed.Groups[1].Rules) != 4 {
+		t.Fatalf("unexpected rule file:\n%s", out)
+	}
+	if _, ok := parsed.Groups[0].Rules[0]["alert"]; ok {
+		t.Errorf("expected no empty fields in recording rules:\n%s", out)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	for name, o := range map[string]func(*Options){
+		"window":       func(o *Options) { o.Windows = []string{"5m", "5x"} },
+		"alert window": func(o *Options) { o.AlertWindow = "" },
+		"for":          func(o *Options) { o.For = "-1m" },
+	} {
+		opts := DefaultOptions()
+		o(&opts)
+		if _, err := Generate(opts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package saved
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Resource is a document kept in a Collection, such as *Query
+type Resource[T any] interface {
+	*T
+	Metadata() *Meta
+	Validate() error
+}
+
+// Collection keeps the resources of one type in a bucket of a store as
+// JSON. Changes are serialized so that updates keep the owner and creation
+// time of the stored resource.
+type Collection[T any, P Resource[T]] struct {
+	store  Store
+	bucket string
+	mu     sync.Mutex
+	now    func() time.Time
+}
+
+// NewCollection returns the collection of bucket, e.g.
+// NewCollection[Query](store, BucketQueries).
+func NewCollection[T any, P Resource[T]](store Store, bucket string) *Collection[T, P] {
+	return &Collection[T, P]{store: store, bucket: bucket, now: time.Now}
+}
+
+// Get returns the resource with the ID.
+func (c *Collection[T, P]) Get(id string) (P, error) {
+	value, err := c.store.Get(c.bucket, id)
+	if err != nil {
+		return nil, err
+	}
+	var v P = new(T)
+	if err := json.Unmarshal(value, v); err != nil {
+		return nil, fmt.Errorf("%s %s: %v", c.bucket, id, err)
+	}
+	return v, nil
+}
+
+// List returns the resources sorted by name.
+func (c *Collection[T, P]) List() ([]P, error) {
+	values, err := c.store.List(c.bucket)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]P, 0, len(values))
+	for _, value := range values {
+		var v P = new(T)
+		if err := json.Unmarshal(value, v); err != nil {
+			return nil, fmt.Errorf("%s: %v", c.bucket, err)
+		}
+		items = append(items, v)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Metadata().Name < items[j].Metadata().Name
+	})
+	return items, nil
+}
+
+// Create validates and stores v with a new ID.
+func (c *Collection[T, P]) Create(v P) error {
+	if err := v.Validate(); err != nil {
+		return err
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := v.Metadata()
+	m.ID = id
+	m.CreatedAt = c.now().UTC()
+	m.UpdatedAt = m.CreatedAt
+	return c.put(v)
+}
+
+// Update replaces the resource with the ID of v. A non-empty by must be the
+// owner of the stored resource, if it has one.
+func (c *Collection[T, P]) Update(v P, by string) error {
+	if err := v.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := v.Metadata()
+	prev, err := c.owned(m.ID, by)
+	if err != nil {
+		return err
+	}
+	m.Owner = prev.Metadata().Owner
+	m.CreatedAt = prev.Metadata().CreatedAt
+	m.UpdatedAt = c.now().UTC()
+	return c.put(v)
+}
+
+// Delete removes the resource with the ID. A non-empty by must be its
+// owner, if it has one.
+func (c *Collection[T, P]) Delete(id string, by string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.owned(id, by); err != nil {
+		return err
+	}
+	return c.store.Delete(c.bucket, id)
+}
+
+// owned returns the stored resource when by may change it.
+func (c *Collection[T, P]) owned(id string, by string) (P, error) {
+	prev, err := c.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if owner := prev.Metadata().Owner; by != "" && owner != "" && owner != by {
+		return nil, ErrNotOwner
+	}
+	return prev, nil
+}
+
+func (c *Collection[T, P]) put(v P) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.store.Put(c.bucket, v.Metadata().ID, value)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,312 @@
+// Package saved keeps the saved queries and dashboards of API users in a
+// pluggable Store, by default an embedded BoltDB file.
+//
+// A saved query looks like:
+//
+//	{
+//	  "name": "busiest tenant ports",
+//	  "spec": {"type": "topk", "metric": "ovs_interface_receive_bytes_total", "duration": "5m", "rank": 10},
+//	  "filters": {"tenant": "acme"}
+//	}
+//
+// and a dashboard holds a list of such specs as panels, with filters shared
+// by all of them.
+package saved
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	ovs_prom_client "github.com/kongseokhwan/Helios-prom-client/pkg/client"
+	"github.com/kongseokhwan/Helios-prom-client/pkg/enrich"
+)
+
+// Spec types, one per query route of the API
+const (
+	SpecCount     string = "count"
+	SpecTopK      string = "topk"
+	SpecGroupBy   string = "groupby"
+	SpecGroupTopK string = "group_topk"
+	SpecNamed     string = "named"
+)
+
+// Buckets of the store
+const (
+	BucketQueries    string = "queries"
+	BucketDashboards string = "dashboards"
+)
+
+// MaxPanels is the largest number of panels of a dashboard
+const MaxPanels int = 100
+
+// ErrNotFound is returned for an unknown ID
+var ErrNotFound = errors.New("not found")
+
+// ErrNotOwner is returned when a principal changes a resource of another
+var ErrNotOwner = errors.New("owned by another principal")
+
+// Groups are the labels a group_topk spec may rank by
+var Groups = []string{
+	enrich.LabelLogicalSwitch,
+	enrich.LabelLogicalRouter,
+	enrich.LabelTenant,
+	enrich.LabelOwner,
+	enrich.LabelEnvironment,
+}
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidationError reports an invalid field of a saved resource
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("field %q: %s", e.Field, e.Reason)
+}
+
+// QuerySpec is one query of the API: Metric, Duration and Rank as in the
+// paths of the count, topk and groupby routes, Group for group_topk, and
+// Query and Params for a named query.
+type QuerySpec struct {
+	Type     string            `json:"type"`
+	Metric   string            `json:"metric,omitempty"`
+	Duration string            `json:"duration,omitempty"`
+	Rank     int               `json:"rank,omitempty"`
+	Group    string            `json:"group,omitempty"`
+	Query    string            `json:"query,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+// Meta are the fields every saved resource has. ID and the times are set by
+// the Collection.
+type Meta struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Query is a saved query. Filters keep the series whose label has the value,
+// enriched labels included.
+type Query struct {
+	Meta
+	Spec    QuerySpec         `json:"spec"`
+	Filters map[string]string `json:"filters,omitempty"`
+}
+
+// Panel is one query of a dashboard
+type Panel struct {
+	Title   string            `json:"title"`
+	Spec    QuerySpec         `json:"spec"`
+	Filters map[string]string `json:"filters,omitempty"`
+}
+
+// Dashboard is a saved list of panels. Its Filters apply to every panel.
+type Dashboard struct {
+	Meta
+	Filters map[string]string `json:"filters,omitempty"`
+	Panels  []Panel           `json:"panels"`
+}
+
+// Validate checks the spec without the server limits.
+func (s *QuerySpec) Validate() error {
+	return s.validate("spec.")
+}
+
+func (s *QuerySpec) validate(prefix string) error {
+	invalid := func(field string, err error) error {
+		return &ValidationError{Field: prefix + field, Reason: err.Error()}
+	}
+	metric := func() error {
+		if err := ovs_prom_client.ValidateMetric(s.Metric); err != nil {
+			return invalid("metric", err)
+		}
+		return nil
+	}
+	duration := func() error {
+		if _, err := ovs_prom_client.ParseDuration(s.Duration); err != nil {
+			return invalid("duration", err)
+		}
+		return nil
+	}
+	rank := func() error {
+		if err := ovs_prom_client.ValidateRank(s.Rank, 0); err != nil {
+			return invalid("rank", err)
+		}
+		return nil
+	}
+
+	var checks []func() error
+	switch s.Type {
+	case SpecCount:
+		checks = []func() error{metric}
+	case SpecTopK:
+		checks = []func() error{metric, duration, rank}
+	case SpecGroupBy:
+		checks = []func() error{metric, duration}
+	case SpecGroupTopK:
+		checks = []func() error{metric, duration, rank, func() error {
+			for _, group := range Groups {
+				if s.Group == group {
+					return nil
+				}
+			}
+			return &ValidationError{Field: prefix + "group", Reason: fmt.Sprintf("%q is not one of %v", s.Group, Groups)}
+		}}
+	case SpecNamed:
+		if s.Query == "" {
+			return &ValidationError{Field: prefix + "query", Reason: "must not be empty"}
+		}
+	default:
+		return &ValidationError{Field: prefix + "type", Reason: fmt.Sprintf("%q is not one of count, topk, groupby, group_topk, named", s.Type)}
+	}
+	for _, check := range checks {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateFilters(field string, filters map[string]string) error {
+	for label := range filters {
+		if !labelNamePattern.MatchString(label) {
+			return &ValidationError{Field: field, Reason: fmt.Sprintf("%q is not a label name", label)}
+		}
+	}
+	return nil
+}
+
+func (m *Meta) validate() error {
+	if m.Name == "" {
+		return &ValidationError{Field: "name", Reason: "must not be empty"}
+	}
+	return nil
+}
+
+// Validate checks the name, spec and filters of q.
+func (q *Query) Validate() error {
+	if err := q.Meta.validate(); err != nil {
+		return err
+	}
+	if err := q.Spec.Validate(); err != nil {
+		return err
+	}
+	return validateFilters("filters", q.Filters)
+}
+
+// Validate checks the name, filters and panels of d.
+func (d *Dashboard) Validate() error {
+	if err := d.Meta.validate(); err != nil {
+		return err
+	}
+	if err := validateFilters("filters", d.Filters); err != nil {
+		return err
+	}
+	if len(d.Panels) > MaxPanels {
+		return &ValidationError{Field: "panels", Reason: fmt.Sprintf("more than %d panels", MaxPanels)}
+	}
+	for i := range d.Panels {
+		p := &d.Panels[i]
+		if err := p.Spec.validate(fmt.Sprintf("panels[%d].spec.", i)); err != nil {
+			return err
+		}
+		if err := validateFilters(fmt.Sprintf("panels[%d].filters", i), p.Filters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Metadata returns the common fields of q.
+func (q *Query) Metadata() *Meta { return &q.Meta }
+
+// Metadata returns the common fields of d.
+func (d *Dashboard) Metadata() *Meta { return &d.Meta }
+
+// Library stores saved queries and dashboards.
+type Library struct {
+	store      Store
+	queries    *Collection[Query, *Query]
+	dashboards *Collection[Dashboard, *Dashboard]
+}
+
+// NewLibrary returns a library backed by store.
+func NewLibrary(store Store) *Library {
+	return &Library{
+		store:      store,
+		queries:    NewCollection[Query](store, BucketQueries),
+		dashboards: NewCollection[Dashboard](store, BucketDashboards),
+	}
+}
+
+// Queries returns the saved queries sorted by name.
+func (l *Library) Queries() ([]*Query, error) {
+	return l.queries.List()
+}
+
+// Query returns the saved query with the ID.
+func (l *Library) Query(id string) (*Query, error) {
+	return l.queries.Get(id)
+}
+
+// CreateQuery validates and stores q with a new ID.
+func (l *Library) CreateQuery(q *Query) error {
+	return l.queries.Create(q)
+}
+
+// UpdateQuery replaces the saved query with the ID of q. A non-empty by must
+// be the owner of the stored query.
+func (l *Library) UpdateQuery(q *Query, by string) error {
+	return l.queries.Update(q, by)
+}
+
+// DeleteQuery removes the saved query with the ID. A non-empty by must be
+// its owner.
+func (l *Library) DeleteQuery(id string, by string) error {
+	return l.queries.Delete(id, by)
+}
+
+// Dashboards returns the dashboards sorted by name.
+func (l *Library) Dashboards() ([]*Dashboard, error) {
+	return l.dashboards.List()
+}
+
+// Dashboard returns the dashboard with the ID.
+func (l *Library) Dashboard(id string) (*Dashboard, error) {
+	return l.dashboards.Get(id)
+}
+
+// CreateDashboard validates and stores d with a new ID.
+func (l *Library) CreateDashboard(d *Dashboard) error {
+	return l.dashboards.Create(d)
+}
+
+// UpdateDashboard replaces the dashboard with the ID of d. A non-empty by
+// must be the owner of the stored dashboard.
+func (l *Library) UpdateDashboard(d *Dashboard, by string) error {
+	return l.dashboards.Update(d, by)
+}
+
+// DeleteDashboard removes the dashboard with the ID. A non-empty by must be
+// its owner.
+func (l *Library) DeleteDashboard(id string, by string) error {
+	return l.dashboards.Delete(id, by)
+}
+
+// Store returns the store of the library, e.g. to keep other resources in
+// a Collection of their own.
+func (l *Library) Store() Store {
+	return l.store
+}
+
+// Close closes the store.
+func (l *Library) Close() error {
+	return l.store.Close()
+}
@@ -0,0 +1,164 @@
+package saved
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testStores(t *testing.T) map[string]Store {
+	bolt, err := OpenBolt(filepath.Join(t.TempDir(), "helios.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+	return map[string]Store{BackendMemory: NewMemoryStore(), BackendBolt: bolt}
+}
+
+func topkQuery(name string) *Query {
+	return &Query{
+		Meta:    Meta{Name: name, Owner: "alice"},
+		Spec:    QuerySpec{Type: SpecTopK, Metric: "ovs_interface_receive_bytes_total", Duration: "5m", Rank: 10},
+		Filters: map[string]string{"tenant": "acme"},
+	}
+}
+
+func TestLibraryQueries(t *testing.T) {
+	for backend, store := range testStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			l := NewLibrary(store)
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			l.queries.now = func() time.Time { return now }
+
+			q := topkQuery("b")
+			if err := l.CreateQuery(q); err != nil {
+				t.Fatal(err)
+			}
+			if q.ID == "" || !q.CreatedAt.Equal(now) {
+				t.Fatalf("expected ID and creation time, got %+v", q.Meta)
+			}
+			if err := l.CreateQuery(topkQuery("a")); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := l.Query(q.ID)
+			if err != nil || !reflect.DeepEqual(got.Spec, q.Spec) || got.Filters["tenant"] != "acme" || got.Owner != "alice" {
+				t.Fatalf("unexpected query %+v %v", got, err)
+			}
+			all, err := l.Queries()
+			if err != nil || len(all) != 2 || all[0].Name != "a" || all[1].Name != "b" {
+				t.Fatalf("unexpected list %+v %v", all, err)
+			}
+
+			// Updates keep the owner and creation time.
+			now = now.Add(time.Hour)
+			next := topkQuery("b2")
+			next.ID = q.ID
+			next.Owner = "mallory"
+			if err := l.UpdateQuery(next, "mallory"); !errors.Is(err, ErrNotOwner) {
+				t.Errorf("expected ErrNotOwner, got %v", err)
+			}
+			if err := l.UpdateQuery(next, "alice"); err != nil {
+				t.Fatal(err)
+			}
+			got, _ = l.Query(q.ID)
+			if got.Name != "b2" || got.Owner != "alice" || !got.CreatedAt.Equal(q.CreatedAt) || !got.UpdatedAt.Equal(now) {
+				t.Errorf("unexpected update %+v", got.Meta)
+			}
+
+			if err := l.DeleteQuery(q.ID, "mallory"); !errors.Is(err, ErrNotOwner) {
+				t.Errorf("expected ErrNotOwner, got %v", err)
+			}
+			if err := l.DeleteQuery(q.ID, ""); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := l.Query(q.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+			if err := l.DeleteQuery(q.ID, ""); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+			missing := topkQuery("c")
+			missing.ID = "missing"
+			if err := l.UpdateQuery(missing, ""); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLibraryDashboards(t *testing.T) {
+	for backend, store := range testStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			l := NewLibrary(store)
+			d := &Dashboard{
+				Meta:    Meta{Name: "noc"},
+				Filters: map[string]string{"bridge": "br-int"},
+				Panels: []Panel{
+					{Title: "ports", Spec: QuerySpec{Type: SpecCount, Metric: "ovs_interface_receive_bytes_total"}},
+					{Title: "switches", Spec: QuerySpec{Type: SpecGroupTopK, Metric: "ovs_interface_receive_bytes_total", Duration: "5m", Rank: 5, Group: "logical_switch"}},
+				},
+			}
+			if err := l.CreateDashboard(d); err != nil {
+				t.Fatal(err)
+			}
+			got, err := l.Dashboard(d.ID)
+			if err != nil || len(got.Panels) != 2 || got.Panels[1].Spec.Group != "logical_switch" {
+				t.Fatalf("unexpected dashboard %+v %v", got, err)
+			}
+			if _, err := l.Query(d.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("dashboards and queries must not share IDs, got %v", err)
+			}
+			if all, err := l.Dashboards(); err != nil || len(all) != 1 {
+				t.Errorf("unexpected list %+v %v", all, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for field, q := range map[string]*Query{
+		"name":          {Spec: QuerySpec{Type: SpecCount, Metric: "up"}},
+		"spec.type":     {Meta: Meta{Name: "x"}, Spec: QuerySpec{Type: "sum"}},
+		"spec.metric":   {Meta: Meta{Name: "x"}, Spec: QuerySpec{Type: SpecCount, Metric: "up{}"}},
+		"spec.duration": {Meta: Meta{Name: "x"}, Spec: QuerySpec{Type: SpecGroupBy, Metric: "up", Duration: "5m])"}},
+		"spec.rank":     {Meta: Meta{Name: "x"}, Spec: QuerySpec{Type: SpecTopK, Metric: "up", Duration: "5m"}},
+		"spec.group":    {Meta: Meta{Name: "x"}, Spec: QuerySpec{Type: SpecGroupTopK, Metric: "up", Duration: "5m", Rank: 1, Group: "port"}},
+		"spec.query":    {Meta: Meta{Name: "x"}, Spec: QuerySpec{Type: SpecNamed}},
+		"filters":       {Meta: Meta{Name: "x"}, Spec: QuerySpec{Type: SpecCount, Metric: "up"}, Filters: map[string]string{"0tenant": "acme"}},
+	} {
+		var verr *ValidationError
+		if err := q.Validate(); !errors.As(err, &verr) || verr.Field != field {
+			t.Errorf("%s: unexpected error %v", field, err)
+		}
+	}
+
+	d := &Dashboard{Meta: Meta{Name: "x"}, Panels: []Panel{{Spec: QuerySpec{Type: SpecCount, Metric: "up"}}, {Spec: QuerySpec{Type: SpecCount}}}}
+	var verr *ValidationError
+	if err := d.Validate(); !errors.As(err, &verr) || verr.Field != "panels[1].spec.metric" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestBoltPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "helios.db")
+	store, err := Open(BackendBolt, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := topkQuery("a")
+	if err := NewLibrary(store).CreateQuery(q); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	if store, err = Open(BackendBolt, path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if got, err := NewLibrary(store).Query(q.ID); err != nil || got.Name != "a" {
+		t.Errorf("expected the query to survive a restart, got %+v %v", got, err)
+	}
+}
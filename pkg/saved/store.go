@@ -0,0 +1,171 @@
+package saved
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store backends
+const (
+	BackendBolt   string = "bolt"
+	BackendMemory string = "memory"
+)
+
+// Store keeps JSON documents by bucket and key. Get and Delete return
+// ErrNotFound for a missing key.
+type Store interface {
+	Get(bucket string, key string) ([]byte, error)
+	// List returns the documents of a bucket ordered by key.
+	List(bucket string) ([][]byte, error)
+	Put(bucket string, key string, value []byte) error
+	Delete(bucket string, key string) error
+	Close() error
+}
+
+// Open returns the store of a backend. Path is the database file of the
+// bolt backend.
+func Open(backend string, path string) (Store, error) {
+	switch backend {
+	case BackendBolt:
+		return OpenBolt(path)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// MemoryStore is a Store that forgets everything on restart
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *MemoryStore) Get(bucket string, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *MemoryStore) List(bucket string) ([][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, append([]byte(nil), s.buckets[bucket][key]...))
+	}
+	return values, nil
+}
+
+func (s *MemoryStore) Put(bucket string, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string][]byte)
+	}
+	s.buckets[bucket][key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *MemoryStore) Delete(bucket string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucket][key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.buckets[bucket], key)
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// BoltStore is a Store in a BoltDB file, one bolt bucket per bucket
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens or creates the database file at path. It fails when
+// another process holds the file for more than a second.
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(bucket string, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrNotFound
+		}
+		v := b.Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		// v is only valid within the transaction.
+		value = append([]byte(nil), v...)
+		return nil
+	})
+	return value, err
+}
+
+func (s *BoltStore) List(bucket string) ([][]byte, error) {
+	var values [][]byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			values = append(values, append([]byte(nil), v...))
+			return nil
+		})
+	})
+	return values, err
+}
+
+func (s *BoltStore) Put(bucket string, key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), value)
+	})
+}
+
+func (s *BoltStore) Delete(bucket string, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil || b.Get([]byte(key)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}